@@ -25,7 +25,11 @@ func main() {
 		fmt.Println("error initializing database:", err)
 		return
 	}
-	defer db.Close()
+	defer func() {
+		if err := db.Close(); err != nil {
+			fmt.Println("error closing database:", err)
+		}
+	}()
 
 	fmt.Println("database initialized")
 
@@ -46,7 +50,8 @@ func main() {
 		if line == "quit" || line == "exit" {
 			break
 		}
-		if strings.HasPrefix(strings.ToLower(line), "select") {
+		lower := strings.ToLower(line)
+		if strings.HasPrefix(lower, "select") || strings.HasPrefix(lower, "explain") {
 			plan, err := db.Planner.CreateQueryPlan(line, tx)
 			if err != nil {
 				fmt.Println("error creating query plan:", err)
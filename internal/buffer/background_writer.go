@@ -0,0 +1,68 @@
+package buffer
+
+import (
+	"sync"
+	"time"
+)
+
+// BackgroundWriter periodically flushes dirty buffers that are not pinned,
+// so that transactions pinning new blocks are less likely to have to wait
+// for a victim buffer to be written first.
+type BackgroundWriter struct {
+	bm       *BufferMgr
+	interval time.Duration
+	batch    int
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	// The error of the most recent flush that failed.
+	err error
+	mu  sync.Mutex
+}
+
+// NewBackgroundWriter creates a writer that, once started, flushes up to
+// batch unpinned dirty buffers every interval. A batch that is not
+// positive flushes all of them.
+func NewBackgroundWriter(bm *BufferMgr, interval time.Duration, batch int) *BackgroundWriter {
+	return &BackgroundWriter{bm: bm, interval: interval, batch: batch}
+}
+
+// Start starts the writer's goroutine.
+func (w *BackgroundWriter) Start() {
+	w.stop = make(chan struct{})
+	w.wg.Add(1)
+	go w.run()
+}
+
+// Stop stops the writer, waiting for any flush in progress to finish.
+// It returns the error of the most recent flush that failed, if any.
+func (w *BackgroundWriter) Stop() error {
+	close(w.stop)
+	w.wg.Wait()
+	return w.Err()
+}
+
+// Err returns the error of the most recent flush that failed, or nil if
+// none has.
+func (w *BackgroundWriter) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *BackgroundWriter) run() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if _, err := w.bm.FlushUnpinned(w.batch); err != nil {
+				w.mu.Lock()
+				w.err = err
+				w.mu.Unlock()
+			}
+		}
+	}
+}
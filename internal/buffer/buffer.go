@@ -4,6 +4,7 @@ import (
 	"errors"
 	"simpledb/internal/file"
 	"simpledb/internal/log"
+	"sync/atomic"
 )
 
 // Buffer is an individual buffer. It wraps a page and stores information about
@@ -17,16 +18,30 @@ type Buffer struct {
 	Blk      file.BlockID
 	pins     int
 	Txnum    int
+	// A copy of Txnum that can be read without pinning the buffer, since
+	// the transaction that has it pinned sets Txnum without a lock.
+	modifiedBy atomic.Int32
 	// The most recent LSN (log sequence number) associated with the buffer,
 	// or -1 if the buffer has not been modified.
 	// We don't need to store all LSNs, since flushing the log with a given LSN
 	// will also write any logs up to that LSN to disk.
 	lsn int
+	// The LSN of the first logged change since the buffer was last
+	// flushed, or 0 if there is none. Recovery must redo the log from
+	// there to restore the buffer's changes. Like modifiedBy, it is read
+	// by checkpoints without pinning the buffer.
+	recLSN atomic.Int64
+	// Whether the buffer holds a block that was prefetched and hasn't been
+	// pinned since.
+	prefetched bool
+	// Closed once a prefetched block has been read into the buffer, or nil
+	// if no read is in progress. The buffer can't be pinned until then.
+	loading chan struct{}
 }
 
 // NewBuffer creates a new Buffer instance.
 func NewBuffer(fm *file.FileMgr, lm *log.LogMgr) *Buffer {
-	return &Buffer{
+	b := &Buffer{
 		fm:       fm,
 		lm:       lm,
 		Contents: file.NewPage(fm.BlockSize),
@@ -35,14 +50,20 @@ func NewBuffer(fm *file.FileMgr, lm *log.LogMgr) *Buffer {
 		Txnum:    -1,
 		lsn:      -1,
 	}
+	b.modifiedBy.Store(-1)
+	return b
 }
 
 // SetModified marks the buffer as modified by the specified transaction and
-// updates the most recent LSN (log sequence number) associated with the buffer.
+// updates the most recent LSN (log sequence number) associated with the buffer,
+// which also becomes the LSN of its page.
 func (b *Buffer) SetModified(txnum int, lsn int) {
 	b.Txnum = txnum
+	b.modifiedBy.Store(int32(txnum))
 	if lsn >= 0 {
 		b.lsn = lsn
+		b.Contents.SetLSN(lsn)
+		b.recLSN.CompareAndSwap(0, int64(lsn))
 	}
 }
 
@@ -51,6 +72,12 @@ func (b *Buffer) IsPinned() bool {
 	return b.pins > 0
 }
 
+// evictable returns true if the buffer is unpinned and doesn't hold a
+// prefetched block that is waiting to be pinned.
+func (b *Buffer) evictable() bool {
+	return !b.IsPinned() && !b.prefetched
+}
+
 // AssignToBlock reads the contents of the specified block into the contents of
 // the buffer. If the buffer was dirty, then its previous contents are first
 // written to disk.
@@ -79,6 +106,8 @@ func (b *Buffer) Flush() error {
 			return err
 		}
 		b.Txnum = -1
+		b.modifiedBy.Store(-1)
+		b.recLSN.Store(0)
 	}
 	return nil
 }
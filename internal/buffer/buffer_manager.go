@@ -1,8 +1,10 @@
 package buffer
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"simpledb/internal/file"
 	"simpledb/internal/log"
 	"sync"
@@ -10,143 +12,275 @@ import (
 )
 
 // BufferMgr manages the pinning and unpinning of buffers to blocks.
+// The buffers may be divided into several partitions, each with its own
+// lock, so that transactions using different blocks don't contend for a
+// single lock. Each block is always buffered in the same partition, chosen
+// by hashing its BlockID; a caller waits only for a buffer in the
+// partition of the block it wants, even if other partitions have
+// available buffers.
 type BufferMgr struct {
-	bufpool      []*Buffer
-	numAvailable int
-	mu           sync.Mutex
+	pools []*bufferPool
+	// Prefetch requests waiting for a prefetcher, the number of
+	// prefetchers running, and the prefetchers still in progress.
+	prefetchQueue [][]file.BlockID
+	prefetchers   int
+	prefetches    sync.WaitGroup
+	prefetchMu    sync.Mutex
+	// The prefetch budget set by SetPrefetchBudget, or -1 to use the
+	// default fraction of the buffers.
+	prefetchBudget int
+	// Serializes calls to Resize and SetPrefetchBudget.
+	mu sync.Mutex
 }
 
 // NewBufferMgr creates a new BufferMgr instance with the given number of
-// buffer slots.
+// buffer slots, which replaces buffers using a NaiveStrategy.
 func NewBufferMgr(fm *file.FileMgr, lm *log.LogMgr, numbufs int) (*BufferMgr, error) {
-	bufpool := make([]*Buffer, numbufs)
-	for i := 0; i < numbufs; i++ {
-		bufpool[i] = NewBuffer(fm, lm)
+	return NewBufferMgrWithStrategy(fm, lm, numbufs, NewNaiveStrategy())
+}
+
+// NewBufferMgrWithStrategy creates a new BufferMgr instance with the given
+// number of buffer slots, which uses the given strategy to choose the
+// buffers to replace.
+func NewBufferMgrWithStrategy(fm *file.FileMgr, lm *log.LogMgr, numbufs int, strategy ReplacementStrategy) (*BufferMgr, error) {
+	if numbufs < 1 {
+		return nil, fmt.Errorf("invalid number of buffers: %d", numbufs)
+	}
+	return &BufferMgr{pools: []*bufferPool{newBufferPool(fm, lm, numbufs, strategy)}, prefetchBudget: -1}, nil
+}
+
+// NewPartitionedBufferMgr creates a new BufferMgr instance whose buffer
+// slots are divided evenly among the given number of partitions.
+// Each partition replaces its buffers using a strategy created by
+// newStrategy.
+func NewPartitionedBufferMgr(fm *file.FileMgr, lm *log.LogMgr, numbufs, numPartitions int, newStrategy func() ReplacementStrategy) (*BufferMgr, error) {
+	if numPartitions < 1 || numPartitions > numbufs {
+		return nil, fmt.Errorf("cannot divide %d buffers into %d partitions", numbufs, numPartitions)
 	}
-	bm := &BufferMgr{
-		bufpool:      bufpool,
-		numAvailable: numbufs,
+	bm := &BufferMgr{pools: make([]*bufferPool, numPartitions), prefetchBudget: -1}
+	for i := range bm.pools {
+		bm.pools[i] = newBufferPool(fm, lm, partitionSize(numbufs, numPartitions, i), newStrategy())
 	}
 	return bm, nil
 }
 
 // Available returns the number of available (i.e. unpinned) buffers.
 func (bm *BufferMgr) Available() int {
-	bm.mu.Lock()
-	a := bm.numAvailable
-	bm.mu.Unlock()
+	a := 0
+	for _, bp := range bm.pools {
+		a += bp.available()
+	}
 	return a
 }
 
 // FlushAll flushes the dirty buffers modified by the specified transaction.
 func (bm *BufferMgr) FlushAll(txnum int) error {
-	bm.mu.Lock()
-	defer bm.mu.Unlock()
-
 	var errs []error
-	for i, b := range bm.bufpool {
-		if b.Txnum == txnum {
-			if err := b.Flush(); err != nil {
-				errs = append(errs, fmt.Errorf("error flushing buffer %d: %w", i, err))
-			}
+	for _, bp := range bm.pools {
+		if err := bp.flushAll(txnum); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	return errors.Join(errs...)
 }
 
-// Unpin unpins the specified data buffer. If its pin count goes to zero, then
-// notify any waiting threads.
-func (bm *BufferMgr) Unpin(b *Buffer) {
-	bm.mu.Lock()
-	defer bm.mu.Unlock()
+// FlushUnpinned flushes up to limit dirty buffers that are not pinned, or
+// all of them if limit is not positive, and returns the number of buffers
+// flushed. As with any flush, the log is first flushed up to each buffer's
+// most recent log record, so the buffers can be written regardless of
+// whether their transactions have committed.
+func (bm *BufferMgr) FlushUnpinned(limit int) (int, error) {
+	total := 0
+	var errs []error
+	for _, bp := range bm.pools {
+		if limit > 0 && total >= limit {
+			break
+		}
+		n, err := bp.flushUnpinned(limit - total)
+		total += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return total, errors.Join(errs...)
+}
 
-	b.Unpin()
-	if !b.IsPinned() {
-		bm.numAvailable++
-		// notifyAll() // TODO: currently the Pin() method does a wait loop, consider revising and using channels
+// OldestDirtyLSN returns the LSN of the oldest logged change that is in a
+// buffer but not yet on disk, or 0 if every logged change has been flushed.
+// Recovery doesn't need to redo any earlier part of the log.
+func (bm *BufferMgr) OldestDirtyLSN() int {
+	oldest := 0
+	for _, bp := range bm.pools {
+		if lsn := bp.oldestDirtyLSN(); lsn > 0 && (oldest == 0 || lsn < oldest) {
+			oldest = lsn
+		}
 	}
+	return oldest
+}
+
+// Unpin unpins the specified data buffer. If its pin count goes to zero, then
+// the longest-waiting caller of Pin is woken.
+func (bm *BufferMgr) Unpin(b *Buffer) {
+	bm.poolFor(b.Blk).unpin(b)
 }
 
 // Pin pins a buffer to the specified block, potentially waiting until a buffer
-// becomes available. If no buffer becomes available within a fixed time period,
+// becomes available. If no buffer becomes available within maxWaitTime,
 // a BufferAbortError error is returned.
 func (bm *BufferMgr) Pin(blk file.BlockID) (*Buffer, error) {
-	bm.mu.Lock()
-	defer bm.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), maxWaitTime)
+	defer cancel()
+	return bm.PinContext(ctx, blk)
+}
+
+// PinContext pins a buffer to the specified block, potentially waiting until
+// a buffer becomes available. Callers that have to wait are served in the
+// order they arrived. If the context is done before a buffer becomes
+// available, a BufferAbortError wrapping the context's error is returned.
+func (bm *BufferMgr) PinContext(ctx context.Context, blk file.BlockID) (*Buffer, error) {
+	return bm.poolFor(blk).pin(ctx, blk)
+}
 
-	start := time.Now()
+// Prefetch reads the specified blocks into unpinned buffers in the
+// background, in order, so that a caller scanning them sequentially doesn't
+// have to wait for each read when it pins them.
+// To avoid evicting the blocks that concurrent transactions are using, the
+// number of buffers holding prefetched blocks that haven't been pinned yet
+// is limited by the prefetch budget; once it is reached, the remaining
+// blocks are not prefetched. Prefetching is best effort, so read errors are
+// ignored; they are reported again when the block is pinned.
+// Requests are served by at most maxPrefetchers goroutines. Once
+// maxQueuedPrefetches requests are waiting for one, further requests are
+// dropped.
+func (bm *BufferMgr) Prefetch(blks []file.BlockID) {
+	bm.prefetchMu.Lock()
+	defer bm.prefetchMu.Unlock()
+	if len(bm.prefetchQueue) >= maxQueuedPrefetches {
+		return
+	}
+	bm.prefetchQueue = append(bm.prefetchQueue, blks)
+	if bm.prefetchers < maxPrefetchers {
+		bm.prefetchers++
+		bm.prefetches.Add(1)
+		go bm.runPrefetcher()
+	}
+}
+
+// runPrefetcher serves prefetch requests until the queue is empty.
+func (bm *BufferMgr) runPrefetcher() {
+	defer bm.prefetches.Done()
 	for {
-		b, err := bm.tryToPin(blk)
-		if err != nil {
-			return nil, err
-		}
-		if b != nil {
-			return b, nil
+		bm.prefetchMu.Lock()
+		if len(bm.prefetchQueue) == 0 {
+			bm.prefetchers--
+			bm.prefetchMu.Unlock()
+			return
 		}
-		if waitingTooLong(start) {
-			return nil, NewBufferAbortError()
+		blks := bm.prefetchQueue[0]
+		bm.prefetchQueue = bm.prefetchQueue[1:]
+		bm.prefetchMu.Unlock()
+
+		for _, blk := range blks {
+			if ok, err := bm.poolFor(blk).prefetch(blk); !ok || err != nil {
+				break
+			}
 		}
-		// wait a short duration before trying again
-		bm.mu.Unlock()
-		time.Sleep(100 * time.Millisecond)
-		bm.mu.Lock()
 	}
 }
 
-// tryToPin tries to pin a buffer to the specified block. If there is already a
-// buffer assigned to that block, then that buffer is used; otherwise, an
-// unpinned buffer from the pool is chosen. Returns nil if there are no
-// available buffers.
-func (bm *BufferMgr) tryToPin(blk file.BlockID) (*Buffer, error) {
-	b := bm.findExistingBuffer(blk)
-	if b == nil {
-		b = bm.chooseUnpinnedBuffer()
-		if b == nil {
-			// there is no existing buffer for this block, nor unpinned buffer available
-			return nil, nil
-		}
-		if err := b.AssignToBlock(blk); err != nil {
-			return nil, err
+// SetPrefetchBudget sets the most buffers that may hold prefetched blocks
+// that haven't been pinned yet. It is divided among the partitions in
+// proportion to their size. By default, the budget is a quarter of the
+// buffers.
+func (bm *BufferMgr) SetPrefetchBudget(n int) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.prefetchBudget = n
+	bm.dividePrefetchBudget()
+}
+
+// dividePrefetchBudget divides the prefetch budget among the partitions.
+func (bm *BufferMgr) dividePrefetchBudget() {
+	numbufs := bm.Size()
+	for _, bp := range bm.pools {
+		n := bp.size() / defaultPrefetchDivisor
+		if bm.prefetchBudget >= 0 {
+			n = bm.prefetchBudget * bp.size() / numbufs
 		}
+		bp.setPrefetchBudget(n)
 	}
+}
 
-	// by this point, b is not nil
-	if !b.IsPinned() {
-		// if it's not pinned, we are the first to pin it, so there's one less buffer available now
-		bm.numAvailable--
+// Size returns the number of buffers in the buffer pool.
+func (bm *BufferMgr) Size() int {
+	n := 0
+	for _, bp := range bm.pools {
+		n += bp.size()
 	}
-	b.Pin()
-	return b, nil
+	return n
 }
 
-// findExistingBuffer returns the buffer assigned to the specified block, or nil
-// if no buffer is assigned to that block.
-func (bm *BufferMgr) findExistingBuffer(blk file.BlockID) *Buffer {
-	for _, b := range bm.bufpool {
-		if b.Blk.Equal(blk) {
-			return b
+// Resize grows or shrinks the buffer pool to the specified number of
+// buffers, which are divided evenly among the partitions.
+// Added buffers can be pinned immediately. When the pool shrinks, only
+// unpinned buffers are removed, after being flushed; buffers that are
+// pinned are removed once they are unpinned, so transactions that are
+// using them are unaffected. Size reports the new size right away.
+func (bm *BufferMgr) Resize(numbufs int) error {
+	if numbufs < len(bm.pools) {
+		return fmt.Errorf("cannot divide %d buffers into %d partitions", numbufs, len(bm.pools))
+	}
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	var errs []error
+	for i, bp := range bm.pools {
+		if err := bp.resize(partitionSize(numbufs, len(bm.pools), i)); err != nil {
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	bm.dividePrefetchBudget()
+	return errors.Join(errs...)
 }
 
-// chooseUnpinnedBuffer returns an unpinned buffer from the buffer pool, or nil
-// if no such buffer exists.
-func (bm *BufferMgr) chooseUnpinnedBuffer() *Buffer {
-	for _, b := range bm.bufpool {
-		if !b.IsPinned() {
-			return b
-		}
-	}
-	return nil
+// WaitPrefetches waits until the prefetches in progress have finished.
+func (bm *BufferMgr) WaitPrefetches() {
+	bm.prefetches.Wait()
 }
 
-// maxWaitTime is the maximum amount of time to wait for a buffer to become
-// available.
-const maxWaitTime = 10 * time.Second
+// poolFor returns the partition that buffers the specified block.
+func (bm *BufferMgr) poolFor(blk file.BlockID) *bufferPool {
+	if len(bm.pools) == 1 {
+		return bm.pools[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(blk.Filename))
+	// Consecutive blocks of a file go to consecutive partitions.
+	i := (uint64(h.Sum32()) + uint64(blk.Blknum)) % uint64(len(bm.pools))
+	return bm.pools[i]
+}
 
-// waitingTooLong returns true if the specified start time is greater than
-// maxWaitTime.
-func waitingTooLong(start time.Time) bool {
-	return time.Since(start) > maxWaitTime
+// partitionSize returns the number of buffers in the i-th partition, when
+// numbufs buffers are divided evenly among numPartitions partitions.
+func partitionSize(numbufs, numPartitions, i int) int {
+	n := numbufs / numPartitions
+	if i < numbufs%numPartitions {
+		n++
+	}
+	return n
 }
+
+// maxPrefetchers is the most goroutines that read prefetched blocks at
+// once, and maxQueuedPrefetches the most prefetch requests that may wait
+// for them.
+const (
+	maxPrefetchers      = 2
+	maxQueuedPrefetches = 16
+)
+
+// defaultPrefetchDivisor is the fraction of the buffers, as a divisor, that
+// may hold prefetched blocks by default.
+const defaultPrefetchDivisor = 4
+
+// maxWaitTime is the maximum amount of time that Pin waits for a buffer to
+// become available.
+const maxWaitTime = 10 * time.Second
@@ -1,11 +1,15 @@
 package buffer_test
 
 import (
+	"context"
+	"errors"
 	"os"
 	"simpledb/internal/buffer"
 	"simpledb/internal/file"
 	"simpledb/internal/server"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestBuffer(t *testing.T) {
@@ -168,3 +172,537 @@ func TestBufferFile(t *testing.T) {
 	}
 	bm.Unpin(b2)
 }
+
+func TestBufferMgrWaiters(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("bufferwaittest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("bufferwaittest", 400, 2) // only 2 buffers
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bm := db.BufferMgr
+	b0, err := bm.Pin(file.NewBlockID("testfile", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1, err := bm.Pin(file.NewBlockID("testfile", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Start two waiters, one after the other.
+	type result struct {
+		id  int
+		buf *buffer.Buffer
+		err error
+	}
+	results := make(chan result)
+	for id := 2; id <= 3; id++ {
+		go func() {
+			b, err := bm.PinContext(context.Background(), file.NewBlockID("testfile", id))
+			results <- result{id, b, err}
+		}()
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Unpinning a buffer wakes the first waiter promptly.
+	start := time.Now()
+	bm.Unpin(b0)
+	r := <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.id != 2 {
+		t.Fatalf("Expected the first waiter to be served first, but got waiter %d", r.id)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the waiter to be woken promptly, but it took %v", elapsed)
+	}
+	select {
+	case r := <-results:
+		t.Fatalf("Expected waiter %d to keep waiting", r.id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	bm.Unpin(b1)
+	r = <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.id != 3 {
+		t.Fatalf("Expected waiter 3, but got waiter %d", r.id)
+	}
+
+	// A cancelled caller stops waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = bm.PinContext(ctx, file.NewBlockID("testfile", 4))
+	var abortErr *buffer.BufferAbortError
+	if !errors.As(err, &abortErr) {
+		t.Fatalf("Expected BufferAbortError, but got %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the error to wrap context.Canceled, but got %v", err)
+	}
+}
+
+func TestPartitionedBufferMgr(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("bufferparttest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("bufferparttest", 400, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const numbufs = 1000
+	bm, err := buffer.NewPartitionedBufferMgr(db.FileMgr, db.LogMgr, numbufs, 4, func() buffer.ReplacementStrategy {
+		return buffer.NewLRUStrategy()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pin and modify blocks from several goroutines at once.
+	const numWorkers, blocksPerWorker = 8, 100
+	var wg sync.WaitGroup
+	errs := make(chan error, numWorkers)
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < blocksPerWorker; i++ {
+				blk := file.NewBlockID("testfile", w*blocksPerWorker+i)
+				b, err := bm.Pin(blk)
+				if err != nil {
+					errs <- err
+					return
+				}
+				b.Contents.SetInt(0, int32(blk.Blknum))
+				b.SetModified(w+1, -1)
+				bm.Unpin(b)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if bm.Available() != numbufs {
+		t.Fatalf("Expected %d available buffers, but got %d", numbufs, bm.Available())
+	}
+
+	// Every block still fits in the pool, so repinning finds the same
+	// buffer with the modified contents.
+	for blknum := 0; blknum < numWorkers*blocksPerWorker; blknum++ {
+		b, err := bm.Pin(file.NewBlockID("testfile", blknum))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := b.Contents.GetInt(0); got != int32(blknum) {
+			t.Fatalf("Expected block %d to contain %d, but got %d", blknum, blknum, got)
+		}
+		bm.Unpin(b)
+	}
+
+	// FlushAll writes the blocks modified by a transaction in every partition.
+	if err := bm.FlushAll(1); err != nil {
+		t.Fatal(err)
+	}
+	p := file.NewPage(db.FileMgr.BlockSize)
+	for blknum := 0; blknum < blocksPerWorker; blknum++ {
+		if err := db.FileMgr.Read(file.NewBlockID("testfile", blknum), p); err != nil {
+			t.Fatal(err)
+		}
+		if got := p.GetInt(0); got != int32(blknum) {
+			t.Fatalf("Expected block %d on disk to contain %d, but got %d", blknum, blknum, got)
+		}
+	}
+}
+
+func TestBufferMgrStats(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("bufferstatstest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("bufferstatstest", 400, 2) // only 2 buffers
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bm := db.BufferMgr
+	pin := func(blknum int) *buffer.Buffer {
+		t.Helper()
+		b, err := bm.Pin(file.NewBlockID("testfile", blknum))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	b0 := pin(0) // miss
+	b0.SetModified(7, -1)
+	b1 := pin(1)     // miss
+	bm.Unpin(pin(0)) // hit
+	bm.Unpin(b0)
+	bm.Unpin(pin(2)) // miss, evicts and flushes block 0
+
+	frames := bm.Frames()
+	if len(frames) != 2 {
+		t.Fatalf("Expected 2 frames, but got %d", len(frames))
+	}
+	for _, f := range frames {
+		if f.Blk == file.NewBlockID("testfile", 1) && (f.Pins != 1 || f.Dirty) {
+			t.Errorf("Expected block 1 to be pinned once and clean, but got %+v", f)
+		}
+		if f.Blk == file.NewBlockID("testfile", 2) && f.Pins != 0 {
+			t.Errorf("Expected block 2 to be unpinned, but got %+v", f)
+		}
+	}
+
+	// With both buffers pinned, pinning block 3 times out.
+	b2 := pin(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := bm.PinContext(ctx, file.NewBlockID("testfile", 3)); err == nil {
+		t.Fatal("Expected BufferAbortError, but got nil")
+	}
+	bm.Unpin(b2)
+	bm.Unpin(b1)
+
+	s := bm.Stats()
+	want := buffer.BufferStats{Pins: 5, Hits: 2, Misses: 3, Evictions: 1, DirtyFlushes: 1, Waits: 1, Aborts: 1}
+	want.PinWait = s.PinWait
+	if s != want {
+		t.Fatalf("Expected stats %+v, but got %+v", want, s)
+	}
+	// The deadline also covers the lookup before the wait starts.
+	if s.PinWait < 15*time.Millisecond {
+		t.Errorf("Expected about 20ms of pin wait time, but got %v", s.PinWait)
+	}
+}
+
+func TestBufferMgrFramesWhileModifying(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("bufferframestest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("bufferframestest", 400, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bm := db.BufferMgr
+	b, err := bm.Pin(file.NewBlockID("testfile", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The transaction with the buffer pinned modifies it without a lock,
+	// while another reads the frames.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			b.SetModified(i%5, -1)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		bm.Frames()
+	}
+	<-done
+
+	for _, f := range bm.Frames() {
+		if f.Blk == b.Blk && (!f.Dirty || f.ModifiedBy != 999%5) {
+			t.Errorf("Expected the buffer to be last modified by transaction %d, but got %+v", 999%5, f)
+		}
+	}
+	bm.Unpin(b)
+}
+
+func TestBackgroundWriter(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("backgroundwritertest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("backgroundwritertest", 400, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bm := db.BufferMgr
+	blk0 := file.NewBlockID("testfile", 0)
+	blk1 := file.NewBlockID("testfile", 1)
+	b0, err := bm.Pin(blk0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1, err := bm.Pin(blk1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b0.Contents.SetInt(0, 123)
+	b0.SetModified(1, -1)
+	b1.Contents.SetInt(0, 456)
+	b1.SetModified(1, -1)
+	bm.Unpin(b0) // b1 stays pinned
+
+	w := buffer.NewBackgroundWriter(bm, time.Millisecond, 0)
+	w.Start()
+	deadline := time.Now().Add(time.Second)
+	for bm.Stats().DirtyFlushes == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := w.Stop(); err != nil {
+		t.Fatalf("Expected the writer to flush without errors, but got %v", err)
+	}
+
+	for _, f := range bm.Frames() {
+		if f.Blk == blk0 && f.Dirty {
+			t.Errorf("Expected unpinned block 0 to be flushed, but got %+v", f)
+		}
+		if f.Blk == blk1 && !f.Dirty {
+			t.Errorf("Expected pinned block 1 to stay dirty, but got %+v", f)
+		}
+	}
+	p := file.NewPage(db.FileMgr.BlockSize)
+	if err := db.FileMgr.Read(blk0, p); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.GetInt(0); n != 123 {
+		t.Errorf("Expected 123 on disk, but got %d", n)
+	}
+	bm.Unpin(b1)
+}
+
+func TestBackgroundWriterError(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("backgroundwritererrortest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("backgroundwritererrortest", 400, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bm := db.BufferMgr
+	b, err := bm.Pin(file.NewBlockID("testfile", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetModified(1, -1)
+	bm.Unpin(b)
+	// Writing the block fails once the files are closed.
+	db.FileMgr.Close()
+
+	w := buffer.NewBackgroundWriter(bm, time.Millisecond, 0)
+	w.Start()
+	deadline := time.Now().Add(time.Second)
+	for w.Err() == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := w.Stop(); err == nil {
+		t.Fatal("Expected Stop to return the flush error, but got nil")
+	}
+}
+
+func TestBufferMgrPrefetch(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("bufferprefetchtest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("bufferprefetchtest", 400, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bm := db.BufferMgr
+	bm.SetPrefetchBudget(3)
+	blks := make([]file.BlockID, 6)
+	for i := range blks {
+		blks[i] = file.NewBlockID("testfile", i)
+	}
+
+	// Only 3 of the blocks fit in the budget.
+	bm.Prefetch(blks)
+	bm.WaitPrefetches()
+	if s := bm.Stats(); s.Prefetches != 3 || s.Pins != 0 {
+		t.Fatalf("Expected 3 prefetches and no pins, but got %+v", s)
+	}
+	if n := bm.Available(); n != 8 {
+		t.Fatalf("Expected prefetched buffers to be unpinned, but %d are available", n)
+	}
+
+	// Pinning a prefetched block frees up room in the budget.
+	b0, err := bm.Pin(blks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm.Prefetch(blks[3:])
+	bm.WaitPrefetches()
+	s := bm.Stats()
+	if s.Hits != 1 || s.Misses != 0 || s.PrefetchHits != 1 {
+		t.Fatalf("Expected the pin to hit a prefetched block, but got %+v", s)
+	}
+	if s.Prefetches != 4 {
+		t.Fatalf("Expected 4 prefetches, but got %d", s.Prefetches)
+	}
+	bm.Unpin(b0)
+
+	// Blocks that are already buffered aren't read again.
+	bm.SetPrefetchBudget(8)
+	bm.Prefetch(blks)
+	bm.WaitPrefetches()
+	if s := bm.Stats(); s.Prefetches != 6 || s.Evictions != 0 {
+		t.Fatalf("Expected 6 prefetches without evictions, but got %+v", s)
+	}
+}
+
+func TestBufferMgrPrefetchWhilePinning(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("bufferprefetchpintest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("bufferprefetchpintest", 400, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bm := db.BufferMgr
+	bm.SetPrefetchBudget(4)
+	blks := make([]file.BlockID, 32)
+	p := file.NewPage(db.FileMgr.BlockSize)
+	for i := range blks {
+		blks[i] = file.NewBlockID("testfile", i)
+		p.SetInt(0, int32(100+i))
+		if err := db.FileMgr.Write(blks[i], p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A pin of a block that is being prefetched waits for the read, and
+	// sees the block's contents.
+	for round := 0; round < 20; round++ {
+		for i := 0; i < len(blks); i += 4 {
+			bm.Prefetch(blks[i : i+4])
+		}
+		for i, blk := range blks {
+			b, err := bm.Pin(blk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := b.Contents.GetInt(0); n != int32(100+i) {
+				t.Fatalf("Expected %d in block %d, but got %d", 100+i, i, n)
+			}
+			bm.Unpin(b)
+		}
+		bm.WaitPrefetches()
+	}
+	if n := bm.Available(); n != 8 {
+		t.Fatalf("Expected every buffer to be unpinned, but %d are available", n)
+	}
+}
+
+func TestBufferMgrResize(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("bufferresizetest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("bufferresizetest", 400, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bm := db.BufferMgr
+	blk := func(i int) file.BlockID {
+		return file.NewBlockID("testfile", i)
+	}
+	var bufs []*buffer.Buffer
+	for i := 0; i < 4; i++ {
+		b, err := bm.Pin(blk(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Contents.SetInt(0, int32(100+i))
+		b.SetModified(1, -1)
+		bufs = append(bufs, b)
+	}
+	bm.Unpin(bufs[0])
+	bm.Unpin(bufs[1])
+
+	// Shrinking removes the two unpinned buffers, after flushing them.
+	if err := bm.Resize(1); err != nil {
+		t.Fatal(err)
+	}
+	if n := bm.Size(); n != 1 {
+		t.Fatalf("Expected 1 buffer, but got %d", n)
+	}
+	if n := len(bm.Frames()); n != 2 {
+		t.Fatalf("Expected the 2 pinned buffers to remain, but got %d frames", n)
+	}
+	p := file.NewPage(db.FileMgr.BlockSize)
+	for i := 0; i < 2; i++ {
+		if err := db.FileMgr.Read(blk(i), p); err != nil {
+			t.Fatal(err)
+		}
+		if n := p.GetInt(0); n != int32(100+i) {
+			t.Errorf("Expected block %d to be flushed with %d, but got %d", i, 100+i, n)
+		}
+	}
+
+	// The pinned buffers are still usable, and one of them is removed once
+	// it is unpinned.
+	if n := bufs[2].Contents.GetInt(0); n != 102 {
+		t.Errorf("Expected pinned buffer to hold 102, but got %d", n)
+	}
+	bm.Unpin(bufs[2])
+	if n := len(bm.Frames()); n != 1 {
+		t.Fatalf("Expected 1 frame after unpinning, but got %d", n)
+	}
+	if n := bm.Available(); n != 0 {
+		t.Fatalf("Expected no available buffers, but got %d", n)
+	}
+
+	// A caller waiting for a buffer is woken when the pool grows.
+	done := make(chan error)
+	go func() {
+		b, err := bm.Pin(blk(5))
+		if err == nil {
+			bm.Unpin(b)
+		}
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := bm.Resize(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Expected waiting pin to succeed, but got %v", err)
+	}
+	bm.Unpin(bufs[3])
+	if n := bm.Size(); n != 3 {
+		t.Fatalf("Expected 3 buffers, but got %d", n)
+	}
+	if n := bm.Available(); n != 3 {
+		t.Fatalf("Expected 3 available buffers, but got %d", n)
+	}
+	if err := bm.Resize(0); err == nil {
+		t.Fatal("Expected an error resizing to 0 buffers")
+	}
+}
@@ -0,0 +1,523 @@
+package buffer
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"simpledb/internal/file"
+	"simpledb/internal/log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// bufferPool is one partition of the buffers managed by a BufferMgr.
+// Each block is always buffered in the same pool, so pools can pin and
+// unpin their buffers independently, under their own locks.
+type bufferPool struct {
+	fm      *file.FileMgr
+	lm      *log.LogMgr
+	bufpool []*Buffer
+	// The buffers assigned to each block.
+	blocks map[file.BlockID]*Buffer
+	// The buffers that have never been assigned to a block.
+	free         []*Buffer
+	numAvailable int
+	strategy     ReplacementStrategy
+	// Callers waiting for a buffer to become available, in arrival order.
+	// Each is represented by a channel that is closed to wake it.
+	waiters list.List
+	// The number of waiters that have been woken but have not yet tried
+	// to pin a buffer. An available buffer is reserved for each of them.
+	woken int
+	// The number of buffers holding prefetched blocks that haven't been
+	// pinned yet, and the most there may be.
+	prefetched     int
+	prefetchBudget int
+	// The number of buffers still to be removed from a pool that is
+	// shrinking. They are removed as soon as they are unpinned.
+	retiring int
+	stats    BufferStats
+	mu       sync.Mutex
+}
+
+// newBufferPool creates a new pool with the given number of buffers.
+func newBufferPool(fm *file.FileMgr, lm *log.LogMgr, numbufs int, strategy ReplacementStrategy) *bufferPool {
+	bp := &bufferPool{
+		fm:             fm,
+		lm:             lm,
+		bufpool:        make([]*Buffer, numbufs),
+		blocks:         make(map[file.BlockID]*Buffer, numbufs),
+		free:           make([]*Buffer, numbufs),
+		numAvailable:   numbufs,
+		strategy:       strategy,
+		prefetchBudget: numbufs / defaultPrefetchDivisor,
+	}
+	for i := 0; i < numbufs; i++ {
+		bp.bufpool[i] = NewBuffer(fm, lm)
+		// Hand out the free buffers in pool order.
+		bp.free[numbufs-1-i] = bp.bufpool[i]
+	}
+	return bp
+}
+
+// available returns the number of unpinned buffers in the pool.
+func (bp *bufferPool) available() int {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return bp.numAvailable
+}
+
+// flushAll flushes the dirty buffers modified by the specified transaction.
+func (bp *bufferPool) flushAll(txnum int) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	var errs []error
+	for i, b := range bp.bufpool {
+		if b.Txnum == txnum {
+			dirty := b.Txnum >= 0
+			if err := b.Flush(); err != nil {
+				errs = append(errs, fmt.Errorf("error flushing buffer %d: %w", i, err))
+			} else if dirty {
+				bp.stats.DirtyFlushes++
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// flushUnpinned flushes up to limit dirty buffers that are not pinned,
+// or all of them if limit is not positive. It returns the number of
+// buffers flushed.
+func (bp *bufferPool) flushUnpinned(limit int) (int, error) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	n := 0
+	var errs []error
+	for i, b := range bp.bufpool {
+		if limit > 0 && n >= limit {
+			break
+		}
+		if b.IsPinned() || b.Txnum < 0 {
+			continue
+		}
+		if err := b.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("error flushing buffer %d: %w", i, err))
+			continue
+		}
+		bp.stats.DirtyFlushes++
+		n++
+	}
+	return n, errors.Join(errs...)
+}
+
+// oldestDirtyLSN returns the oldest LSN that a dirty buffer in the pool
+// needs redone, or 0 if there is none.
+func (bp *bufferPool) oldestDirtyLSN() int {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	oldest := 0
+	for _, b := range bp.bufpool {
+		// A pinned buffer may be modified concurrently, so read the
+		// copies that can be read without a lock.
+		recLSN := int(b.recLSN.Load())
+		if b.modifiedBy.Load() >= 0 && recLSN > 0 && (oldest == 0 || recLSN < oldest) {
+			oldest = recLSN
+		}
+	}
+	return oldest
+}
+
+// unpin unpins the specified buffer. If its pin count goes to zero, then
+// the longest-waiting caller of pin is woken.
+func (bp *bufferPool) unpin(b *Buffer) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	b.Unpin()
+	if !b.IsPinned() {
+		bp.numAvailable++
+		bp.strategy.Unpinned(b)
+		if bp.retiring > 0 {
+			// If the buffer can't be flushed, it stays in the pool
+			// until it is unpinned again.
+			_ = bp.retire(b)
+		}
+		bp.wakeNext()
+	}
+}
+
+// pin pins a buffer to the specified block, waiting until a buffer becomes
+// available if necessary. Callers that have to wait are served in the
+// order they arrived. If the context is done before a buffer becomes
+// available, a BufferAbortError wrapping the context's error is returned.
+func (bp *bufferPool) pin(ctx context.Context, blk file.BlockID) (*Buffer, error) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	// Don't overtake the callers that are already waiting, unless the block
+	// is pinned already and so pinning it doesn't use up a buffer.
+	bp.waitForRead(blk)
+	if bp.numAvailable > bp.woken+bp.waiters.Len() || bp.isPinned(blk) {
+		b, err := bp.tryToPin(blk)
+		if err != nil || b != nil {
+			return b, err
+		}
+	}
+
+	start := time.Now()
+	bp.stats.Waits++
+	defer func() {
+		bp.stats.PinWait += time.Since(start)
+	}()
+	requeue := false
+	for {
+		ready := make(chan struct{})
+		var e *list.Element
+		if requeue {
+			// Keep our place at the head of the queue.
+			e = bp.waiters.PushFront(ready)
+		} else {
+			e = bp.waiters.PushBack(ready)
+		}
+		bp.mu.Unlock()
+		select {
+		case <-ready:
+			bp.mu.Lock()
+			bp.woken--
+		case <-ctx.Done():
+			bp.mu.Lock()
+			select {
+			case <-ready:
+				// We were woken just as we gave up, so pass the buffer on.
+				bp.woken--
+				bp.wakeNext()
+			default:
+				bp.waiters.Remove(e)
+			}
+			bp.stats.Aborts++
+			return nil, NewBufferAbortError(ctx.Err())
+		}
+
+		bp.waitForRead(blk)
+		b, err := bp.tryToPin(blk)
+		if err != nil {
+			bp.wakeNext()
+			return nil, err
+		}
+		if b != nil {
+			// Other buffers may have become available while we waited.
+			bp.wakeNext()
+			return b, nil
+		}
+		requeue = true
+	}
+}
+
+// wakeNext wakes the longest-waiting caller of pin, if there is an available
+// buffer that hasn't been reserved for another woken caller.
+func (bp *bufferPool) wakeNext() {
+	if bp.numAvailable > bp.woken && bp.waiters.Len() > 0 {
+		e := bp.waiters.Front()
+		bp.waiters.Remove(e)
+		close(e.Value.(chan struct{}))
+		bp.woken++
+	}
+}
+
+// waitForRead waits until the specified block has been read into its
+// buffer, if it is being prefetched. The lock is released while waiting.
+func (bp *bufferPool) waitForRead(blk file.BlockID) {
+	for {
+		b, ok := bp.blocks[blk]
+		if !ok || b.loading == nil {
+			return
+		}
+		loading := b.loading
+		bp.mu.Unlock()
+		<-loading
+		bp.mu.Lock()
+	}
+}
+
+// isPinned returns true if the specified block is assigned to a pinned buffer.
+func (bp *bufferPool) isPinned(blk file.BlockID) bool {
+	b, ok := bp.blocks[blk]
+	return ok && b.IsPinned()
+}
+
+// tryToPin tries to pin a buffer to the specified block. If there is already a
+// buffer assigned to that block, then that buffer is used; otherwise, an
+// unpinned buffer from the pool is chosen. Returns nil if there are no
+// available buffers.
+func (bp *bufferPool) tryToPin(blk file.BlockID) (*Buffer, error) {
+	b, ok := bp.blocks[blk]
+	if ok {
+		bp.stats.Hits++
+		if b.prefetched {
+			b.prefetched = false
+			bp.prefetched--
+			bp.stats.PrefetchHits++
+		}
+	} else {
+		b = bp.chooseUnpinnedBuffer()
+		if b == nil {
+			// there is no existing buffer for this block, nor unpinned buffer available
+			return nil, nil
+		}
+		if err := bp.assign(b, blk); err != nil {
+			return nil, err
+		}
+		bp.stats.Misses++
+	}
+
+	// by this point, b is not nil
+	if !b.IsPinned() {
+		// if it's not pinned, we are the first to pin it, so there's one less buffer available now
+		bp.numAvailable--
+	}
+	b.Pin()
+	bp.stats.Pins++
+	bp.strategy.Pinned(b)
+	return b, nil
+}
+
+// assign assigns the buffer to the specified block, keeping the block map
+// up to date. If the block can't be read, the buffer is returned to the
+// free list.
+func (bp *bufferPool) assign(b *Buffer, blk file.BlockID) error {
+	if b.prefetched {
+		// The prefetched block was never used.
+		b.prefetched = false
+		bp.prefetched--
+	}
+	if b.Blk != (file.BlockID{}) {
+		delete(bp.blocks, b.Blk)
+		bp.stats.Evictions++
+	}
+	dirty := b.Txnum >= 0
+	if err := b.AssignToBlock(blk); err != nil {
+		if b.Txnum < 0 {
+			b.Blk = file.BlockID{}
+			bp.free = append(bp.free, b)
+		} else {
+			// The old contents couldn't be flushed, so keep them.
+			bp.blocks[b.Blk] = b
+		}
+		return err
+	}
+	if dirty {
+		bp.stats.DirtyFlushes++
+	}
+	bp.blocks[blk] = b
+	bp.strategy.Assigned(b)
+	return nil
+}
+
+// prefetch reads the specified block into an unpinned buffer, unless the
+// block is already buffered. It returns false without reading the block if
+// the pool's prefetch budget is used up or all of its buffers are pinned.
+// The buffer is chosen, and its old contents flushed, under the pool's
+// lock, so that neither block can be read from disk in between. The block
+// is read after releasing the lock; callers that pin it meanwhile wait for
+// the read.
+func (bp *bufferPool) prefetch(blk file.BlockID) (bool, error) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	if _, ok := bp.blocks[blk]; ok {
+		return true, nil
+	}
+	if bp.prefetched >= bp.prefetchBudget {
+		return false, nil
+	}
+	// Never replace another prefetched block.
+	numFree := len(bp.free)
+	b := bp.chooseEvictableBuffer()
+	if b == nil {
+		return false, nil
+	}
+	fromFree := len(bp.free) < numFree
+	dirty := b.Txnum >= 0
+	if err := b.Flush(); err != nil {
+		return false, err
+	}
+	if dirty {
+		bp.stats.DirtyFlushes++
+	}
+	if b.Blk != (file.BlockID{}) {
+		delete(bp.blocks, b.Blk)
+		bp.stats.Evictions++
+	}
+	b.Blk = blk
+	b.prefetched = true
+	b.loading = make(chan struct{})
+	bp.prefetched++
+	bp.blocks[blk] = b
+	bp.strategy.Assigned(b)
+
+	bp.mu.Unlock()
+	err := bp.fm.Read(blk, b.Contents)
+	bp.mu.Lock()
+	close(b.loading)
+	b.loading = nil
+	if err != nil {
+		// A buffer replaced by the strategy stays unassigned until the
+		// strategy chooses it again.
+		delete(bp.blocks, blk)
+		b.Blk = file.BlockID{}
+		b.prefetched = false
+		bp.prefetched--
+		if fromFree {
+			bp.free = append(bp.free, b)
+		}
+		return false, err
+	}
+	bp.stats.Prefetches++
+	return true, nil
+}
+
+// setPrefetchBudget sets the most buffers that may hold prefetched blocks
+// that haven't been pinned yet.
+func (bp *bufferPool) setPrefetchBudget(n int) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.prefetchBudget = n
+}
+
+// chooseUnpinnedBuffer returns a buffer from the free list, or else an
+// unpinned buffer chosen by the replacement strategy. If every other
+// unpinned buffer holds a prefetched block that hasn't been pinned yet, one
+// of those is returned, unless the block is still being read. It returns
+// nil if no such buffer exists.
+func (bp *bufferPool) chooseUnpinnedBuffer() *Buffer {
+	if b := bp.chooseEvictableBuffer(); b != nil {
+		return b
+	}
+	if bp.prefetched > 0 {
+		for _, b := range bp.bufpool {
+			if !b.IsPinned() && b.loading == nil {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+// chooseEvictableBuffer returns a buffer from the free list, or else an
+// unpinned buffer chosen by the replacement strategy, or nil if there is
+// no such buffer.
+func (bp *bufferPool) chooseEvictableBuffer() *Buffer {
+	if n := len(bp.free); n > 0 {
+		b := bp.free[n-1]
+		bp.free = bp.free[:n-1]
+		return b
+	}
+	return bp.strategy.ChooseVictim(bp.bufpool)
+}
+
+// size returns the number of buffers in the pool, not counting those that
+// are waiting to be removed.
+func (bp *bufferPool) size() int {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return len(bp.bufpool) - bp.retiring
+}
+
+// resize grows or shrinks the pool to the specified number of buffers.
+// New buffers are available immediately. When shrinking, unpinned buffers
+// are flushed and removed right away, and pinned buffers are removed once
+// they are unpinned, so callers that have them pinned are unaffected.
+func (bp *bufferPool) resize(numbufs int) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	diff := numbufs - (len(bp.bufpool) - bp.retiring)
+	if diff > 0 {
+		// Cancel a pending shrink before adding buffers.
+		cancelled := min(diff, bp.retiring)
+		bp.retiring -= cancelled
+		for range diff - cancelled {
+			b := NewBuffer(bp.fm, bp.lm)
+			bp.bufpool = append(bp.bufpool, b)
+			bp.free = append(bp.free, b)
+			bp.numAvailable++
+		}
+		for bp.numAvailable > bp.woken && bp.waiters.Len() > 0 {
+			bp.wakeNext()
+		}
+		return nil
+	}
+
+	bp.retiring -= diff
+	// Don't take the buffers reserved for callers that have been woken.
+	for bp.retiring > 0 && bp.numAvailable > bp.woken {
+		b := bp.chooseUnpinnedBuffer()
+		if b == nil {
+			break
+		}
+		if err := bp.retire(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retire flushes the specified unpinned buffer and removes it from the
+// pool. If the buffer can't be flushed, it is kept.
+func (bp *bufferPool) retire(b *Buffer) error {
+	if b.Blk == (file.BlockID{}) {
+		// The buffer may have just been taken off the free list; make
+		// sure it's not on it.
+		bp.free = slices.DeleteFunc(bp.free, func(fb *Buffer) bool { return fb == b })
+	} else {
+		dirty := b.Txnum >= 0
+		if err := b.Flush(); err != nil {
+			return fmt.Errorf("error flushing retired buffer: %w", err)
+		}
+		if dirty {
+			bp.stats.DirtyFlushes++
+		}
+		delete(bp.blocks, b.Blk)
+	}
+	if b.prefetched {
+		b.prefetched = false
+		bp.prefetched--
+	}
+	bp.bufpool = slices.DeleteFunc(bp.bufpool, func(pb *Buffer) bool { return pb == b })
+	bp.numAvailable--
+	bp.retiring--
+	bp.strategy.Removed(b)
+	return nil
+}
+
+// frames returns a description of each buffer in the pool.
+func (bp *bufferPool) frames() []FrameInfo {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	frames := make([]FrameInfo, len(bp.bufpool))
+	for i, b := range bp.bufpool {
+		// A pinned buffer may be modified concurrently, so read the copy
+		// of its modifier.
+		modifiedBy := int(b.modifiedBy.Load())
+		frames[i] = FrameInfo{
+			Blk:        b.Blk,
+			Pins:       b.pins,
+			Dirty:      modifiedBy >= 0,
+			ModifiedBy: modifiedBy,
+		}
+	}
+	return frames
+}
+
+// snapshot returns a copy of the pool's counters.
+func (bp *bufferPool) snapshot() BufferStats {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return bp.stats
+}
@@ -0,0 +1,88 @@
+package buffer
+
+import (
+	"simpledb/internal/file"
+	"time"
+)
+
+// BufferStats is a snapshot of the counters kept by a BufferMgr since it
+// was created.
+type BufferStats struct {
+	// Pins is the number of successful calls to Pin.
+	Pins int64
+	// Hits is the number of pins whose block was already in a buffer.
+	Hits int64
+	// Misses is the number of pins whose block had to be read into a buffer.
+	Misses int64
+	// Evictions is the number of misses that replaced another block.
+	Evictions int64
+	// DirtyFlushes is the number of modified buffers written to disk.
+	DirtyFlushes int64
+	// Waits is the number of calls to Pin that had to wait for a buffer.
+	Waits int64
+	// PinWait is the total time that calls to Pin spent waiting.
+	PinWait time.Duration
+	// Aborts is the number of calls to Pin that returned a BufferAbortError.
+	Aborts int64
+	// Prefetches is the number of blocks read ahead by Prefetch.
+	Prefetches int64
+	// PrefetchHits is the number of pins whose block had been prefetched.
+	PrefetchHits int64
+}
+
+// HitRatio returns the fraction of pins whose block was already in a
+// buffer, or 0 if there have been no pins.
+func (s BufferStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// add adds the counters of another snapshot to this one.
+func (s *BufferStats) add(o BufferStats) {
+	s.Pins += o.Pins
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.Evictions += o.Evictions
+	s.DirtyFlushes += o.DirtyFlushes
+	s.Waits += o.Waits
+	s.PinWait += o.PinWait
+	s.Aborts += o.Aborts
+	s.Prefetches += o.Prefetches
+	s.PrefetchHits += o.PrefetchHits
+}
+
+// FrameInfo describes the state of one buffer in the buffer pool.
+type FrameInfo struct {
+	// Blk is the block assigned to the buffer, or the zero BlockID if the
+	// buffer has never been assigned a block.
+	Blk file.BlockID
+	// Pins is the buffer's pin count.
+	Pins int
+	// Dirty is true if the buffer has been modified since it was last
+	// written to disk.
+	Dirty bool
+	// ModifiedBy is the number of the transaction that last modified the
+	// buffer, or -1 if it is not dirty. That transaction doesn't
+	// necessarily still have the buffer pinned.
+	ModifiedBy int
+}
+
+// Stats returns a snapshot of the buffer manager's counters.
+func (bm *BufferMgr) Stats() BufferStats {
+	var s BufferStats
+	for _, bp := range bm.pools {
+		s.add(bp.snapshot())
+	}
+	return s
+}
+
+// Frames returns a description of each buffer in the buffer pool.
+func (bm *BufferMgr) Frames() []FrameInfo {
+	var frames []FrameInfo
+	for _, bp := range bm.pools {
+		frames = append(frames, bp.frames()...)
+	}
+	return frames
+}
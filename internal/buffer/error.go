@@ -2,14 +2,23 @@ package buffer
 
 // BufferAbortError represents an error indicating that a buffer request could
 // not be satisfied and the transaction needs to abort.
-type BufferAbortError struct{}
+type BufferAbortError struct {
+	// Cause is the reason the request stopped waiting for a buffer, such as
+	// the expiry or cancellation of its context, or nil if unknown.
+	Cause error
+}
 
 // Error implements the error interface for BufferAbortError.
 func (e *BufferAbortError) Error() string {
 	return "no available buffers"
 }
 
-// NewBufferAbortError creates a new BufferAbortError.
-func NewBufferAbortError() *BufferAbortError {
-	return &BufferAbortError{}
+// Unwrap returns the cause of the error.
+func (e *BufferAbortError) Unwrap() error {
+	return e.Cause
+}
+
+// NewBufferAbortError creates a new BufferAbortError with the specified cause.
+func NewBufferAbortError(cause error) *BufferAbortError {
+	return &BufferAbortError{Cause: cause}
 }
@@ -0,0 +1,377 @@
+package buffer
+
+import (
+	"cmp"
+	"container/heap"
+	"container/list"
+	"simpledb/internal/file"
+	"slices"
+)
+
+// ReplacementStrategy decides which buffer to replace when a block that is
+// not in the buffer pool needs to be pinned.
+// The buffer manager calls its methods while holding its lock, so
+// implementations need not be safe for concurrent use.
+type ReplacementStrategy interface {
+	// Assigned is called when a buffer is assigned to a new block.
+	Assigned(b *Buffer)
+
+	// Pinned is called each time a buffer is pinned, after it has been
+	// assigned to the block if necessary.
+	Pinned(b *Buffer)
+
+	// Unpinned is called when a buffer's pin count drops to zero.
+	Unpinned(b *Buffer)
+
+	// Removed is called when an unpinned buffer is removed from a buffer
+	// pool that is shrinking.
+	Removed(b *Buffer)
+
+	// ChooseVictim returns an unpinned buffer from the buffer pool to be
+	// replaced, or nil if every buffer is pinned. The strategies in this
+	// package also pass over buffers holding prefetched blocks that haven't
+	// been pinned yet; the buffer manager replaces those only as a last
+	// resort.
+	// It is called on every miss in a warm buffer pool, so the strategies
+	// that order the buffers keep the unpinned ones in a list or heap,
+	// rather than scanning the whole pool.
+	ChooseVictim(bufpool []*Buffer) *Buffer
+}
+
+// NaiveStrategy replaces the first unpinned buffer in the buffer pool.
+// Finding it takes a scan past the pinned buffers at the start of the pool.
+type NaiveStrategy struct{}
+
+var _ ReplacementStrategy = (*NaiveStrategy)(nil)
+
+// NewNaiveStrategy creates a new NaiveStrategy.
+func NewNaiveStrategy() *NaiveStrategy {
+	return &NaiveStrategy{}
+}
+
+func (s *NaiveStrategy) Assigned(b *Buffer) {}
+
+func (s *NaiveStrategy) Pinned(b *Buffer) {}
+
+func (s *NaiveStrategy) Unpinned(b *Buffer) {}
+
+func (s *NaiveStrategy) Removed(b *Buffer) {}
+
+func (s *NaiveStrategy) ChooseVictim(bufpool []*Buffer) *Buffer {
+	for _, b := range bufpool {
+		if b.evictable() {
+			return b
+		}
+	}
+	return nil
+}
+
+// FIFOStrategy replaces the unpinned buffer that was assigned to its block
+// the longest time ago.
+type FIFOStrategy struct {
+	clock    uint64
+	assigned map[*Buffer]uint64
+	unpinned unpinnedHeap
+}
+
+var _ ReplacementStrategy = (*FIFOStrategy)(nil)
+
+// NewFIFOStrategy creates a new FIFOStrategy.
+func NewFIFOStrategy() *FIFOStrategy {
+	return &FIFOStrategy{assigned: make(map[*Buffer]uint64)}
+}
+
+func (s *FIFOStrategy) Assigned(b *Buffer) {
+	s.clock++
+	s.assigned[b] = s.clock
+	// A prefetched block is assigned to an unpinned buffer.
+	s.unpinned.update(b, s.clock, 0)
+}
+
+func (s *FIFOStrategy) Pinned(b *Buffer) {
+	s.unpinned.remove(b)
+}
+
+func (s *FIFOStrategy) Unpinned(b *Buffer) {
+	s.unpinned.add(b, s.assigned[b], 0)
+}
+
+func (s *FIFOStrategy) Removed(b *Buffer) {
+	delete(s.assigned, b)
+	s.unpinned.remove(b)
+}
+
+func (s *FIFOStrategy) ChooseVictim(bufpool []*Buffer) *Buffer {
+	return s.unpinned.victim()
+}
+
+// LRUStrategy replaces the unpinned buffer that was unpinned the longest
+// time ago. The unpinned buffers are kept in a list, least recently
+// unpinned first.
+type LRUStrategy struct {
+	unpinned list.List
+	elements map[*Buffer]*list.Element
+}
+
+var _ ReplacementStrategy = (*LRUStrategy)(nil)
+
+// NewLRUStrategy creates a new LRUStrategy.
+func NewLRUStrategy() *LRUStrategy {
+	return &LRUStrategy{elements: make(map[*Buffer]*list.Element)}
+}
+
+func (s *LRUStrategy) Assigned(b *Buffer) {}
+
+func (s *LRUStrategy) Pinned(b *Buffer) {
+	s.Removed(b)
+}
+
+func (s *LRUStrategy) Unpinned(b *Buffer) {
+	s.elements[b] = s.unpinned.PushBack(b)
+}
+
+func (s *LRUStrategy) Removed(b *Buffer) {
+	if e, ok := s.elements[b]; ok {
+		s.unpinned.Remove(e)
+		delete(s.elements, b)
+	}
+}
+
+func (s *LRUStrategy) ChooseVictim(bufpool []*Buffer) *Buffer {
+	for e := s.unpinned.Front(); e != nil; e = e.Next() {
+		if b := e.Value.(*Buffer); b.evictable() {
+			return b
+		}
+	}
+	return nil
+}
+
+// unpinnedHeap holds unpinned buffers, ordered by a pair of keys, so that
+// the one with the smallest keys can be found without scanning the buffer
+// pool.
+type unpinnedHeap struct {
+	items []heapItem
+	index map[*Buffer]int
+}
+
+type heapItem struct {
+	b          *Buffer
+	key1, key2 uint64
+}
+
+func (h *unpinnedHeap) Len() int { return len(h.items) }
+
+func (h *unpinnedHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	return a.key1 < b.key1 || (a.key1 == b.key1 && a.key2 < b.key2)
+}
+
+func (h *unpinnedHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].b] = i
+	h.index[h.items[j].b] = j
+}
+
+func (h *unpinnedHeap) Push(x any) {
+	item := x.(heapItem)
+	h.index[item.b] = len(h.items)
+	h.items = append(h.items, item)
+}
+
+func (h *unpinnedHeap) Pop() any {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.index, item.b)
+	return item
+}
+
+// add adds an unpinned buffer to the heap with the specified keys.
+func (h *unpinnedHeap) add(b *Buffer, key1, key2 uint64) {
+	if h.index == nil {
+		h.index = make(map[*Buffer]int)
+	}
+	if _, ok := h.index[b]; ok {
+		h.update(b, key1, key2)
+		return
+	}
+	heap.Push(h, heapItem{b, key1, key2})
+}
+
+// update changes the keys of a buffer, if it is in the heap.
+func (h *unpinnedHeap) update(b *Buffer, key1, key2 uint64) {
+	if i, ok := h.index[b]; ok {
+		h.items[i].key1, h.items[i].key2 = key1, key2
+		heap.Fix(h, i)
+	}
+}
+
+// remove removes a buffer from the heap, if it is there.
+func (h *unpinnedHeap) remove(b *Buffer) {
+	if i, ok := h.index[b]; ok {
+		heap.Remove(h, i)
+	}
+}
+
+// victim returns the evictable buffer with the smallest keys, or nil if
+// there is none. The buffers holding prefetched blocks are passed over.
+func (h *unpinnedHeap) victim() *Buffer {
+	var skipped []heapItem
+	var victim *Buffer
+	for h.Len() > 0 {
+		item := heap.Pop(h).(heapItem)
+		skipped = append(skipped, item)
+		if item.b.evictable() {
+			victim = item.b
+			break
+		}
+	}
+	for _, item := range skipped {
+		heap.Push(h, item)
+	}
+	return victim
+}
+
+// ClockStrategy approximates LRU by sweeping a clock hand around the buffer
+// pool. Each buffer has a reference bit that is set when it is pinned; the
+// hand clears the bits it passes, and replaces the first unpinned buffer
+// whose bit is already clear.
+type ClockStrategy struct {
+	hand       int
+	referenced map[*Buffer]bool
+}
+
+var _ ReplacementStrategy = (*ClockStrategy)(nil)
+
+// NewClockStrategy creates a new ClockStrategy.
+func NewClockStrategy() *ClockStrategy {
+	return &ClockStrategy{referenced: make(map[*Buffer]bool)}
+}
+
+func (s *ClockStrategy) Assigned(b *Buffer) {}
+
+func (s *ClockStrategy) Pinned(b *Buffer) {
+	s.referenced[b] = true
+}
+
+func (s *ClockStrategy) Unpinned(b *Buffer) {}
+
+func (s *ClockStrategy) Removed(b *Buffer) {
+	delete(s.referenced, b)
+}
+
+func (s *ClockStrategy) ChooseVictim(bufpool []*Buffer) *Buffer {
+	n := len(bufpool)
+	// Two sweeps are enough: the first clears every reference bit.
+	for i := 0; i < 2*n; i++ {
+		b := bufpool[s.hand%n]
+		s.hand = (s.hand + 1) % n
+		if !b.evictable() {
+			continue
+		}
+		if s.referenced[b] {
+			s.referenced[b] = false
+			continue
+		}
+		return b
+	}
+	return nil
+}
+
+// LRUKStrategy implements the LRU-K algorithm. It replaces the unpinned
+// buffer whose K-th most recent access is the oldest, so that blocks that
+// are accessed once, such as those read by a table scan, are replaced
+// before blocks that are accessed repeatedly. Blocks with fewer than K
+// accesses are replaced first, least recently used first.
+// The access history of a block is kept for a while after it leaves the
+// buffer pool, so that a block that is read again soon is recognized.
+type LRUKStrategy struct {
+	k        int
+	clock    uint64
+	history  map[file.BlockID][]uint64
+	unpinned unpinnedHeap
+}
+
+var _ ReplacementStrategy = (*LRUKStrategy)(nil)
+
+// historyFactor bounds the number of blocks whose access history an
+// LRUKStrategy keeps, as a multiple of the size of the buffer pool.
+const historyFactor = 4
+
+// NewLRUKStrategy creates a new LRUKStrategy that considers the K most
+// recent accesses of each block. LRU-2 is the usual choice.
+func NewLRUKStrategy(k int) *LRUKStrategy {
+	return &LRUKStrategy{k: max(1, k), history: make(map[file.BlockID][]uint64)}
+}
+
+func (s *LRUKStrategy) Assigned(b *Buffer) {
+	// A prefetched block is assigned to an unpinned buffer.
+	kth, last := s.keys(b)
+	s.unpinned.update(b, kth, last)
+}
+
+func (s *LRUKStrategy) Pinned(b *Buffer) {
+	s.unpinned.remove(b)
+	s.clock++
+	h := append(s.history[b.Blk], s.clock)
+	if len(h) > s.k {
+		h = h[len(h)-s.k:]
+	}
+	s.history[b.Blk] = h
+}
+
+func (s *LRUKStrategy) Unpinned(b *Buffer) {
+	kth, last := s.keys(b)
+	s.unpinned.add(b, kth, last)
+}
+
+// Removed keeps the access history of the buffer's block, since the
+// history outlives the block's stay in the buffer pool anyway.
+func (s *LRUKStrategy) Removed(b *Buffer) {
+	s.unpinned.remove(b)
+}
+
+// keys returns the time of the K-th most recent access of the buffer's
+// block, or 0 if the block has been accessed fewer than K times, and the
+// time of its most recent access.
+func (s *LRUKStrategy) keys(b *Buffer) (kth, last uint64) {
+	if h := s.history[b.Blk]; len(h) > 0 {
+		last = h[len(h)-1]
+		if len(h) == s.k {
+			kth = h[0]
+		}
+	}
+	return kth, last
+}
+
+func (s *LRUKStrategy) ChooseVictim(bufpool []*Buffer) *Buffer {
+	victim := s.unpinned.victim()
+	if len(s.history) > historyFactor*len(bufpool) {
+		s.pruneHistory(bufpool)
+	}
+	return victim
+}
+
+// pruneHistory forgets the least recently accessed half of the blocks that
+// are not in the buffer pool.
+func (s *LRUKStrategy) pruneHistory(bufpool []*Buffer) {
+	resident := make(map[file.BlockID]bool, len(bufpool))
+	for _, b := range bufpool {
+		resident[b.Blk] = true
+	}
+	var blks []file.BlockID
+	for blk := range s.history {
+		if !resident[blk] {
+			blks = append(blks, blk)
+		}
+	}
+	last := func(blk file.BlockID) uint64 {
+		h := s.history[blk]
+		return h[len(h)-1]
+	}
+	slices.SortFunc(blks, func(a, b file.BlockID) int {
+		return cmp.Compare(last(a), last(b))
+	})
+	for _, blk := range blks[:len(blks)/2] {
+		delete(s.history, blk)
+	}
+}
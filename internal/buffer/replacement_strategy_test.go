@@ -0,0 +1,187 @@
+package buffer_test
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"simpledb/internal/buffer"
+	"simpledb/internal/file"
+	"simpledb/internal/server"
+	"testing"
+)
+
+// countingStrategy wraps a replacement strategy and counts the number of
+// blocks read into the buffer pool.
+type countingStrategy struct {
+	buffer.ReplacementStrategy
+	misses int
+}
+
+func (s *countingStrategy) Assigned(b *buffer.Buffer) {
+	s.misses++
+	s.ReplacementStrategy.Assigned(b)
+}
+
+var strategies = []struct {
+	name string
+	new  func() buffer.ReplacementStrategy
+}{
+	{"naive", func() buffer.ReplacementStrategy { return buffer.NewNaiveStrategy() }},
+	{"fifo", func() buffer.ReplacementStrategy { return buffer.NewFIFOStrategy() }},
+	{"lru", func() buffer.ReplacementStrategy { return buffer.NewLRUStrategy() }},
+	{"clock", func() buffer.ReplacementStrategy { return buffer.NewClockStrategy() }},
+	{"lru2", func() buffer.ReplacementStrategy { return buffer.NewLRUKStrategy(2) }},
+}
+
+const traceBuffers = 8
+
+// traces are block access traces. Each is a sequence of block numbers.
+var traces = []struct {
+	name  string
+	trace func() []int
+}{
+	// A few hot catalog blocks are read before each block of repeated
+	// scans over a table that is larger than the buffer pool.
+	{"catalog+scan", func() []int {
+		var trace []int
+		for i := 0; i < 20; i++ {
+			for blk := 100; blk < 150; blk++ {
+				trace = append(trace, i%4, blk)
+			}
+		}
+		return trace
+	}},
+	// A loop over slightly more blocks than fit in the buffer pool.
+	{"loop", func() []int {
+		var trace []int
+		for i := 0; i < 1000; i++ {
+			trace = append(trace, i%(traceBuffers+2))
+		}
+		return trace
+	}},
+	// Random accesses, skewed towards a small set of blocks.
+	{"zipf", func() []int {
+		r := rand.New(rand.NewSource(1))
+		z := rand.NewZipf(r, 1.2, 1, 200)
+		var trace []int
+		for i := 0; i < 2000; i++ {
+			trace = append(trace, int(z.Uint64()))
+		}
+		return trace
+	}},
+}
+
+// replay pins and unpins each block of the trace in turn, using a buffer
+// manager with the specified strategy, and returns the hit ratio.
+func replay(tb testing.TB, db *server.SimpleDB, strategy buffer.ReplacementStrategy, trace []int) float64 {
+	cs := &countingStrategy{ReplacementStrategy: strategy}
+	bm, err := buffer.NewBufferMgrWithStrategy(db.FileMgr, db.LogMgr, traceBuffers, cs)
+	if err != nil {
+		tb.Fatalf("Failed to create buffer manager: %v", err)
+	}
+	for _, blknum := range trace {
+		b, err := bm.Pin(file.NewBlockID("tracefile", blknum))
+		if err != nil {
+			tb.Fatalf("Failed to pin block: %v", err)
+		}
+		bm.Unpin(b)
+	}
+	return float64(len(trace)-cs.misses) / float64(len(trace))
+}
+
+func TestReplacementStrategies(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("replacementtest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("replacementtest", 400, traceBuffers)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	for _, tr := range traces {
+		trace := tr.trace()
+		ratios := make(map[string]float64)
+		for _, s := range strategies {
+			ratios[s.name] = replay(t, db, s.new(), trace)
+			t.Logf("%s/%s: hit ratio %.3f", tr.name, s.name, ratios[s.name])
+		}
+		if tr.name == "catalog+scan" {
+			// The scan should not push the catalog blocks out of the pool.
+			for _, name := range []string{"lru", "clock", "lru2"} {
+				if ratios[name] <= ratios["naive"] {
+					t.Errorf("Expected %s to beat naive on %s: %.3f <= %.3f", name, tr.name, ratios[name], ratios["naive"])
+				}
+			}
+			if ratios["lru2"] < 0.45 {
+				t.Errorf("Expected lru2 to keep the catalog blocks, got hit ratio %.3f", ratios["lru2"])
+			}
+		}
+	}
+}
+
+func BenchmarkReplacementStrategies(b *testing.B) {
+	b.Cleanup(func() {
+		os.RemoveAll("replacementbench")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("replacementbench", 400, traceBuffers)
+	if err != nil {
+		b.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	for _, tr := range traces {
+		trace := tr.trace()
+		for _, s := range strategies {
+			b.Run(fmt.Sprintf("%s/%s", tr.name, s.name), func(b *testing.B) {
+				var ratio float64
+				for i := 0; i < b.N; i++ {
+					ratio = replay(b, db, s.new(), trace)
+				}
+				b.ReportMetric(ratio, "hit-ratio")
+			})
+		}
+	}
+}
+
+// BenchmarkWarmPool measures the cost of a miss in a large buffer pool that
+// is full, and mostly pinned, so that every pin has to choose a victim.
+func BenchmarkWarmPool(b *testing.B) {
+	const numbufs, numpinned = 1024, 1000
+	b.Cleanup(func() {
+		os.RemoveAll("warmpoolbench")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("warmpoolbench", 400, traceBuffers)
+	if err != nil {
+		b.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	for _, s := range strategies {
+		b.Run(s.name, func(b *testing.B) {
+			bm, err := buffer.NewBufferMgrWithStrategy(db.FileMgr, db.LogMgr, numbufs, s.new())
+			if err != nil {
+				b.Fatalf("Failed to create buffer manager: %v", err)
+			}
+			for blknum := 0; blknum < numpinned; blknum++ {
+				if _, err := bm.Pin(file.NewBlockID("warmfile", blknum)); err != nil {
+					b.Fatalf("Failed to pin block: %v", err)
+				}
+			}
+			// Cycle over twice as many blocks as there are unpinned
+			// buffers, so that every pin misses.
+			cycle := 2 * (numbufs - numpinned)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf, err := bm.Pin(file.NewBlockID("warmfile", numpinned+i%cycle))
+				if err != nil {
+					b.Fatalf("Failed to pin block: %v", err)
+				}
+				bm.Unpin(buf)
+			}
+		})
+	}
+}
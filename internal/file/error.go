@@ -0,0 +1,23 @@
+package file
+
+import "fmt"
+
+// CorruptPageError represents an error indicating that the checksum stored
+// with a block does not match its contents, because the block was only
+// partially written or has been damaged since.
+type CorruptPageError struct {
+	Blk BlockID
+	// Stored is the checksum read from disk, and Computed is the checksum
+	// of the contents that were read.
+	Stored, Computed uint32
+}
+
+// Error implements the error interface for CorruptPageError.
+func (e *CorruptPageError) Error() string {
+	return fmt.Sprintf("corrupt page %s: stored checksum %08x does not match computed checksum %08x", e.Blk, e.Stored, e.Computed)
+}
+
+// NewCorruptPageError creates a new CorruptPageError for the specified block.
+func NewCorruptPageError(blk BlockID, stored, computed uint32) *CorruptPageError {
+	return &CorruptPageError{Blk: blk, Stored: stored, Computed: computed}
+}
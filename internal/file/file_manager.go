@@ -1,14 +1,46 @@
 package file
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 )
 
+// ControlFile is the name of the file that records the options a database
+// was created with.
+const ControlFile = "simpledb.ctl"
+
+// ChecksumSize is the number of bytes stored after each block to hold its
+// checksum, when page checksums are enabled.
+const ChecksumSize = 4
+
+// PageLSNSize is the number of bytes stored after each block to hold the
+// LSN of its page, when page LSNs are enabled.
+const PageLSNSize = 8
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Options are database-wide settings that are chosen when a database is
+// created, and kept for its lifetime.
+type Options struct {
+	// Checksums enables page checksums. Each block is stored with a CRC32C
+	// checksum of its contents, which is verified when the block is read.
+	Checksums bool
+	// PageLSNs stores each page's LSN with its block, so that recovery can
+	// tell which logged changes the block already contains.
+	PageLSNs bool
+}
+
+// DefaultOptions are the options used by NewFileMgr for new databases.
+var DefaultOptions = Options{Checksums: true, PageLSNs: true}
+
 // FileMgr manages raw file access for the database.
 type FileMgr struct {
 	dbdir string
@@ -17,12 +49,27 @@ type FileMgr struct {
 	// size defined by the operating system.
 	BlockSize int
 	IsNew     bool
-	openFiles map[string]*os.File
-	mu        sync.Mutex
+	// The options the database was created with.
+	options Options
+	// The version of the log's format, or 0 if the control file doesn't
+	// record one.
+	logVersion int
+	openFiles  map[string]*os.File
+	// A buffer holding a block and its trailer, for reads and writes.
+	scratch []byte
+	mu      sync.Mutex
 }
 
 // NewFileMgr creates a new FileMgr with the given directory name and blocksize.
+// If the database is new, it is created with the DefaultOptions.
 func NewFileMgr(dbdir string, blocksize int) (*FileMgr, error) {
+	return NewFileMgrWithOptions(dbdir, blocksize, DefaultOptions)
+}
+
+// NewFileMgrWithOptions creates a new FileMgr with the given directory name
+// and blocksize. If the database is new, it is created with the specified
+// options; otherwise, the options it was created with are used.
+func NewFileMgrWithOptions(dbdir string, blocksize int, opts Options) (*FileMgr, error) {
 	fm := &FileMgr{
 		dbdir:     dbdir,
 		BlockSize: blocksize,
@@ -55,9 +102,111 @@ func NewFileMgr(dbdir string, blocksize int) (*FileMgr, error) {
 		}
 	}
 
+	if fm.IsNew {
+		fm.options = opts
+		if err := fm.writeControlFile(); err != nil {
+			return nil, err
+		}
+	} else if err := fm.readControlFile(); err != nil {
+		return nil, err
+	}
+	fm.scratch = make([]byte, fm.BlockStride())
+
 	return fm, nil
 }
 
+// Checksums returns true if the database stores a checksum with each block.
+func (fm *FileMgr) Checksums() bool {
+	return fm.options.Checksums
+}
+
+// PageLSNs returns true if the database stores the LSN of each page with
+// its block.
+func (fm *FileMgr) PageLSNs() bool {
+	return fm.options.PageLSNs
+}
+
+// LogVersion returns the version of the log's format recorded in the
+// control file, or 0 if it doesn't record one. The log manager records the
+// version when it first opens the log.
+func (fm *FileMgr) LogVersion() int {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	return fm.logVersion
+}
+
+// SetLogVersion records the version of the log's format in the control
+// file.
+func (fm *FileMgr) SetLogVersion(v int) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.logVersion = v
+	return fm.writeControlFile()
+}
+
+// writeControlFile records the database's options, and the version of its
+// log's format, in the control file.
+func (fm *FileMgr) writeControlFile() error {
+	flag := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	contents := fmt.Sprintf("checksums=%d\npagelsns=%d\n", flag(fm.options.Checksums), flag(fm.options.PageLSNs))
+	if fm.logVersion > 0 {
+		contents += fmt.Sprintf("logversion=%d\n", fm.logVersion)
+	}
+	path := filepath.Join(fm.dbdir, ControlFile)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		return fmt.Errorf("cannot write control file: %w", err)
+	}
+	return nil
+}
+
+// readControlFile reads the database's options from the control file.
+// Databases created before the control file existed have no options
+// enabled.
+func (fm *FileMgr) readControlFile() error {
+	path := filepath.Join(fm.dbdir, ControlFile)
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("cannot read control file: %w", err)
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		key, val, _ := strings.Cut(line, "=")
+		switch key {
+		case "checksums":
+			fm.options.Checksums = val == "1"
+		case "pagelsns":
+			fm.options.PageLSNs = val == "1"
+		case "logversion":
+			v, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("invalid log version in control file: %q", val)
+			}
+			fm.logVersion = v
+		}
+	}
+	return nil
+}
+
+// BlockStride returns the number of bytes each block takes up on disk.
+// The block's contents are followed by its page LSN and then its checksum,
+// if the database stores them.
+func (fm *FileMgr) BlockStride() int {
+	stride := fm.BlockSize
+	if fm.options.PageLSNs {
+		stride += PageLSNSize
+	}
+	if fm.options.Checksums {
+		stride += ChecksumSize
+	}
+	return stride
+}
+
 // Close closes all open files
 func (fm *FileMgr) Close() {
 	fm.mu.Lock()
@@ -68,7 +217,10 @@ func (fm *FileMgr) Close() {
 	}
 }
 
-// Read reads the contents of the specified block into the specified page
+// Read reads the contents of the specified block into the specified page,
+// along with the page's LSN if the database stores page LSNs.
+// If the block's checksum doesn't match, the page still receives the
+// contents that were read, and a CorruptPageError is returned.
 func (fm *FileMgr) Read(blk BlockID, p *Page) error {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
@@ -78,19 +230,47 @@ func (fm *FileMgr) Read(blk BlockID, p *Page) error {
 		return err
 	}
 
-	offset := int64(blk.Blknum) * int64(fm.BlockSize)
-	if _, err = f.ReadAt(p.buf, offset); err != nil {
-		if err != io.EOF {
-			return fmt.Errorf("cannot read block %s: %w", blk, err)
+	stride := fm.BlockStride()
+	if stride == fm.BlockSize {
+		offset := int64(blk.Blknum) * int64(fm.BlockSize)
+		if _, err = f.ReadAt(p.buf, offset); err != nil {
+			if err != io.EOF {
+				return fmt.Errorf("cannot read block %s: %w", blk, err)
+			}
 		}
+		// note: if we read less bytes than the size of the page buffer, it's ok
+		p.lsn = 0
+		return nil
 	}
 
-	// note: if we read less bytes than the size of the page buffer, it's ok
-
+	offset := int64(blk.Blknum) * int64(stride)
+	n, err := f.ReadAt(fm.scratch, offset)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("cannot read block %s: %w", blk, err)
+	}
+	// Bytes past the end of the file read as zeros.
+	clear(fm.scratch[n:])
+	copy(p.buf, fm.scratch[:fm.BlockSize])
+	p.lsn = 0
+	if fm.options.PageLSNs {
+		p.lsn = int(binary.BigEndian.Uint64(fm.scratch[fm.BlockSize:]))
+	}
+	if !fm.options.Checksums {
+		return nil
+	}
+	// The checksum covers the block and its page LSN.
+	data := fm.scratch[:stride-ChecksumSize]
+	stored := binary.BigEndian.Uint32(fm.scratch[stride-ChecksumSize:])
+	computed := crc32.Checksum(data, castagnoli)
+	// A block that was appended but never written is all zeros.
+	if stored != computed && (stored != 0 || !isZero(data)) {
+		return NewCorruptPageError(blk, stored, computed)
+	}
 	return nil
 }
 
-// Write writes the contents of a page to the specified block
+// Write writes the contents of a page to the specified block, along with
+// the page's LSN if the database stores page LSNs.
 func (fm *FileMgr) Write(blk BlockID, p *Page) error {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
@@ -100,8 +280,22 @@ func (fm *FileMgr) Write(blk BlockID, p *Page) error {
 		return err
 	}
 
-	offset := int64(blk.Blknum) * int64(fm.BlockSize)
-	if _, err := f.WriteAt(p.buf, offset); err != nil {
+	buf := p.buf
+	stride := fm.BlockStride()
+	if stride != fm.BlockSize {
+		// Write the block and its trailer together.
+		buf = fm.scratch
+		copy(buf, p.buf)
+		if fm.options.PageLSNs {
+			binary.BigEndian.PutUint64(buf[fm.BlockSize:], uint64(p.lsn))
+		}
+		if fm.options.Checksums {
+			sum := crc32.Checksum(buf[:stride-ChecksumSize], castagnoli)
+			binary.BigEndian.PutUint32(buf[stride-ChecksumSize:], sum)
+		}
+	}
+	offset := int64(blk.Blknum) * int64(stride)
+	if _, err := f.WriteAt(buf, offset); err != nil {
 		return fmt.Errorf("cannot write block %s: %w", blk, err)
 	}
 
@@ -125,9 +319,10 @@ func (fm *FileMgr) Append(filename string) (BlockID, error) {
 		return BlockID{}, fmt.Errorf("cannot stat file %s: %w", filename, err)
 	}
 
-	newblknum := int(info.Size() / int64(fm.BlockSize))
-	buf := make([]byte, fm.BlockSize) // an empty block of data
-	offset := int64(newblknum * fm.BlockSize)
+	stride := fm.BlockStride()
+	newblknum := int(info.Size() / int64(stride))
+	buf := make([]byte, stride) // an empty block of data
+	offset := int64(newblknum * stride)
 	if _, err := f.WriteAt(buf, offset); err != nil {
 		return BlockID{}, fmt.Errorf("cannot write to file %s: %w", filename, err)
 	}
@@ -150,7 +345,69 @@ func (fm *FileMgr) Length(filename string) (int, error) {
 		return 0, fmt.Errorf("cannot stat file %s: %w", filename, err)
 	}
 
-	return int(info.Size() / int64(fm.BlockSize)), nil
+	return int(info.Size() / int64(fm.BlockStride())), nil
+}
+
+// isZero returns true if every byte of b is zero.
+func isZero(b []byte) bool {
+	return len(bytes.TrimLeft(b, "\x00")) == 0
+}
+
+// Files returns the names of the files in the database directory that
+// start with the specified prefix.
+func (fm *FileMgr) Files(prefix string) ([]string, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	entries, err := os.ReadDir(fm.dbdir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read directory %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), prefix) {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// Remove closes and deletes the specified file.
+func (fm *FileMgr) Remove(filename string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	fm.closeFile(filename)
+	if err := os.Remove(filepath.Join(fm.dbdir, filename)); err != nil {
+		return fmt.Errorf("cannot remove file %s: %w", filename, err)
+	}
+	return nil
+}
+
+// Rename closes the specified file and renames it. The new name is relative
+// to the database directory, and may include a subdirectory, which is
+// created if necessary.
+func (fm *FileMgr) Rename(filename, newname string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	fm.closeFile(filename)
+	newpath := filepath.Join(fm.dbdir, newname)
+	if err := os.MkdirAll(filepath.Dir(newpath), 0755); err != nil {
+		return fmt.Errorf("cannot create directory %w", err)
+	}
+	if err := os.Rename(filepath.Join(fm.dbdir, filename), newpath); err != nil {
+		return fmt.Errorf("cannot rename file %s: %w", filename, err)
+	}
+	return nil
+}
+
+// closeFile closes the specified file, if it is open.
+func (fm *FileMgr) closeFile(filename string) {
+	if f, ok := fm.openFiles[filename]; ok {
+		f.Close()
+		delete(fm.openFiles, filename)
+	}
 }
 
 // getFile gets a file from the list of open files or creates a new one if it
@@ -1,6 +1,7 @@
 package file
 
 import (
+	"errors"
 	"os"
 	"testing"
 )
@@ -41,3 +42,131 @@ func TestFileMgr(t *testing.T) {
 		t.Errorf("Expected offset %d to contain 'abcdefghijklm', but got %s", pos1, p2.GetString(pos1))
 	}
 }
+
+func TestFileMgrChecksums(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("filechecksumtest")
+		os.RemoveAll("filenochecksumtest")
+	})
+
+	fm, err := NewFileMgrWithOptions("filechecksumtest", 400, Options{Checksums: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk := NewBlockID("testfile", 1)
+	p := NewPage(fm.BlockSize)
+	p.SetInt(80, 345)
+	if err := fm.Write(blk, p); err != nil {
+		t.Fatal(err)
+	}
+	// Block 0 was never written, but reads as an empty block.
+	if err := fm.Read(NewBlockID("testfile", 0), p); err != nil {
+		t.Fatalf("Expected unwritten block to read cleanly, but got %v", err)
+	}
+	if err := fm.Read(blk, p); err != nil || p.GetInt(80) != 345 {
+		t.Fatalf("Expected to read 345, but got %d, %v", p.GetInt(80), err)
+	}
+	if n, err := fm.Length("testfile"); err != nil || n != 2 {
+		t.Fatalf("Expected 2 blocks, but got %d, %v", n, err)
+	}
+	fm.Close()
+
+	// Flip a byte in the middle of block 1, as a torn write or bit rot would.
+	f, err := os.OpenFile("filechecksumtest/testfile", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0xff}, int64(400+ChecksumSize+200)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// The database keeps its options when it is reopened.
+	fm, err = NewFileMgrWithOptions("filechecksumtest", 400, Options{Checksums: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fm.Close()
+	if !fm.Checksums() {
+		t.Fatal("Expected checksums to stay enabled")
+	}
+	err = fm.Read(blk, p)
+	var cerr *CorruptPageError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Expected CorruptPageError, but got %v", err)
+	}
+	if cerr.Blk != blk {
+		t.Errorf("Expected the error to name %s, but got %s", blk, cerr.Blk)
+	}
+
+	fm2, err := NewFileMgrWithOptions("filenochecksumtest", 400, Options{Checksums: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fm2.Close()
+	if err := fm2.Write(blk, p); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := fm2.Length("testfile"); err != nil || n != 2 {
+		t.Fatalf("Expected 2 blocks, but got %d, %v", n, err)
+	}
+}
+
+func TestFileMgrPageLSNs(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("filepagelsntest")
+		os.RemoveAll("filenopagelsntest")
+	})
+
+	fm, err := NewFileMgrWithOptions("filepagelsntest", 400, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fm.BlockStride() != 400+PageLSNSize+ChecksumSize {
+		t.Fatalf("Expected a stride of %d, but got %d", 400+PageLSNSize+ChecksumSize, fm.BlockStride())
+	}
+	blk := NewBlockID("testfile", 0)
+	p := NewPage(fm.BlockSize)
+	p.SetInt(0, 7)
+	p.SetLSN(1 << 40)
+	if err := fm.Write(blk, p); err != nil {
+		t.Fatal(err)
+	}
+	p2 := NewPage(fm.BlockSize)
+	if err := fm.Read(blk, p2); err != nil || p2.LSN() != 1<<40 || p2.GetInt(0) != 7 {
+		t.Fatalf("Expected to read LSN %d, but got %d, %v", 1<<40, p2.LSN(), err)
+	}
+	fm.Close()
+
+	// The checksum covers the page LSN.
+	f, err := os.OpenFile("filepagelsntest/testfile", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0xff}, 400+PageLSNSize-1); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	fm, err = NewFileMgrWithOptions("filepagelsntest", 400, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fm.Close()
+	var cerr *CorruptPageError
+	if err := fm.Read(blk, p2); !errors.As(err, &cerr) {
+		t.Fatalf("Expected CorruptPageError, but got %v", err)
+	}
+
+	// Without page LSNs, pages read with an LSN of 0.
+	fm2, err := NewFileMgrWithOptions("filenopagelsntest", 400, Options{Checksums: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fm2.Close()
+	if err := fm2.Write(blk, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm2.Read(blk, p2); err != nil || p2.LSN() != 0 || p2.GetInt(0) != 7 {
+		t.Fatalf("Expected to read LSN 0, but got %d, %v", p2.LSN(), err)
+	}
+}
@@ -5,17 +5,31 @@ import "encoding/binary"
 // Page represents a fixed-size block of data in memory.
 type Page struct {
 	buf []byte
+	// The LSN of the latest log record describing a change to the page.
+	lsn int
 }
 
 // NewPage creates a new Page with the given blocksize.
 func NewPage(blocksize int) *Page {
 	buf := make([]byte, blocksize)
-	return &Page{buf}
+	return &Page{buf: buf}
 }
 
 // NewPageFromBytes creates a new Page from the given byte slice.
 func NewPageFromBytes(buf []byte) *Page {
-	return &Page{buf}
+	return &Page{buf: buf}
+}
+
+// LSN returns the LSN of the latest log record describing a change to the
+// page, or 0 if the page hasn't been changed since page LSNs were stored.
+// It isn't part of the page's contents, but is stored with its block.
+func (p *Page) LSN() int {
+	return p.lsn
+}
+
+// SetLSN sets the page's LSN.
+func (p *Page) SetLSN(lsn int) {
+	p.lsn = lsn
 }
 
 // GetInt retrieves an int at the specified offset.
@@ -28,6 +42,16 @@ func (p *Page) SetInt(offset int, n int32) {
 	binary.BigEndian.PutUint32(p.buf[offset:offset+4], uint32(n))
 }
 
+// GetLong retrieves a 64-bit int at the specified offset.
+func (p *Page) GetLong(offset int) int64 {
+	return int64(binary.BigEndian.Uint64(p.buf[offset : offset+8]))
+}
+
+// SetLong sets a 64-bit int at the specified offset.
+func (p *Page) SetLong(offset int, n int64) {
+	binary.BigEndian.PutUint64(p.buf[offset:offset+8], uint64(n))
+}
+
 // GetBytes retrieves a byte slice at the specified offset.
 func (p *Page) GetBytes(offset int) []byte {
 	length := int(p.GetInt(offset))
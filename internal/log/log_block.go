@@ -0,0 +1,59 @@
+package log
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"simpledb/internal/file"
+)
+
+// Each log record is stored in a log block as its bytes, preceded by their
+// length, and followed by a checksum and the length again:
+//
+//	[len][bytes][checksum][len]
+//
+// The records of a block are written right to left, starting at the end of
+// the block, so the trailing length lets a block be read from its oldest
+// record to its newest. A record whose checksum doesn't match was only
+// partially written, and neither it nor any newer record in the block is
+// considered part of the log.
+const recordOverhead = 12
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// recordChecksum returns the CRC32C checksum of a log record's length and
+// bytes. Including the length means a run of zeros is never a valid record.
+func recordChecksum(rec []byte) uint32 {
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(rec)))
+	return crc32.Update(crc32.Checksum(hdr[:], castagnoli), castagnoli, rec)
+}
+
+// writeRecord writes a log record to the page at the specified position.
+func writeRecord(p *file.Page, pos int, rec []byte) {
+	p.SetBytes(pos, rec)
+	p.SetInt(pos+4+len(rec), int32(recordChecksum(rec)))
+	p.SetInt(pos+8+len(rec), int32(len(rec)))
+}
+
+// blockRecords returns the positions of the valid records in a log block,
+// from oldest to newest. It also returns the position of the newest valid
+// record, which is the block's boundary unless the records that follow it
+// were torn.
+func blockRecords(p *file.Page, blocksize int) ([]int, int) {
+	var positions []int
+	end := blocksize
+	for end-recordOverhead >= 4 {
+		n := int(p.GetInt(end - 4))
+		pos := end - recordOverhead - n
+		if n < 0 || pos < 4 || int(p.GetInt(pos)) != n {
+			break
+		}
+		rec := p.GetBytes(pos)
+		if uint32(p.GetInt(pos+4+n)) != recordChecksum(rec) {
+			break
+		}
+		positions = append(positions, pos)
+		end = pos
+	}
+	return positions, end
+}
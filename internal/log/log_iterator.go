@@ -3,63 +3,217 @@ package log
 import (
 	"fmt"
 	"simpledb/internal/file"
+	"slices"
 )
 
 // LogIterator lets you move through the records of the log file in reverse order.
+// When it reaches the start of a segment, it continues with the last block
+// of the previous segment.
 type LogIterator struct {
-	fm         *file.FileMgr
-	blk        file.BlockID
-	p          *file.Page
-	currentpos int
+	fm  *file.FileMgr
+	blk file.BlockID
+	p   *file.Page
+	// The log's name, the segment of the current block, and the oldest
+	// segment of the log.
+	logfile      string
+	segment      int
+	firstSegment int
+	// The positions of the records in the current block that haven't been
+	// returned yet, from oldest to newest.
+	positions []int
+	// The LSN of the record most recently returned by Next.
+	lsn int
 }
 
 // NewLogIterator creates an iterator for the records in the log file,
-// positioned after the last log record.
-func NewLogIterator(fm *file.FileMgr, blk file.BlockID) (*LogIterator, error) {
+// positioned after the last log record, which is in the specified block of
+// a segment file. The iterator stops at the start of firstSegment.
+// Records at the end of the specified block that were only partially
+// written are skipped.
+func NewLogIterator(fm *file.FileMgr, blk file.BlockID, firstSegment int) (*LogIterator, error) {
+	logfile, segment, ok := parseSegmentFileName(blk.Filename)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a log segment", blk.Filename)
+	}
 	buf := make([]byte, fm.BlockSize)
 	p := file.NewPageFromBytes(buf)
 	li := &LogIterator{
-		fm:         fm,
-		blk:        blk,
-		p:          p,
-		currentpos: 0,
+		fm:           fm,
+		blk:          blk,
+		p:            p,
+		logfile:      logfile,
+		segment:      segment,
+		firstSegment: firstSegment,
 	}
-	if err := li.moveToBlock(blk); err != nil {
+	if err := li.fm.Read(blk, li.p); err != nil {
 		return nil, err
 	}
+	li.positions, _ = blockRecords(li.p, li.fm.BlockSize)
 	return li, nil
 }
 
 // HasNext returns true if the current log record is the earliest record in the
 // log file. (Recall that the log records are written backwards in the file.)
 func (li *LogIterator) HasNext() bool {
-	return li.currentpos < li.fm.BlockSize || li.blk.Blknum > 0
+	return len(li.positions) > 0 || li.blk.Blknum > 0 || li.segment > li.firstSegment
 }
 
 // Next moves to the next log record in the block.
 // If there are no more records in the block, then move to the previous block
 // and return the log record from there.
 func (li *LogIterator) Next() ([]byte, error) {
-	if li.currentpos == li.fm.BlockSize {
-		li.blk = file.NewBlockID(li.blk.Filename, li.blk.Blknum-1)
+	for len(li.positions) == 0 {
+		if li.blk.Blknum > 0 {
+			li.blk = file.NewBlockID(li.blk.Filename, li.blk.Blknum-1)
+		} else {
+			li.segment--
+			segfile := SegmentFileName(li.logfile, li.segment)
+			n, err := li.fm.Length(segfile)
+			if err != nil {
+				return nil, fmt.Errorf("log iteration error: %w", err)
+			}
+			li.blk = file.NewBlockID(segfile, n-1)
+		}
 		err := li.moveToBlock(li.blk)
 		if err != nil {
 			return nil, fmt.Errorf("log iteration error: %w", err)
 		}
 	}
-	rec := li.p.GetBytes(li.currentpos)
-	li.currentpos += 4 + len(rec)
+	n := len(li.positions) - 1
+	rec := li.p.GetBytes(li.positions[n])
+	li.lsn = NewLSN(li.segment, li.blk.Blknum, li.fm.BlockSize-li.positions[n])
+	li.positions = li.positions[:n]
 	return rec, nil
 }
 
+// LSN returns the LSN of the record most recently returned by Next.
+func (li *LogIterator) LSN() int {
+	return li.lsn
+}
+
 // moveToBlock moves to the specified log block and positions the iterator at
 // the first record in that block (i.e., the most recent one).
+// Only the last block of the log may end in a torn record, so any other
+// block whose records don't reach its boundary is corrupt.
 func (li *LogIterator) moveToBlock(blk file.BlockID) error {
 	err := li.fm.Read(blk, li.p)
 	if err != nil {
 		return err
 	}
 
-	li.currentpos = int(li.p.GetInt(0)) // TODO: handle int overflow better
+	positions, pos := blockRecords(li.p, li.fm.BlockSize)
+	if boundary := int(li.p.GetInt(0)); pos != boundary || len(positions) == 0 {
+		return fmt.Errorf("corrupt log block %s", blk)
+	}
+	li.positions = positions
+	return nil
+}
+
+// forwardIterator moves through the records of the log in the order they
+// were appended, up to the end of a given block.
+type forwardIterator struct {
+	fm      *file.FileMgr
+	logfile string
+	segment int
+	blk     file.BlockID
+	p       *file.Page
+	// The segment and number of the last block to read.
+	lastSegment int
+	lastBlknum  int
+	// The positions of the records in the current block that haven't been
+	// returned yet, from oldest to newest.
+	positions []int
+}
+
+// newForwardIterator creates an iterator that starts at the record with
+// the specified LSN and ends with the last record in the block last.
+func newForwardIterator(fm *file.FileMgr, logfile string, lsn int, last file.BlockID) (*forwardIterator, error) {
+	_, lastSegment, ok := parseSegmentFileName(last.Filename)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a log segment", last.Filename)
+	}
+	segment, blknum, _ := SplitLSN(lsn)
+	fi := &forwardIterator{
+		fm:          fm,
+		logfile:     logfile,
+		segment:     segment,
+		blk:         file.NewBlockID(SegmentFileName(logfile, segment), blknum),
+		p:           file.NewPageFromBytes(make([]byte, fm.BlockSize)),
+		lastSegment: lastSegment,
+		lastBlknum:  last.Blknum,
+	}
+	if fi.atLast() < 0 {
+		return fi, nil
+	}
+	if err := fi.readBlock(); err != nil {
+		return nil, err
+	}
+	// Skip the records in the block that are older than the LSN.
+	fi.positions = slices.DeleteFunc(fi.positions, func(pos int) bool {
+		return fi.lsn(pos) < lsn
+	})
+	return fi, nil
+}
+
+// next returns the next record of the log, or false if there are no more.
+func (fi *forwardIterator) next() (Record, bool, error) {
+	for len(fi.positions) == 0 {
+		if fi.atLast() <= 0 {
+			return Record{}, false, nil
+		}
+		if err := fi.moveToNextBlock(); err != nil {
+			return Record{}, false, fmt.Errorf("log iteration error: %w", err)
+		}
+	}
+	pos := fi.positions[0]
+	fi.positions = fi.positions[1:]
+	return Record{fi.lsn(pos), fi.p.GetBytes(pos)}, true, nil
+}
+
+// lsn returns the LSN of the record at the specified position of the
+// current block.
+func (fi *forwardIterator) lsn(pos int) int {
+	return NewLSN(fi.segment, fi.blk.Blknum, fi.fm.BlockSize-pos)
+}
+
+// atLast returns 0 if the current block is the last block to read, a
+// positive number if it comes before the last block, and a negative number
+// if it comes after it.
+func (fi *forwardIterator) atLast() int {
+	if fi.segment != fi.lastSegment {
+		return fi.lastSegment - fi.segment
+	}
+	return fi.lastBlknum - fi.blk.Blknum
+}
+
+// moveToNextBlock moves to the following block of the log, which is the
+// first block of the next segment if the current block ends its segment,
+// and reads it.
+func (fi *forwardIterator) moveToNextBlock() error {
+	n, err := fi.fm.Length(fi.blk.Filename)
+	if err != nil {
+		return err
+	}
+	if fi.blk.Blknum+1 < n {
+		fi.blk = file.NewBlockID(fi.blk.Filename, fi.blk.Blknum+1)
+	} else {
+		fi.segment++
+		fi.blk = file.NewBlockID(SegmentFileName(fi.logfile, fi.segment), 0)
+	}
+	return fi.readBlock()
+}
+
+// readBlock reads the current block and finds its records.
+// Only the last block of the log may end in a torn record, so any other
+// block whose records don't reach its boundary is corrupt.
+func (fi *forwardIterator) readBlock() error {
+	if err := fi.fm.Read(fi.blk, fi.p); err != nil {
+		return err
+	}
+	positions, pos := blockRecords(fi.p, fi.fm.BlockSize)
+	if fi.atLast() != 0 && (pos != int(fi.p.GetInt(0)) || len(positions) == 0) {
+		return fmt.Errorf("corrupt log block %s", fi.blk)
+	}
+	fi.positions = positions
 	return nil
 }
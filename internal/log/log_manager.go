@@ -1,8 +1,11 @@
 package log
 
 import (
+	"errors"
+	"fmt"
 	"iter"
 	"sync"
+	"time"
 
 	"simpledb/internal/file"
 )
@@ -10,6 +13,8 @@ import (
 const DefaultLogFile = "simpledb.log"
 
 // LogMgr is responsible for writing log records into a file.
+// The log is split into segment files, each holding a fixed number of
+// blocks; see SegmentFileName.
 // The tail of the log is kept in a buffer, which is flushed to disk when needed.
 type LogMgr struct {
 	fm           *file.FileMgr
@@ -18,60 +23,196 @@ type LogMgr struct {
 	currentblk   file.BlockID
 	latestLSN    int
 	lastSavedLSN int
-	mu           sync.Mutex
+	// The oldest and newest segments of the log.
+	firstSegment    int
+	segment         int
+	segmentBlocks   int
+	archiveDir      string
+	oldestNeededLSN int
+	// How long FlushCommit waits for other commits to join it, or 0 to
+	// write the log page immediately.
+	groupCommitWindow time.Duration
+	// Whether a FlushCommit is waiting for others to join it or writing
+	// the log page. Commits that arrive meanwhile wait on flushed.
+	flushing bool
+	flushed  *sync.Cond
+	// The number of times the log page has been written to disk.
+	pageWrites int64
+	mu         sync.Mutex
 }
 
 // NewLogMgr creates a new LogMgr instance with the specified file manager and logfile.
+// The segments of the log are named after logfile.
+// It returns an error if the log has an older format; see FormatVersion.
 func NewLogMgr(fm *file.FileMgr, logfile string) (*LogMgr, error) {
-	buf := make([]byte, fm.BlockSize)
-	logpage := file.NewPageFromBytes(buf)
-	logsize, err := fm.Length(logfile)
+	if fm.BlockSize > MaxLogBlockSize {
+		return nil, fmt.Errorf("block size %d is too large for the log", fm.BlockSize)
+	}
+	// Don't touch a log in an older format, which has to be upgraded first.
+	v, err := Version(fm, logfile)
 	if err != nil {
 		return nil, err
 	}
+	if v != FormatVersion {
+		return nil, fmt.Errorf("log %s has format version %d, not %d, and must be upgraded", logfile, v, FormatVersion)
+	}
+	if fm.LogVersion() == 0 {
+		if err := fm.SetLogVersion(FormatVersion); err != nil {
+			return nil, err
+		}
+	}
+	buf := make([]byte, fm.BlockSize)
+	logpage := file.NewPageFromBytes(buf)
 
 	lm := &LogMgr{
-		fm:           fm,
-		logfile:      logfile,
-		logpage:      logpage,
-		currentblk:   file.BlockID{},
-		latestLSN:    0,
-		lastSavedLSN: 0,
+		fm:            fm,
+		logfile:       logfile,
+		logpage:       logpage,
+		currentblk:    file.BlockID{},
+		latestLSN:     0,
+		lastSavedLSN:  0,
+		segmentBlocks: DefaultSegmentBlocks,
 	}
+	lm.flushed = sync.NewCond(&lm.mu)
 
+	segments, err := lm.findSegments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		segments = []int{1}
+	}
+	lm.firstSegment = segments[0]
+	lm.segment = segments[len(segments)-1]
+	lm.oldestNeededLSN = NewLSN(lm.firstSegment, 0, 0)
+
+	segfile := SegmentFileName(logfile, lm.segment)
+	logsize, err := fm.Length(segfile)
+	if err != nil {
+		return nil, err
+	}
 	if logsize == 0 {
 		if err := lm.appendNewBlock(); err != nil {
 			return nil, err
 		}
 	} else {
-		lm.currentblk = file.NewBlockID(logfile, logsize-1)
-		if err := lm.fm.Read(lm.currentblk, lm.logpage); err != nil {
+		lm.currentblk = file.NewBlockID(segfile, logsize-1)
+		if err := lm.truncateTornTail(); err != nil {
 			return nil, err
 		}
 	}
+	// Carry on numbering records from the end of the log.
+	boundary := int(lm.logpage.GetInt(0))
+	lm.latestLSN = NewLSN(lm.segment, lm.currentblk.Blknum, fm.BlockSize-boundary)
+	lm.lastSavedLSN = lm.latestLSN
 
 	return lm, nil
 }
 
+// truncateTornTail reads the last block of the log, and truncates the log
+// after its last complete record. A crash while the block was being
+// written can leave it with records that were only partially written, or
+// with a boundary that doesn't match its records.
+func (lm *LogMgr) truncateTornTail() error {
+	err := lm.fm.Read(lm.currentblk, lm.logpage)
+	var cerr *file.CorruptPageError
+	if err != nil && !errors.As(err, &cerr) {
+		return err
+	}
+	// Even if the page is torn, the records in it that have valid
+	// checksums were written completely.
+	_, pos := blockRecords(lm.logpage, lm.fm.BlockSize)
+	if cerr == nil && int(lm.logpage.GetInt(0)) == pos {
+		return nil
+	}
+	// Clear the space that new records will be written to.
+	lm.logpage.SetBytes(0, make([]byte, pos-4))
+	lm.logpage.SetInt(0, int32(pos))
+	return lm.fm.Write(lm.currentblk, lm.logpage)
+}
+
 // Flush ensures the log record corresponding to the specified LSN
 // (log sequence number) has been written to disk.
 // All earlier log records will also be written to disk.
+// The log page is written right away, even if a group commit window is set,
+// since callers such as buffer eviction can't afford to wait; see
+// FlushCommit.
 func (lm *LogMgr) Flush(lsn int) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
 	if lsn >= lm.lastSavedLSN {
 		return lm.forceFlush()
 	}
 	return nil
 }
 
+// FlushCommit is like Flush, but is meant for the record that ends a
+// transaction. If a group commit window is set, the log page isn't written
+// right away. Instead, the first caller waits for the window to pass, so
+// that the callers that arrive meanwhile are all satisfied by a single
+// write. Either way, FlushCommit doesn't return until the record is on
+// disk.
+func (lm *LogMgr) FlushCommit(lsn int) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if lm.groupCommitWindow == 0 {
+		if lsn >= lm.lastSavedLSN {
+			return lm.forceFlush()
+		}
+		return nil
+	}
+
+	for lsn > lm.lastSavedLSN {
+		if lm.flushing {
+			// Another caller is going to write the page.
+			lm.flushed.Wait()
+			continue
+		}
+		lm.flushing = true
+		lm.mu.Unlock()
+		time.Sleep(lm.groupCommitWindow)
+		lm.mu.Lock()
+		var err error
+		// A Flush may have written the page in the meantime.
+		if lsn > lm.lastSavedLSN {
+			err = lm.forceFlush()
+		}
+		lm.flushing = false
+		lm.flushed.Broadcast()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetGroupCommitWindow sets how long FlushCommit waits for other commits
+// to join it, so that they are all satisfied by one write. A window of 0, the
+// default, turns group commit off.
+func (lm *LogMgr) SetGroupCommitWindow(d time.Duration) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.groupCommitWindow = d
+}
+
+// PageWrites returns the number of times the log page has been written to
+// disk.
+func (lm *LogMgr) PageWrites() int64 {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.pageWrites
+}
+
 // Append appends a log record to the log buffer.
 // The record consists of an arbitrary array of bytes.
 // Log records are written right to left in the buffer.
-// The size of the record is written before the bytes.
+// The size of the record is written before the bytes, and a checksum and
+// the size again after them, so that a torn record can be detected.
 // The beginning of the buffer contains the location
 // of the last-written record (the "boundary").
 // Storing the records backwards makes it easy to read them
 // in reverse order.
-// Returns the LSN (log sequence number) of the final value.
+// Returns the LSN (log sequence number) of the record.
 func (lm *LogMgr) Append(logrec []byte) (int, error) {
 	// Prevent two threads from mutating the same page or latestLSN
 	lm.mu.Lock()
@@ -79,7 +220,7 @@ func (lm *LogMgr) Append(logrec []byte) (int, error) {
 
 	boundary := lm.logpage.GetInt(0)
 	recsize := len(logrec)
-	bytesneeded := int32(4 + recsize)
+	bytesneeded := int32(recordOverhead + recsize)
 	if boundary-bytesneeded < 4 { // the log record doesn't fit,
 		lm.forceFlush() // so move to the next block.
 		if err := lm.appendNewBlock(); err != nil {
@@ -89,37 +230,86 @@ func (lm *LogMgr) Append(logrec []byte) (int, error) {
 	}
 	recpos := boundary - bytesneeded
 
-	lm.logpage.SetBytes(int(recpos), logrec)
+	writeRecord(lm.logpage, int(recpos), logrec)
 	lm.logpage.SetInt(0, recpos) // the new boundary
-	lm.latestLSN += 1
+	lm.latestLSN = NewLSN(lm.segment, lm.currentblk.Blknum, lm.fm.BlockSize-int(recpos))
 	return lm.latestLSN, nil
 }
 
 // All returns the records of the log file in reverse order.
 func (lm *LogMgr) All() iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		for rec, err := range lm.Backward() {
+			if !yield(rec.Bytes, err) {
+				return
+			}
+		}
+	}
+}
+
+// Backward returns the records of the log file in reverse order, along
+// with their LSNs.
+func (lm *LogMgr) Backward() iter.Seq2[Record, error] {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
 	err := lm.forceFlush()
 	if err != nil {
 		// we couldn't flush, so return a dummy iterator with the error
-		return func(yield func([]byte, error) bool) {
-			yield(nil, err)
+		return func(yield func(Record, error) bool) {
+			yield(Record{}, err)
 		}
 	}
 
-	li, err := NewLogIterator(lm.fm, lm.currentblk)
+	li, err := NewLogIterator(lm.fm, lm.currentblk, lm.firstSegment)
 	if err != nil {
 		// we couldn't create the iterator, so return a dummy iterator with the error
-		return func(yield func([]byte, error) bool) {
-			yield(nil, err)
+		return func(yield func(Record, error) bool) {
+			yield(Record{}, err)
 		}
 	}
 
-	return func(yield func([]byte, error) bool) {
+	return func(yield func(Record, error) bool) {
 		for {
 			if !li.HasNext() {
 				return
 			}
 			v, err := li.Next()
-			if !yield(v, err) {
+			if !yield(Record{li.LSN(), v}, err) {
+				return
+			}
+		}
+	}
+}
+
+// From returns the records of the log in the order they were appended,
+// starting with the record with the specified LSN. If that record has been
+// truncated from the log, it starts with the oldest record that remains.
+// Records appended while iterating may or may not be returned.
+func (lm *LogMgr) From(lsn int) iter.Seq2[Record, error] {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	err := lm.forceFlush()
+	if err != nil {
+		// we couldn't flush, so return a dummy iterator with the error
+		return func(yield func(Record, error) bool) {
+			yield(Record{}, err)
+		}
+	}
+
+	fi, err := newForwardIterator(lm.fm, lm.logfile, max(lsn, NewLSN(lm.firstSegment, 0, 0)), lm.currentblk)
+	if err != nil {
+		return func(yield func(Record, error) bool) {
+			yield(Record{}, err)
+		}
+	}
+	return func(yield func(Record, error) bool) {
+		for {
+			rec, ok, err := fi.next()
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			if !ok || !yield(rec, nil) {
 				return
 			}
 		}
@@ -127,12 +317,18 @@ func (lm *LogMgr) All() iter.Seq2[[]byte, error] {
 }
 
 // appendNewBlock appends a new block to the log file.
+// If the current segment is full, the block starts a new segment.
 func (lm *LogMgr) appendNewBlock() error {
-	blk, err := lm.fm.Append(lm.logfile)
+	if lm.currentblk.Filename != "" && lm.currentblk.Blknum+1 >= lm.segmentBlocks {
+		lm.segment++
+	}
+	blk, err := lm.fm.Append(SegmentFileName(lm.logfile, lm.segment))
 	if err != nil {
 		return err
 	}
 
+	// Clear the records of the previous block.
+	lm.logpage.SetBytes(0, make([]byte, lm.fm.BlockSize-4))
 	lm.logpage.SetInt(0, int32(lm.fm.BlockSize)) // TODO: handle int overflow better
 	err = lm.fm.Write(blk, lm.logpage)
 	if err != nil {
@@ -149,6 +345,9 @@ func (lm *LogMgr) forceFlush() error {
 	if err != nil {
 		return err
 	}
+	lm.pageWrites++
 	lm.lastSavedLSN = lm.latestLSN
+	// Wake the commits waiting for a group flush that this one satisfied.
+	lm.flushed.Broadcast()
 	return nil
 }
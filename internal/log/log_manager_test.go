@@ -1,11 +1,14 @@
 package log
 
 import (
+	"encoding/binary"
 	"fmt"
 	"os"
 	"simpledb/internal/file"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestLogMgr(t *testing.T) {
@@ -81,3 +84,366 @@ func createLogMgr(t *testing.T, dirname string, blocksize int) *LogMgr {
 func closeLogMgr(lm *LogMgr) {
 	lm.fm.Close()
 }
+
+func TestLogMgrTornTail(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("logtorntest")
+	})
+
+	lm := createLogMgr(t, "logtorntest", 400)
+	createRecords(t, lm, 1, 20)
+	lsn, err := lm.Append(createLogRecord("record21", 121))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lm.Flush(lsn); err != nil {
+		t.Fatal(err)
+	}
+	blk := lm.currentblk
+	boundary := int(lm.logpage.GetInt(0))
+	stride := lm.fm.BlockStride()
+	closeLogMgr(lm)
+
+	// Simulate a crash while writing the last block: the boundary moved to
+	// make room for a new record, but the record was only partly written.
+	f, err := os.OpenFile("logtorntest/"+blk.Filename, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(blk.Blknum * stride)
+	hdr := binary.BigEndian.AppendUint32(nil, uint32(boundary-30))
+	if _, err := f.WriteAt(hdr, offset); err != nil {
+		t.Fatal(err)
+	}
+	garbage := []byte{0, 0, 0, 18, 0, 0, 0, 4, 'r', 'e', 'c'}
+	if _, err := f.WriteAt(garbage, offset+int64(boundary-30)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	lm = createLogMgr(t, "logtorntest", 400)
+	defer closeLogMgr(lm)
+	if _, err := lm.Append(createLogRecord("record22", 122)); err != nil {
+		t.Fatal(err)
+	}
+	// Only the torn record is dropped; record21 was written completely.
+	want := 22
+	for rec, err := range lm.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := file.NewPageFromBytes(rec)
+		if s := p.GetString(0); s != fmt.Sprint("record", want) {
+			t.Fatalf("Expected record%d, but got %q", want, s)
+		}
+		want--
+	}
+	if want != 0 {
+		t.Fatalf("Expected to read back to record1, but stopped before record%d", want)
+	}
+}
+
+func TestLogMgrGroupCommit(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("loggrouptest")
+	})
+
+	lm := createLogMgr(t, "loggrouptest", 400)
+	defer closeLogMgr(lm)
+	lm.SetGroupCommitWindow(20 * time.Millisecond)
+
+	const committers = 10
+	start := lm.PageWrites()
+	var wg sync.WaitGroup
+	errs := make(chan error, committers)
+	for i := 0; i < committers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lsn, err := lm.Append(createLogRecord(fmt.Sprint("commit", i), int32(i)))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := lm.FlushCommit(lsn); err != nil {
+				errs <- err
+				return
+			}
+			lm.mu.Lock()
+			saved := lm.lastSavedLSN
+			lm.mu.Unlock()
+			if saved < lsn {
+				errs <- fmt.Errorf("flush of LSN %d returned, but only LSN %d is saved", lsn, saved)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	if writes := lm.PageWrites() - start; writes >= committers {
+		t.Errorf("Expected the flushes to share page writes, but there were %d writes", writes)
+	}
+	// Every record is on disk, without flushing the log again.
+	li, err := NewLogIterator(lm.fm, lm.currentblk, lm.firstSegment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for li.HasNext() {
+		if _, err := li.Next(); err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+	if count != committers {
+		t.Fatalf("Expected %d records on disk, but got %d", committers, count)
+	}
+
+	// A write-ahead flush, such as one for a buffer being evicted, doesn't
+	// wait for the window, even while a commit is waiting for it.
+	lm.SetGroupCommitWindow(time.Second)
+	commitLSN, err := lm.Append(createLogRecord("commit", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed := make(chan error, 1)
+	go func() {
+		committed <- lm.FlushCommit(commitLSN)
+	}()
+	lsn, err := lm.Append(createLogRecord("update", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	begin := time.Now()
+	if err := lm.Flush(lsn); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed >= 500*time.Millisecond {
+		t.Errorf("Expected Flush to write immediately, but it took %v", elapsed)
+	}
+	// The commit record was written along with the update.
+	select {
+	case err := <-committed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Error("Expected the waiting commit to return once its record was flushed")
+	}
+}
+
+func TestLogMgrSegments(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("logsegmenttest")
+	})
+
+	lm := createLogMgr(t, "logsegmenttest", 400)
+	lm.SetSegmentBlocks(2)
+	lm.SetArchiveDir("archive")
+	// Each block holds about 15 records, so 100 records fill several
+	// segments.
+	createRecords(t, lm, 1, 89)
+	lsn90, err := lm.Append(createLogRecord("record90", 190))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createRecords(t, lm, 91, 100)
+	before, err := lm.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Segments < 3 {
+		t.Fatalf("Expected at least 3 segments, but got %d", before.Segments)
+	}
+	if before.Bytes != int64(before.Blocks)*400 || before.LatestLSN <= lsn90 {
+		t.Fatalf("Unexpected log stats: %+v", before)
+	}
+
+	// The iterator reads across segments.
+	want := 100
+	for rec, err := range lm.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := file.NewPageFromBytes(rec).GetString(0); s != fmt.Sprint("record", want) {
+			t.Fatalf("Expected record%d, but got %q", want, s)
+		}
+		want--
+	}
+	if want != 0 {
+		t.Fatalf("Expected to read back to record1, but stopped before record%d", want)
+	}
+
+	if err := lm.TruncateBefore(lsn90); err != nil {
+		t.Fatal(err)
+	}
+	after, err := lm.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Segments >= before.Segments || after.OldestNeededLSN != lsn90 {
+		t.Fatalf("Expected the log to be truncated, but got %+v", after)
+	}
+	archived, err := os.ReadDir("logsegmenttest/archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != before.Segments-after.Segments {
+		t.Fatalf("Expected %d archived segments, but got %d", before.Segments-after.Segments, len(archived))
+	}
+	if archived[0].Name() != SegmentFileName(DefaultLogFile, 1) {
+		t.Fatalf("Expected the first segment to be archived, but got %s", archived[0].Name())
+	}
+
+	// The records recovery needs are still readable, also after reopening.
+	check := func(lm *LogMgr) {
+		t.Helper()
+		want := 100
+		for rec, err := range lm.All() {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := file.NewPageFromBytes(rec).GetString(0); s != fmt.Sprint("record", want) {
+				t.Fatalf("Expected record%d, but got %q", want, s)
+			}
+			want--
+		}
+		if want >= 90 {
+			t.Fatalf("Expected to read back past record90, but stopped before record%d", want)
+		}
+	}
+	check(lm)
+	closeLogMgr(lm)
+
+	lm = createLogMgr(t, "logsegmenttest", 400)
+	defer closeLogMgr(lm)
+	reopened, err := lm.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Segments != after.Segments || reopened.Blocks != after.Blocks {
+		t.Fatalf("Expected %+v after reopening, but got %+v", after, reopened)
+	}
+	check(lm)
+}
+
+func TestLogMgrForward(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("logforwardtest")
+	})
+
+	lm := createLogMgr(t, "logforwardtest", 400)
+	lm.SetSegmentBlocks(2)
+	var lsns []int
+	appendRecords := func(start, end int) {
+		t.Helper()
+		for i := start; i <= end; i++ {
+			lsn, err := lm.Append(createLogRecord(fmt.Sprint("record", i), int32(i+100)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lsns) > 0 && lsn <= lsns[len(lsns)-1] {
+				t.Fatalf("Expected LSN %s to follow %s", FormatLSN(lsn), FormatLSN(lsns[len(lsns)-1]))
+			}
+			lsns = append(lsns, lsn)
+		}
+	}
+	// checkFrom reads the log forwards from the i-th record.
+	checkFrom := func(i int) {
+		t.Helper()
+		for rec, err := range lm.From(lsns[i]) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.LSN != lsns[i] {
+				t.Fatalf("Expected LSN %s, but got %s", FormatLSN(lsns[i]), FormatLSN(rec.LSN))
+			}
+			if s := file.NewPageFromBytes(rec.Bytes).GetString(0); s != fmt.Sprint("record", i+1) {
+				t.Fatalf("Expected record%d, but got %q", i+1, s)
+			}
+			i++
+		}
+		if i != len(lsns) {
+			t.Fatalf("Expected to read up to record%d, but stopped after record%d", len(lsns), i)
+		}
+	}
+
+	appendRecords(1, 50)
+	checkFrom(0)
+	checkFrom(20)
+	checkFrom(49)
+	closeLogMgr(lm)
+
+	// LSNs carry on from where they left off after a restart.
+	lm = createLogMgr(t, "logforwardtest", 400)
+	defer closeLogMgr(lm)
+	appendRecords(51, 80)
+	checkFrom(0)
+	checkFrom(35)
+	for rec, err := range lm.From(lsns[len(lsns)-1] + 1) {
+		t.Fatalf("Expected no records after the last one, but got %v, %v", rec, err)
+	}
+
+	// The records before a truncation point are no longer returned.
+	if err := lm.TruncateBefore(lsns[60]); err != nil {
+		t.Fatal(err)
+	}
+	segment, _, _ := SplitLSN(lsns[60])
+	for rec, err := range lm.From(0) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s, _, _ := SplitLSN(rec.LSN); s != segment {
+			t.Fatalf("Expected to start in segment %d, but got LSN %s", segment, FormatLSN(rec.LSN))
+		}
+		break
+	}
+}
+
+func TestLogMgrUnsegmentedLog(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("logunsegmentedtest")
+	})
+
+	lm := createLogMgr(t, "logunsegmentedtest", 400)
+	createRecords(t, lm, 1, 40)
+	if err := lm.Flush(lm.latestLSN); err != nil {
+		t.Fatal(err)
+	}
+	closeLogMgr(lm)
+	segfile := "logunsegmentedtest/" + SegmentFileName(DefaultLogFile, 1)
+	logfile := "logunsegmentedtest/" + DefaultLogFile
+	if err := os.Rename(segfile, logfile); err != nil {
+		t.Fatal(err)
+	}
+
+	// Damage the first block, which isn't the last one, so it can't be
+	// torn.
+	f, err := os.OpenFile(logfile, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0, 0, 0, 99}, 396); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	fm, err := file.NewFileMgr("logunsegmentedtest", 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLogMgr(fm, DefaultLogFile); err == nil {
+		t.Fatal("Expected an error opening a corrupt log")
+	}
+	fm.Close()
+	if _, err := os.Stat(logfile); err != nil {
+		t.Fatalf("Expected the log file to be left in place: %v", err)
+	}
+	if _, err := os.Stat(segfile); !os.IsNotExist(err) {
+		t.Fatalf("Expected no segment file, but got %v", err)
+	}
+
+}
@@ -0,0 +1,184 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"simpledb/internal/file"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultSegmentBlocks is the number of blocks in each log segment, unless
+// changed with SetSegmentBlocks.
+const DefaultSegmentBlocks = 64
+
+// SegmentFileName returns the name of the file that holds the specified
+// segment of a log.
+// The log is split into segments that are numbered from 1, so that the
+// segments that recovery no longer needs can be removed.
+func SegmentFileName(logfile string, segment int) string {
+	return fmt.Sprintf("%s.%06d", logfile, segment)
+}
+
+// parseSegmentFileName splits the name of a segment file into the name of
+// the log and the segment number.
+func parseSegmentFileName(filename string) (string, int, bool) {
+	i := strings.LastIndexByte(filename, '.')
+	if i < 0 {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(filename[i+1:])
+	if err != nil || n <= 0 {
+		return "", 0, false
+	}
+	return filename[:i], n, true
+}
+
+// LogStats describes the size of the log.
+type LogStats struct {
+	// Segments is the number of segment files in the log.
+	Segments int
+	// Blocks is the number of blocks in those segments.
+	Blocks int
+	// Bytes is the size of those blocks.
+	Bytes int64
+	// OldestNeededLSN is the LSN of the oldest record that recovery may
+	// need, which is the last checkpoint that the log was truncated at, or
+	// the start of the oldest segment if it hasn't been truncated since the
+	// log manager was created.
+	OldestNeededLSN int
+	// LatestLSN is the LSN of the most recently appended record.
+	LatestLSN int
+}
+
+// SetSegmentBlocks sets the number of blocks in each new log segment,
+// which is at most MaxSegmentBlocks.
+func (lm *LogMgr) SetSegmentBlocks(n int) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.segmentBlocks = min(max(n, 1), MaxSegmentBlocks)
+}
+
+// SetArchiveDir sets the directory, relative to the database directory,
+// that segments are moved to when the log is truncated. If dir is "", the
+// default, the segments are removed instead.
+func (lm *LogMgr) SetArchiveDir(dir string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.archiveDir = dir
+}
+
+// TruncateBefore removes the segments that only hold records older than
+// the specified LSN, or moves them to the archive directory if one is set.
+// It is called with the LSN of a checkpoint, once the checkpoint has been
+// flushed, since recovery never reads past a checkpoint.
+func (lm *LogMgr) TruncateBefore(lsn int) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	// Keep the segment holding the record with the specified LSN.
+	keep, _, _ := SplitLSN(lsn)
+	keep = min(keep, lm.segment)
+	for lm.firstSegment < keep {
+		name := SegmentFileName(lm.logfile, lm.firstSegment)
+		var err error
+		if lm.archiveDir != "" {
+			err = lm.fm.Rename(name, filepath.Join(lm.archiveDir, name))
+		} else {
+			err = lm.fm.Remove(name)
+		}
+		if err != nil {
+			return err
+		}
+		lm.firstSegment++
+	}
+	lm.oldestNeededLSN = max(lm.oldestNeededLSN, lsn)
+	return nil
+}
+
+// Stats returns the current size of the log.
+func (lm *LogMgr) Stats() (LogStats, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	s := LogStats{
+		Segments:        lm.segment - lm.firstSegment + 1,
+		OldestNeededLSN: lm.oldestNeededLSN,
+		LatestLSN:       lm.latestLSN,
+	}
+	for seg := lm.firstSegment; seg <= lm.segment; seg++ {
+		n, err := lm.fm.Length(SegmentFileName(lm.logfile, seg))
+		if err != nil {
+			return LogStats{}, err
+		}
+		s.Blocks += n
+	}
+	s.Bytes = int64(s.Blocks) * int64(lm.fm.BlockSize)
+	return s, nil
+}
+
+// findSegments returns the numbers of the log's segment files, in order.
+// A log file written before logs were segmented becomes the first segment,
+// once its blocks have been checked, so that a log that can't be read is
+// left as it was.
+func (lm *LogMgr) findSegments() ([]int, error) {
+	names, err := lm.fm.Files(lm.logfile)
+	if err != nil {
+		return nil, err
+	}
+	var segments []int
+	legacy := false
+	for _, name := range names {
+		if name == lm.logfile {
+			legacy = true
+			continue
+		}
+		if logfile, n, ok := parseSegmentFileName(name); ok && logfile == lm.logfile {
+			segments = append(segments, n)
+		}
+	}
+	if legacy && len(segments) == 0 {
+		if err := lm.checkBlocks(lm.logfile); err != nil {
+			return nil, err
+		}
+		if err := lm.fm.Rename(lm.logfile, SegmentFileName(lm.logfile, 1)); err != nil {
+			return nil, err
+		}
+		segments = append(segments, 1)
+	}
+	slices.Sort(segments)
+	return segments, nil
+}
+
+// checkBlocks checks that every block of the specified log file holds
+// complete records, except for the last block, which may end in a torn
+// record.
+func (lm *LogMgr) checkBlocks(filename string) error {
+	n, err := lm.fm.Length(filename)
+	if err != nil {
+		return err
+	}
+	p := file.NewPageFromBytes(make([]byte, lm.fm.BlockSize))
+	for blknum := 0; blknum < n; blknum++ {
+		blk := file.NewBlockID(filename, blknum)
+		err := lm.fm.Read(blk, p)
+		if blknum == n-1 {
+			// truncateTornTail repairs the last block.
+			var cerr *file.CorruptPageError
+			if errors.As(err, &cerr) {
+				return nil
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		positions, pos := blockRecords(p, lm.fm.BlockSize)
+		if pos != int(p.GetInt(0)) || len(positions) == 0 {
+			return fmt.Errorf("corrupt log block %s", blk)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"simpledb/internal/file"
+)
+
+// FormatVersion is the version of the log format that the log manager
+// writes. Version 1 is the format of logs written before log records had
+// checksums: a single file whose records are stored as their length
+// followed by their bytes. Such a log can't be opened by the log manager;
+// it has to be replaced by a new log once it holds no unfinished
+// transactions.
+const FormatVersion = 2
+
+// Version returns the version of the format of the specified log. If the
+// control file doesn't record it, the version is worked out from the log's
+// files: a log that has been split into segments, or that doesn't exist
+// yet, has the current format.
+func Version(fm *file.FileMgr, logfile string) (int, error) {
+	if v := fm.LogVersion(); v > 0 {
+		return v, nil
+	}
+	names, err := fm.Files(logfile)
+	if err != nil {
+		return 0, err
+	}
+	legacy := false
+	for _, name := range names {
+		if name == logfile {
+			legacy = true
+		} else if l, _, ok := parseSegmentFileName(name); ok && l == logfile {
+			return FormatVersion, nil
+		}
+	}
+	if !legacy {
+		return FormatVersion, nil
+	}
+	n, err := fm.Length(logfile)
+	if err != nil || n == 0 {
+		return FormatVersion, err
+	}
+
+	// The first block of the log tells which format its records have.
+	p := file.NewPageFromBytes(make([]byte, fm.BlockSize))
+	err = fm.Read(file.NewBlockID(logfile, 0), p)
+	var cerr *file.CorruptPageError
+	if err != nil && !errors.As(err, &cerr) {
+		return 0, err
+	}
+	if _, pos := blockRecords(p, fm.BlockSize); pos == int(p.GetInt(0)) {
+		return FormatVersion, nil
+	}
+	if _, ok := legacyBlockRecords(p, fm.BlockSize); ok {
+		return 1, nil
+	}
+	// The block may be the last of the log, with a torn record.
+	return FormatVersion, nil
+}
+
+// LegacyRecords returns the records of a log with format version 1, newest
+// first.
+func LegacyRecords(fm *file.FileMgr, logfile string) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		n, err := fm.Length(logfile)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		p := file.NewPageFromBytes(make([]byte, fm.BlockSize))
+		for blknum := n - 1; blknum >= 0; blknum-- {
+			blk := file.NewBlockID(logfile, blknum)
+			if err := fm.Read(blk, p); err != nil {
+				yield(nil, err)
+				return
+			}
+			positions, ok := legacyBlockRecords(p, fm.BlockSize)
+			if !ok {
+				yield(nil, fmt.Errorf("corrupt log block %s", blk))
+				return
+			}
+			for _, pos := range positions {
+				if !yield(p.GetBytes(pos), nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// legacyBlockRecords returns the positions of the records in a block of a
+// log with format version 1, from newest to oldest. It returns false if the
+// records don't fill the block from its boundary to its end, which means
+// the block doesn't have that format.
+func legacyBlockRecords(p *file.Page, blocksize int) ([]int, bool) {
+	var positions []int
+	pos := int(p.GetInt(0))
+	if pos < 4 {
+		return nil, false
+	}
+	for pos < blocksize {
+		n := int(p.GetInt(pos))
+		if n < 0 || pos+4+n > blocksize {
+			return nil, false
+		}
+		positions = append(positions, pos)
+		pos += 4 + n
+	}
+	return positions, pos == blocksize
+}
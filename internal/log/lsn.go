@@ -0,0 +1,46 @@
+package log
+
+import "fmt"
+
+// An LSN (log sequence number) identifies a log record by where it is
+// stored: the segment, the block within the segment, and the record's
+// distance from the end of the block. Since records are written right to
+// left within a block, LSNs increase in the order that records are
+// appended, and they stay the same when the database is restarted.
+//
+// The LSN 0 doesn't identify any record, since segments are numbered from 1.
+const (
+	lsnOffsetBits = 20
+	lsnBlockBits  = 20
+	// MaxSegmentBlocks is the largest number of blocks that a segment can
+	// hold, and still have an LSN for each of its records.
+	MaxSegmentBlocks = 1 << lsnBlockBits
+	// MaxLogBlockSize is the largest block size that a log can use.
+	MaxLogBlockSize = 1 << lsnOffsetBits
+)
+
+// NewLSN returns the LSN of the record at the specified offset from the end
+// of the specified block of a segment.
+func NewLSN(segment, blknum, offset int) int {
+	return segment<<(lsnBlockBits+lsnOffsetBits) | blknum<<lsnOffsetBits | offset
+}
+
+// SplitLSN returns the segment, block number and offset that an LSN is
+// made of.
+func SplitLSN(lsn int) (segment, blknum, offset int) {
+	return lsn >> (lsnBlockBits + lsnOffsetBits),
+		lsn >> lsnOffsetBits & (MaxSegmentBlocks - 1),
+		lsn & (MaxLogBlockSize - 1)
+}
+
+// FormatLSN returns a readable form of an LSN, as segment/block/offset.
+func FormatLSN(lsn int) string {
+	segment, blknum, offset := SplitLSN(lsn)
+	return fmt.Sprintf("%d/%d/%d", segment, blknum, offset)
+}
+
+// Record is a log record, together with its LSN.
+type Record struct {
+	LSN   int
+	Bytes []byte
+}
@@ -0,0 +1,142 @@
+package metadata
+
+import (
+	"simpledb/internal/record"
+	"simpledb/internal/tx"
+	"sync"
+)
+
+// catalogKind identifies the kind of catalog entry held by a cache key.
+type catalogKind int
+
+const (
+	layoutEntry catalogKind = iota
+	viewEntry
+	indexEntry
+)
+
+// catalogKey identifies a cached catalog entry: the layout of a table,
+// the definition of a view, or the indexes on a table.
+type catalogKey struct {
+	kind catalogKind
+	name string
+}
+
+// catalogCache holds the table layouts, view definitions and index
+// definitions read from the catalog tables, so that planning a statement
+// does not need to scan the catalog.
+//
+// The cache only holds committed metadata. When a transaction changes the
+// catalog, its changed entries are marked as pending: the transaction
+// reads them from the catalog tables, where it sees its own changes, and
+// other transactions keep using the cached entries. If the transaction
+// commits, its pending entries are removed from the cache and reloaded on
+// their next use. If it rolls back, the cached entries are still correct.
+type catalogCache struct {
+	mu      sync.Mutex
+	entries map[catalogKey]interface{}
+	pending map[*tx.Transaction]map[catalogKey]bool
+	// gen is incremented whenever entries are invalidated, so that an
+	// entry loaded before an invalidation is not stored after it.
+	gen int
+}
+
+// newCatalogCache creates a new, empty catalog cache.
+func newCatalogCache() *catalogCache {
+	return &catalogCache{
+		entries: make(map[catalogKey]interface{}),
+		pending: make(map[*tx.Transaction]map[catalogKey]bool),
+	}
+}
+
+// get returns the cached entry for the specified key, calling load to read
+// it from the catalog if it is not cached. Entries that the transaction has
+// changed are always read from the catalog, and are not cached.
+func (cc *catalogCache) get(key catalogKey, tx *tx.Transaction, load func() (interface{}, error)) (interface{}, error) {
+	cc.mu.Lock()
+	if cc.pending[tx][key] {
+		cc.mu.Unlock()
+		return load()
+	}
+	if v, ok := cc.entries[key]; ok {
+		cc.mu.Unlock()
+		return v, nil
+	}
+	gen := cc.gen
+	cc.mu.Unlock()
+
+	v, err := load()
+	if err != nil {
+		return nil, err
+	}
+	cc.mu.Lock()
+	if cc.gen == gen {
+		cc.entries[key] = v
+	}
+	cc.mu.Unlock()
+	return v, nil
+}
+
+// changed marks the entry for the specified key as changed by the
+// transaction. The entry is invalidated if the transaction commits.
+func (cc *catalogCache) changed(key catalogKey, tx *tx.Transaction) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	keys, ok := cc.pending[tx]
+	if !ok {
+		keys = make(map[catalogKey]bool)
+		cc.pending[tx] = keys
+		tx.OnEnd(func(committed bool) {
+			cc.end(tx, committed)
+		})
+	}
+	keys[key] = true
+}
+
+// end removes the entries changed by the transaction from the cache if it
+// committed, and forgets its pending changes.
+func (cc *catalogCache) end(tx *tx.Transaction, committed bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if committed {
+		for key := range cc.pending[tx] {
+			delete(cc.entries, key)
+		}
+		cc.gen++
+	}
+	delete(cc.pending, tx)
+}
+
+// layout returns the cached layout of the specified table.
+func (cc *catalogCache) layout(tblname string, tx *tx.Transaction) (*record.Layout, error) {
+	v, err := cc.get(catalogKey{layoutEntry, tblname}, tx, func() (interface{}, error) {
+		return tblMgr.GetLayout(tblname, tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*record.Layout), nil
+}
+
+// viewDef returns the cached definition of the specified view.
+func (cc *catalogCache) viewDef(viewname string, tx *tx.Transaction) (string, error) {
+	v, err := cc.get(catalogKey{viewEntry, viewname}, tx, func() (interface{}, error) {
+		return viewMgr.GetViewDef(viewname, tx)
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// indexDefs returns the cached definitions of the indexes on the specified
+// table.
+func (cc *catalogCache) indexDefs(tblname string, tx *tx.Transaction) ([]indexDef, error) {
+	v, err := cc.get(catalogKey{indexEntry, tblname}, tx, func() (interface{}, error) {
+		return idxMgr.indexDefs(tblname, tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]indexDef), nil
+}
@@ -0,0 +1,113 @@
+package metadata_test
+
+import (
+	"os"
+	"simpledb/internal/record"
+	"simpledb/internal/server"
+	"simpledb/internal/tx"
+	"testing"
+)
+
+func TestCatalogCache(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("catalogcachetest")
+	})
+
+	db, err := server.NewSimpleDB("catalogcachetest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	mdm := db.MetadataMgr
+
+	newTx := func() *tx.Transaction {
+		t.Helper()
+		tx, err := db.NewTx()
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		return tx
+	}
+	// lookup reads all of the cached metadata for table T and view V.
+	lookup := func(tx *tx.Transaction) (int, string) {
+		t.Helper()
+		if _, err := mdm.GetLayout("T", tx); err != nil {
+			t.Fatalf("Failed to get layout: %v", err)
+		}
+		viewdef, err := mdm.GetViewDef("V", tx)
+		if err != nil {
+			t.Fatalf("Failed to get view definition: %v", err)
+		}
+		indexes, err := mdm.GetIndexInfo("T", tx)
+		if err != nil {
+			t.Fatalf("Failed to get index info: %v", err)
+		}
+		return len(indexes), viewdef
+	}
+
+	tx1 := newTx()
+	sch := record.NewSchema()
+	sch.AddIntField("A")
+	if err := mdm.CreateTable("T", sch, tx1); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	// Once the metadata is cached, looking it up reads no blocks.
+	tx2 := newTx()
+	lookup(tx2)
+	pinned := tx2.BlocksPinned()
+	if n, viewdef := lookup(tx2); n != 0 || viewdef != "" {
+		t.Fatalf("Expected no indexes or view, got %d indexes and view %q", n, viewdef)
+	}
+	if tx2.BlocksPinned() != pinned {
+		t.Fatalf("Expected cached lookups to pin no blocks, but %d were pinned", tx2.BlocksPinned()-pinned)
+	}
+	if err := tx2.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	// A transaction sees its own catalog changes, and rolling it back
+	// leaves the cache as it was.
+	tx3 := newTx()
+	if err := mdm.CreateIndex("TA", "T", "A", tx3); err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	if err := mdm.CreateView("V", "select A from T", tx3); err != nil {
+		t.Fatalf("Failed to create view: %v", err)
+	}
+	if n, viewdef := lookup(tx3); n != 1 || viewdef == "" {
+		t.Fatalf("Expected 1 index and a view, got %d indexes and view %q", n, viewdef)
+	}
+	if err := tx3.Rollback(); err != nil {
+		t.Fatalf("Failed to roll back transaction: %v", err)
+	}
+	tx4 := newTx()
+	if n, viewdef := lookup(tx4); n != 0 || viewdef != "" {
+		t.Fatalf("Expected no indexes or view after rollback, got %d indexes and view %q", n, viewdef)
+	}
+	if err := tx4.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	// Committing the changes invalidates the cached entries.
+	tx5 := newTx()
+	if err := mdm.CreateIndex("TA", "T", "A", tx5); err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	if err := mdm.CreateView("V", "select A from T", tx5); err != nil {
+		t.Fatalf("Failed to create view: %v", err)
+	}
+	if err := tx5.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+	tx6 := newTx()
+	if n, viewdef := lookup(tx6); n != 1 || viewdef != "select A from T" {
+		t.Fatalf("Expected 1 index and view V, got %d indexes and view %q", n, viewdef)
+	}
+	if err := tx6.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
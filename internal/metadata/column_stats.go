@@ -0,0 +1,189 @@
+package metadata
+
+import (
+	"math/rand/v2"
+	"simpledb/internal/record"
+	"slices"
+)
+
+const (
+	// NumHistogramBuckets is the number of buckets in an equi-depth histogram.
+	NumHistogramBuckets = 10
+	// statSampleSize is the number of values per column that ANALYZE keeps
+	// in its reservoir sample when building histograms.
+	statSampleSize = 1000
+)
+
+// Bucket is one bucket of an equi-depth histogram. It covers the values
+// greater than the previous bucket's upper bound, up to and including Hi.
+type Bucket struct {
+	// Hi is the largest value in the bucket.
+	Hi record.Constant
+	// NumRecs is the number of records whose value falls in the bucket.
+	NumRecs int
+	// Distinct is the number of distinct values in the bucket.
+	Distinct int
+}
+
+// ColumnStats contains the statistics gathered by ANALYZE for one field
+// of a table.
+type ColumnStats struct {
+	// NumRecs is the number of records in the table when it was analyzed.
+	NumRecs int
+	// Distinct is the estimated number of distinct values in the field.
+	Distinct int
+	// Min and Max are the smallest and largest values in the field.
+	Min, Max record.Constant
+	// Histogram is an equi-depth histogram of the field's values,
+	// ordered by upper bound.
+	Histogram []Bucket
+}
+
+// RecordsWithValue estimates how many of the analyzed records have the
+// specified value, by looking up the histogram bucket containing it and
+// assuming the values within a bucket are evenly distributed.
+// Long strings are compared by the prefix that the statistics keep.
+func (cs *ColumnStats) RecordsWithValue(val record.Constant) int {
+	if cs.NumRecs == 0 || val.Type() != cs.Min.Type() {
+		return 0
+	}
+	val = truncateStatVal(val)
+	if val.Compare(cs.Min) < 0 || val.Compare(cs.Max) > 0 {
+		return 0
+	}
+	for _, b := range cs.Histogram {
+		if val.Compare(b.Hi) <= 0 {
+			return max(1, b.NumRecs/max(1, b.Distinct))
+		}
+	}
+	return max(1, cs.NumRecs/max(1, cs.Distinct))
+}
+
+// columnCollector accumulates the values of one field during ANALYZE.
+// It keeps a HyperLogLog sketch for the distinct count and a fixed-size
+// reservoir sample for the histogram, so its memory use is bounded.
+type columnCollector struct {
+	hll      *HyperLogLog
+	sample   []record.Constant
+	seen     int
+	min, max record.Constant
+	rnd      *rand.Rand
+}
+
+// newColumnCollector creates a new, empty collector.
+func newColumnCollector() *columnCollector {
+	return &columnCollector{
+		hll: NewHyperLogLog(),
+		rnd: rand.New(rand.NewPCG(1, 2)),
+	}
+}
+
+// add adds a field value to the collector.
+func (cc *columnCollector) add(val record.Constant) {
+	if cc.seen == 0 || val.Compare(cc.min) < 0 {
+		cc.min = val
+	}
+	if cc.seen == 0 || val.Compare(cc.max) > 0 {
+		cc.max = val
+	}
+	cc.seen++
+	cc.hll.Add(val)
+	if len(cc.sample) < statSampleSize {
+		cc.sample = append(cc.sample, val)
+	} else if i := cc.rnd.IntN(cc.seen); i < statSampleSize {
+		cc.sample[i] = val
+	}
+}
+
+// stats builds the column statistics from the collected values.
+func (cc *columnCollector) stats() *ColumnStats {
+	cs := &ColumnStats{
+		NumRecs:  cc.seen,
+		Distinct: min(cc.hll.Estimate(), cc.seen),
+		Min:      cc.min,
+		Max:      cc.max,
+	}
+	n := len(cc.sample)
+	if n == 0 {
+		return cs
+	}
+	vals := slices.Clone(cc.sample)
+	slices.SortFunc(vals, record.Constant.Compare)
+	sampled := n < cc.seen
+	scale := float64(cc.seen) / float64(n)
+	numBuckets := min(NumHistogramBuckets, n)
+	start := 0
+	for b := 1; b <= numBuckets && start < n; b++ {
+		end := b * n / numBuckets
+		if end <= start {
+			continue
+		}
+		hi := vals[end-1]
+		// Keep all copies of a value in the same bucket.
+		for end < n && vals[end].Equal(hi) {
+			end++
+		}
+		distinct := 1
+		for i := start + 1; i < end; i++ {
+			if !vals[i].Equal(vals[i-1]) {
+				distinct++
+			}
+		}
+		if sampled {
+			// A sample undercounts the distinct values in a bucket, so
+			// assume the bucket holds its share of the column's values.
+			distinct = max(distinct, cs.Distinct*(end-start)/n)
+		}
+		cs.Histogram = append(cs.Histogram, Bucket{
+			Hi:       hi,
+			NumRecs:  int(float64(end-start)*scale + 0.5),
+			Distinct: distinct,
+		})
+		start = end
+	}
+	return cs
+}
+
+// extrapolate scales statistics computed from a sample of the table's
+// records up to the estimated number of records in the whole table.
+// If nearly every sampled value is distinct, the field is assumed to be a
+// key whose number of distinct values grows with the table; otherwise the
+// sample is assumed to have seen most of the field's values.
+func (cs *ColumnStats) extrapolate(total int) {
+	if cs.NumRecs == 0 || total <= cs.NumRecs {
+		return
+	}
+	scale := float64(total) / float64(cs.NumRecs)
+	unique := cs.Distinct*10 >= cs.NumRecs*9
+	if unique {
+		cs.Distinct = int(float64(cs.Distinct) * scale)
+	}
+	for i := range cs.Histogram {
+		b := &cs.Histogram[i]
+		b.NumRecs = int(float64(b.NumRecs)*scale + 0.5)
+		if unique {
+			b.Distinct = int(float64(b.Distinct) * scale)
+		}
+	}
+	cs.NumRecs = total
+}
+
+// truncateValues shortens the string values of the statistics to the
+// length they are stored with in the catalog, so that they compare the
+// same way before and after being reloaded.
+func (cs *ColumnStats) truncateValues() {
+	cs.Min = truncateStatVal(cs.Min)
+	cs.Max = truncateStatVal(cs.Max)
+	for i := range cs.Histogram {
+		cs.Histogram[i].Hi = truncateStatVal(cs.Histogram[i].Hi)
+	}
+}
+
+// truncateStatVal returns the prefix of a string value that is stored in
+// the statistics catalog. Other values are returned unchanged.
+func truncateStatVal(val record.Constant) record.Constant {
+	if val.Type() == record.String && len(val.AsString()) > MaxStatValLen {
+		return record.NewStringConstant(val.AsString()[:MaxStatValLen])
+	}
+	return val
+}
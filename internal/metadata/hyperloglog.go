@@ -0,0 +1,73 @@
+package metadata
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"simpledb/internal/record"
+)
+
+// hllPrecision is the number of hash bits used to choose a register.
+const hllPrecision = 10
+
+// hllRegisters is the number of registers in a HyperLogLog sketch.
+const hllRegisters = 1 << hllPrecision
+
+// HyperLogLog estimates the number of distinct values added to it,
+// using a fixed amount of memory regardless of how many values there are.
+// With 1024 registers the typical error is around 3%.
+type HyperLogLog struct {
+	registers [hllRegisters]uint8
+}
+
+// NewHyperLogLog creates a new, empty HyperLogLog sketch.
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{}
+}
+
+// Add adds a value to the sketch.
+func (h *HyperLogLog) Add(val record.Constant) {
+	x := hashConstant(val)
+	idx := x >> (64 - hllPrecision)
+	rest := x<<hllPrecision | 1<<(hllPrecision-1) // guard bit bounds the rank
+	rank := uint8(bits.LeadingZeros64(rest)) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// Estimate returns the estimated number of distinct values added to the
+// sketch. Small cardinalities are estimated by linear counting, which is
+// exact or nearly so for the table sizes typical of this system.
+func (h *HyperLogLog) Estimate() int {
+	m := float64(hllRegisters)
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(estimate))
+}
+
+// hashConstant returns a well-mixed 64-bit hash of a constant.
+// FNV alone does not spread short keys across the high bits well enough
+// for HyperLogLog, so its output is passed through a finalizer.
+func hashConstant(val record.Constant) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(val.String()))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
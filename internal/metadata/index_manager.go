@@ -130,7 +130,25 @@ func (im *IndexMgr) CreateIndex(idxname, tblname, fldname string, tx *tx.Transac
 
 // GetIndexInfo returns a map containing the index info for all indices.
 func (im *IndexMgr) GetIndexInfo(tblname string, tx *tx.Transaction) (map[string]*IndexInfo, error) {
-	var result = make(map[string]*IndexInfo)
+	defs, err := im.indexDefs(tblname, tx)
+	if err != nil || len(defs) == 0 {
+		return make(map[string]*IndexInfo), err
+	}
+	tblLayout, err := im.tm.GetLayout(tblname, tx)
+	if err != nil {
+		return nil, err
+	}
+	return im.indexInfo(tblname, tblLayout, defs, tx)
+}
+
+// indexDef describes an index, as recorded in the idxcat table.
+type indexDef struct {
+	idxname, fldname string
+}
+
+// indexDefs returns the definitions of all indexes on the specified table.
+func (im *IndexMgr) indexDefs(tblname string, tx *tx.Transaction) ([]indexDef, error) {
+	var defs []indexDef
 	ts, err := record.NewTableScan(tx, "idxcat", im.layout)
 	if err != nil {
 		return nil, err
@@ -150,20 +168,26 @@ func (im *IndexMgr) GetIndexInfo(tblname string, tx *tx.Transaction) (map[string
 			if err != nil {
 				return nil, err
 			}
-			tblLayout, err := im.tm.GetLayout(tblname, tx)
-			if err != nil {
-				return nil, err
-			}
-			tblStats, err := im.sm.GetStatInfo(tblname, tblLayout, tx)
-			if err != nil {
-				return nil, err
-			}
-			ii, err := NewIndexInfo(idxname, fldname, tblLayout.Schema, tx, tblStats)
-			if err != nil {
-				return nil, err
-			}
-			result[fldname] = ii
+			defs = append(defs, indexDef{idxname, fldname})
+		}
+	}
+	return defs, nil
+}
+
+// indexInfo creates the index info for the specified indexes on a table,
+// keyed by the indexed field.
+func (im *IndexMgr) indexInfo(tblname string, tblLayout *record.Layout, defs []indexDef, tx *tx.Transaction) (map[string]*IndexInfo, error) {
+	var result = make(map[string]*IndexInfo)
+	tblStats, err := im.sm.GetStatInfo(tblname, tblLayout, tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, def := range defs {
+		ii, err := NewIndexInfo(def.idxname, def.fldname, tblLayout.Schema, tx, tblStats)
+		if err != nil {
+			return nil, err
 		}
+		result[def.fldname] = ii
 	}
 	return result, nil
 }
@@ -1,6 +1,7 @@
 package metadata
 
 import (
+	"fmt"
 	"simpledb/internal/record"
 	"simpledb/internal/tx"
 )
@@ -10,7 +11,16 @@ var viewMgr *ViewMgr
 var idxMgr *IndexMgr
 var statMgr *StatMgr
 
-type MetadataMgr struct{}
+// MetadataMgr provides access to the database's metadata: table layouts,
+// views, indexes and statistics. Layouts, view definitions and index
+// definitions are cached, so that planning a statement does not need to
+// scan the catalog tables.
+type MetadataMgr struct {
+	cache        *catalogCache
+	systemTables map[string]SystemTable
+}
+
+var _ tx.TableChangeListener = (*MetadataMgr)(nil)
 
 func NewMetadataMgr(isNew bool, tx *tx.Transaction) (*MetadataMgr, error) {
 	var err error
@@ -22,7 +32,7 @@ func NewMetadataMgr(isNew bool, tx *tx.Transaction) (*MetadataMgr, error) {
 	if err != nil {
 		return nil, err
 	}
-	statMgr, err = NewStatMgr(tblMgr, tx)
+	statMgr, err = NewStatMgr(isNew, tblMgr, tx)
 	if err != nil {
 		return nil, err
 	}
@@ -30,33 +40,116 @@ func NewMetadataMgr(isNew bool, tx *tx.Transaction) (*MetadataMgr, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &MetadataMgr{}, nil
+	return &MetadataMgr{cache: newCatalogCache(), systemTables: make(map[string]SystemTable)}, nil
 }
 
 func (mm *MetadataMgr) CreateTable(tblname string, sch *record.Schema, tx *tx.Transaction) error {
+	if _, ok := mm.systemTables[tblname]; ok {
+		return fmt.Errorf("table %s already exists as a system table", tblname)
+	}
+	mm.cache.changed(catalogKey{layoutEntry, tblname}, tx)
 	return tblMgr.CreateTable(tblname, sch, tx)
 }
 
 func (mm *MetadataMgr) GetLayout(tblname string, tx *tx.Transaction) (*record.Layout, error) {
-	return tblMgr.GetLayout(tblname, tx)
+	return mm.cache.layout(tblname, tx)
 }
 
 func (mm *MetadataMgr) CreateView(viewname string, viewdef string, tx *tx.Transaction) error {
+	mm.cache.changed(catalogKey{viewEntry, viewname}, tx)
 	return viewMgr.CreateView(viewname, viewdef, tx)
 }
 
 func (mm *MetadataMgr) GetViewDef(viewname string, tx *tx.Transaction) (string, error) {
-	return viewMgr.GetViewDef(viewname, tx)
+	return mm.cache.viewDef(viewname, tx)
 }
 
 func (mm *MetadataMgr) CreateIndex(idxname, tblname, fldname string, tx *tx.Transaction) error {
+	mm.cache.changed(catalogKey{indexEntry, tblname}, tx)
 	return idxMgr.CreateIndex(idxname, tblname, fldname, tx)
 }
 
 func (mm *MetadataMgr) GetIndexInfo(tblname string, tx *tx.Transaction) (map[string]*IndexInfo, error) {
-	return idxMgr.GetIndexInfo(tblname, tx)
+	defs, err := mm.cache.indexDefs(tblname, tx)
+	if err != nil || len(defs) == 0 {
+		return make(map[string]*IndexInfo), err
+	}
+	tblLayout, err := mm.GetLayout(tblname, tx)
+	if err != nil {
+		return nil, err
+	}
+	return idxMgr.indexInfo(tblname, tblLayout, defs, tx)
 }
 
 func (mm *MetadataMgr) GetStatInfo(tblname string, tblLayout *record.Layout, tx *tx.Transaction) (*StatInfo, error) {
 	return statMgr.GetStatInfo(tblname, tblLayout, tx)
 }
+
+// Analyze gathers column statistics for the specified table, or for every
+// table in the database if tblname is empty.
+// It returns the number of tables analyzed.
+func (mm *MetadataMgr) Analyze(tblname string, tx *tx.Transaction) (int, error) {
+	tblnames := []string{tblname}
+	if tblname == "" {
+		var err error
+		tblnames, err = tblMgr.TableNames(tx)
+		if err != nil {
+			return 0, err
+		}
+	}
+	for _, name := range tblnames {
+		layout, err := mm.GetLayout(name, tx)
+		if err != nil {
+			return 0, err
+		}
+		if err := statMgr.Analyze(name, layout, tx); err != nil {
+			return 0, err
+		}
+	}
+	return len(tblnames), nil
+}
+
+// TablesChanged keeps the table statistics up to date with the changes
+// made by a transaction.
+func (mm *MetadataMgr) TablesChanged(deltas map[string]tx.TableDelta, committed bool) {
+	statMgr.TablesChanged(deltas, committed)
+}
+
+// EnableAutoAnalyze turns on automatic analysis of tables whose records
+// have changed substantially, using transactions created by newTx.
+func (mm *MetadataMgr) EnableAutoAnalyze(newTx func() (*tx.Transaction, error)) {
+	statMgr.EnableAutoAnalyze(newTx)
+}
+
+// StopAutoAnalyze turns off automatic analysis, waiting for an analysis in
+// progress to finish.
+func (mm *MetadataMgr) StopAutoAnalyze() {
+	statMgr.StopAutoAnalyze()
+}
+
+// WaitAutoAnalyze waits until the tables waiting to be analyzed
+// automatically have been analyzed.
+func (mm *MetadataMgr) WaitAutoAnalyze() {
+	statMgr.WaitAutoAnalyze()
+}
+
+// AutoAnalyzeErr returns the error of the most recent automatic analysis
+// that failed, or nil if none has.
+func (mm *MetadataMgr) AutoAnalyzeErr() error {
+	return statMgr.AutoAnalyzeErr()
+}
+
+// RegisterSystemTable makes the specified system table available to
+// queries under the specified name.
+// System tables must be registered before the metadata manager is used
+// by concurrent transactions.
+func (mm *MetadataMgr) RegisterSystemTable(name string, t SystemTable) {
+	mm.systemTables[name] = t
+}
+
+// GetSystemTable returns the system table with the specified name,
+// or false if there is none.
+func (mm *MetadataMgr) GetSystemTable(name string) (SystemTable, bool) {
+	t, ok := mm.systemTables[name]
+	return t, ok
+}
@@ -1,11 +1,21 @@
 package metadata
 
 import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
 	"simpledb/internal/record"
 	"simpledb/internal/tx"
+	"slices"
+	"strconv"
 	"sync"
 )
 
+// MaxStatValLen is the maximum length of a value stored in the statistics
+// catalog. Longer string values are truncated, and values are compared
+// with the statistics by their prefix of this length.
+const MaxStatValLen = 32
+
 // StatInfo contains statistics about a table:
 // - the number of blocks used by the table
 // - the number of records in the table
@@ -15,6 +25,9 @@ type StatInfo struct {
 	BlocksAccessed int
 	// The estimated number of records in the table.
 	RecordsOutput int
+	// The statistics gathered by ANALYZE for each field,
+	// or nil if the table has not been analyzed.
+	Columns map[string]*ColumnStats
 }
 
 // NewStatInfo creates a new StatInfo.
@@ -26,100 +39,676 @@ func NewStatInfo(numBlocks int, numRecords int) StatInfo {
 }
 
 // DistinctValues returns an estimated number of distinct values for a field.
-// This is a complete guess because doing something reasonable is beyond
-// the scope of the system.
+// If the table has been analyzed, the estimate comes from its column
+// statistics. Otherwise it is a complete guess.
 func (si StatInfo) DistinctValues(fldname string) int {
+	if cs, ok := si.Columns[fldname]; ok {
+		return max(1, min(cs.Distinct, si.RecordsOutput))
+	}
 	return int(1 + si.RecordsOutput/3)
 }
 
+// RecordsWithValue estimates how many records in the table have the
+// specified value for a field, using the field's histogram.
+// It returns false if the field has not been analyzed.
+func (si StatInfo) RecordsWithValue(fldname string, val record.Constant) (int, bool) {
+	cs, ok := si.Columns[fldname]
+	if !ok || cs.NumRecs == 0 {
+		return 0, false
+	}
+	n := cs.RecordsWithValue(val)
+	if n == 0 {
+		return 0, true
+	}
+	// The table may have changed size since it was analyzed.
+	return max(1, n*si.RecordsOutput/cs.NumRecs), true
+}
+
+const (
+	// AutoAnalyzeThreshold and AutoAnalyzeScaleFactor determine when a
+	// table is analyzed automatically: once the number of records inserted,
+	// deleted or updated since it was last analyzed exceeds
+	// AutoAnalyzeThreshold + AutoAnalyzeScaleFactor * (number of records).
+	AutoAnalyzeThreshold   = 50
+	AutoAnalyzeScaleFactor = 0.1
+	// AnalyzeSampleBlocks is the number of blocks read when computing the
+	// statistics of a large table. Tables with more blocks than this are
+	// sampled rather than scanned in full.
+	AnalyzeSampleBlocks = 64
+)
+
 // StatMgr manages statistics about each table.
-// The manager doesn't store this information in the database;
-// instead it calculates this information on startup and
-// periodically refreshes it.
+// The number of blocks and records in a table are calculated the first
+// time the table's statistics are requested, and are then kept up to date
+// by applying the changes made by each committed transaction. Column
+// statistics are computed by ANALYZE and stored in the colstatcat and
+// histcat catalog tables. A table is analyzed automatically once enough of
+// its records have changed, by a background goroutine, so that the
+// transaction that made the changes doesn't wait for the analysis.
 type StatMgr struct {
 	tm         *TableMgr
+	colLayout  *record.Layout
+	histLayout *record.Layout
 	tableStats map[string]*StatInfo
-	numCalls   int
-	mu         sync.Mutex
+	// The statistics computed by the ANALYZE statements of each
+	// transaction, by transaction number, which only that transaction sees
+	// until it commits.
+	pending map[int]map[string]*StatInfo
+	// The number of records inserted, deleted or updated in each table
+	// since it was last analyzed.
+	modCounts map[string]int
+	// Creates the transactions used for automatic analysis,
+	// or nil if automatic analysis is disabled.
+	newTx func() (*tx.Transaction, error)
+	// The tables waiting to be analyzed automatically, in order.
+	stale []string
+	// Whether a table is being analyzed automatically.
+	analyzing bool
+	// The error of the most recent automatic analysis that failed.
+	autoAnalyzeErr error
+	// Wakes up the automatic analysis goroutine when a table becomes
+	// stale, and stops it.
+	work chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+	// Signalled when the automatic analysis goroutine runs out of work.
+	idle *sync.Cond
+	mu   sync.Mutex
 }
 
+var _ tx.TableChangeListener = (*StatMgr)(nil)
+
 // NewStatMgr creates a new StatMgr.
-// Initial statistics are computed by traversing the entire database.
-func NewStatMgr(tm *TableMgr, tx *tx.Transaction) (*StatMgr, error) {
-	tablestats := make(map[string]*StatInfo)
-	sm := &StatMgr{tm, tablestats, 0, sync.Mutex{}}
-	if err := sm.refreshStatistics(tx); err != nil {
+// The statistics catalog tables are created if the database doesn't have
+// them, which is the case for a new database and for one created before
+// ANALYZE was supported.
+func NewStatMgr(isNew bool, tm *TableMgr, tx *tx.Transaction) (*StatMgr, error) {
+	sm := &StatMgr{
+		tm:         tm,
+		tableStats: make(map[string]*StatInfo),
+		pending:    make(map[int]map[string]*StatInfo),
+		modCounts:  make(map[string]int),
+	}
+	sm.idle = sync.NewCond(&sm.mu)
+	var tblnames []string
+	if !isNew {
+		var err error
+		if tblnames, err = tm.TableNames(tx); err != nil {
+			return nil, err
+		}
+	}
+	if !slices.Contains(tblnames, "colstatcat") {
+		colsch := record.NewSchema()
+		colsch.AddStringField("tblname", MaxNameLen)
+		colsch.AddStringField("fldname", MaxNameLen)
+		colsch.AddIntField("numrecs")
+		colsch.AddIntField("distinct")
+		colsch.AddStringField("minval", MaxStatValLen)
+		colsch.AddStringField("maxval", MaxStatValLen)
+		if err := tm.CreateTable("colstatcat", colsch, tx); err != nil {
+			return nil, err
+		}
+	}
+	if !slices.Contains(tblnames, "histcat") {
+		histsch := record.NewSchema()
+		histsch.AddStringField("tblname", MaxNameLen)
+		histsch.AddStringField("fldname", MaxNameLen)
+		histsch.AddIntField("bucket")
+		histsch.AddStringField("hival", MaxStatValLen)
+		histsch.AddIntField("numrecs")
+		histsch.AddIntField("distinct")
+		if err := tm.CreateTable("histcat", histsch, tx); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if sm.colLayout, err = tm.GetLayout("colstatcat", tx); err != nil {
+		return nil, err
+	}
+	if sm.histLayout, err = tm.GetLayout("histcat", tx); err != nil {
 		return nil, err
 	}
 	return sm, nil
 }
 
+// EnableAutoAnalyze turns on automatic analysis of tables whose records
+// have changed substantially. The tables are analyzed in the background,
+// each in its own transaction, created by newTx, after the transaction that
+// crossed the threshold has committed.
+func (sm *StatMgr) EnableAutoAnalyze(newTx func() (*tx.Transaction, error)) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.newTx != nil {
+		sm.newTx = newTx
+		return
+	}
+	sm.newTx = newTx
+	sm.work = make(chan struct{}, 1)
+	sm.stop = make(chan struct{})
+	sm.wg.Add(1)
+	go sm.runAutoAnalyze(sm.work, sm.stop)
+}
+
+// StopAutoAnalyze turns off automatic analysis, waiting for an analysis in
+// progress to finish. The tables waiting to be analyzed are not.
+func (sm *StatMgr) StopAutoAnalyze() {
+	sm.mu.Lock()
+	if sm.newTx == nil {
+		sm.mu.Unlock()
+		return
+	}
+	sm.newTx = nil
+	sm.stale = nil
+	close(sm.stop)
+	sm.idle.Broadcast()
+	sm.mu.Unlock()
+	sm.wg.Wait()
+}
+
+// WaitAutoAnalyze waits until the tables waiting to be analyzed
+// automatically have been analyzed.
+func (sm *StatMgr) WaitAutoAnalyze() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for len(sm.stale) > 0 || sm.analyzing {
+		sm.idle.Wait()
+	}
+}
+
+// AutoAnalyzeErr returns the error of the most recent automatic analysis
+// that failed, or nil if none has.
+func (sm *StatMgr) AutoAnalyzeErr() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.autoAnalyzeErr
+}
+
 // GetStatInfo gets the statistics for a specified table.
+// The first time they are requested, the table is read without holding
+// the StatMgr's mutex, since reading it waits for locks, and the
+// transactions holding them need the mutex to plan and commit.
 func (sm *StatMgr) GetStatInfo(tblname string, layout *record.Layout, tx *tx.Transaction) (*StatInfo, error) {
+	sm.mu.Lock()
+	if si, ok := sm.pending[tx.TxNum()][tblname]; ok {
+		sm.mu.Unlock()
+		return si, nil
+	}
+	si, ok := sm.tableStats[tblname]
+	sm.mu.Unlock()
+	if ok {
+		return si, nil
+	}
+
+	si, err := sm.calcTableStats(tblname, layout, tx)
+	if err != nil {
+		return nil, err
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	// Another transaction may have calculated them meanwhile.
+	if cached, ok := sm.tableStats[tblname]; ok {
+		return cached, nil
+	}
+	sm.tableStats[tblname] = si
+	return si, nil
+}
+
+// TablesChanged applies the changes made by a transaction to the
+// statistics of each table, and queues any table whose number of changed
+// records has crossed the auto-analyze threshold to be analyzed in the
+// background.
+func (sm *StatMgr) TablesChanged(deltas map[string]tx.TableDelta, committed bool) {
+	sm.mu.Lock()
+	stale := false
+	for tblname, d := range deltas {
+		if !committed {
+			// The records were restored, but the appended blocks remain.
+			d.Rows, d.Modifications = 0, 0
+		}
+		if si, ok := sm.tableStats[tblname]; ok {
+			// Plans may be holding the old StatInfo, so replace it.
+			updated := *si
+			updated.RecordsOutput = max(0, si.RecordsOutput+d.Rows)
+			updated.BlocksAccessed = si.BlocksAccessed + d.Blocks
+			sm.tableStats[tblname] = &updated
+		}
+		if d.Modifications == 0 {
+			continue
+		}
+		sm.modCounts[tblname] += d.Modifications
+		threshold := AutoAnalyzeThreshold
+		if si, ok := sm.tableStats[tblname]; ok {
+			threshold += int(AutoAnalyzeScaleFactor * float64(si.RecordsOutput))
+		}
+		if sm.newTx != nil && !isCatalogTable(tblname) && sm.modCounts[tblname] > threshold {
+			sm.modCounts[tblname] = 0
+			if !slices.Contains(sm.stale, tblname) {
+				sm.stale = append(sm.stale, tblname)
+			}
+			stale = true
+		}
+	}
+	if stale {
+		select {
+		case sm.work <- struct{}{}:
+		default:
+			// The goroutine has already been woken up.
+		}
+	}
+	sm.mu.Unlock()
+}
+
+// runAutoAnalyze analyzes the stale tables whenever it is woken up, until
+// it is stopped.
+func (sm *StatMgr) runAutoAnalyze(work, stop chan struct{}) {
+	defer sm.wg.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-work:
+		}
+		sm.mu.Lock()
+		for len(sm.stale) > 0 {
+			tblname := sm.stale[0]
+			sm.stale = sm.stale[1:]
+			sm.analyzing = true
+			sm.mu.Unlock()
+			err := sm.autoAnalyze(tblname)
+			sm.mu.Lock()
+			sm.analyzing = false
+			if err != nil {
+				sm.autoAnalyzeErr = fmt.Errorf("automatic analysis of table %s failed: %w", tblname, err)
+			}
+		}
+		sm.idle.Broadcast()
+		sm.mu.Unlock()
+	}
+}
+
+// autoAnalyze analyzes the specified table in a new transaction.
+func (sm *StatMgr) autoAnalyze(tblname string) error {
+	tx, err := sm.newTx()
+	if err != nil {
+		return err
+	}
+	layout, err := sm.tm.GetLayout(tblname, tx)
+	if err == nil {
+		err = sm.Analyze(tblname, layout, tx)
+	}
+	if err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			return errors.Join(err, rerr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// Analyze computes the column statistics of the specified table and stores
+// them in the statistics catalog, replacing any previous statistics.
+// Large tables are sampled, and their statistics are extrapolated from
+// the sample. Other transactions see the new statistics once tx commits.
+// Like GetStatInfo, it reads the table and writes the catalog without
+// holding the StatMgr's mutex, which it only takes to record the results.
+func (sm *StatMgr) Analyze(tblname string, layout *record.Layout, tx *tx.Transaction) error {
+	sch := layout.Schema
+	collectors := make(map[string]*columnCollector)
+	for _, fldname := range sch.Fields {
+		collectors[fldname] = newColumnCollector()
+	}
+	si, err := sampleTable(tblname, layout, tx, func(ts *record.TableScan) error {
+		for _, fldname := range sch.Fields {
+			val, err := ts.GetVal(fldname)
+			if err != nil {
+				return err
+			}
+			collectors[fldname].add(val)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := sm.deleteColumnStats(tblname, tx); err != nil {
+		return err
+	}
+	si.Columns = make(map[string]*ColumnStats)
+	for _, fldname := range sch.Fields {
+		cs := collectors[fldname].stats()
+		if cs.NumRecs == 0 {
+			// There are no values to describe.
+			continue
+		}
+		cs.extrapolate(si.RecordsOutput)
+		cs.truncateValues()
+		if err := sm.writeColumnStats(tblname, fldname, cs, tx); err != nil {
+			return err
+		}
+		si.Columns[fldname] = cs
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	txnum := tx.TxNum()
+	if _, ok := sm.pending[txnum]; !ok {
+		sm.pending[txnum] = make(map[string]*StatInfo)
+		tx.OnEnd(func(committed bool) {
+			sm.publish(txnum, committed)
+		})
+	}
+	sm.pending[txnum][tblname] = si
+	return nil
+}
+
+// publish makes the statistics computed by a transaction's ANALYZE
+// statements visible to other transactions, if it committed.
+func (sm *StatMgr) publish(txnum int, committed bool) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	if committed {
+		for tblname, si := range sm.pending[txnum] {
+			sm.tableStats[tblname] = si
+			sm.modCounts[tblname] = 0
+		}
+	}
+	delete(sm.pending, txnum)
+}
+
+// calcTableStats calculates the statistics for a specified table,
+// and loads its column statistics from the catalog.
+func (sm *StatMgr) calcTableStats(tblname string, layout *record.Layout, tx *tx.Transaction) (*StatInfo, error) {
+	si, err := sampleTable(tblname, layout, tx, nil)
+	if err != nil {
+		return nil, err
+	}
+	si.Columns, err = sm.loadColumnStats(tblname, layout.Schema, tx)
+	if err != nil {
+		return nil, err
+	}
+	return si, nil
+}
 
-	sm.numCalls++
-	if sm.numCalls > 100 {
-		if err := sm.refreshStatistics(tx); err != nil {
+// sampleTable counts the blocks and records of a table, calling visit
+// (if it is not nil) on each record read. A table with more than
+// AnalyzeSampleBlocks blocks is sampled: only that many randomly chosen
+// blocks are read, and the number of records is estimated from them.
+func sampleTable(tblname string, layout *record.Layout, tx *tx.Transaction, visit func(*record.TableScan) error) (*StatInfo, error) {
+	numBlocks, err := tx.Size(tblname + ".tbl")
+	if err != nil {
+		return nil, err
+	}
+	ts, err := record.NewTableScan(tx, tblname, layout)
+	if err != nil {
+		return nil, err
+	}
+	defer ts.Close()
+	numRecords := 0
+	readRecord := func() error {
+		numRecords++
+		if visit != nil {
+			return visit(ts)
+		}
+		return nil
+	}
+
+	if numBlocks <= AnalyzeSampleBlocks {
+		for ts.Next() {
+			if err := readRecord(); err != nil {
+				return nil, err
+			}
+		}
+		return &StatInfo{BlocksAccessed: numBlocks, RecordsOutput: numRecords}, nil
+	}
+
+	// Read the chosen blocks in file order.
+	blknums := rand.Perm(numBlocks)[:AnalyzeSampleBlocks]
+	slices.Sort(blknums)
+	for _, blknum := range blknums {
+		if err := ts.MoveToRid(record.NewRID(blknum, -1)); err != nil {
 			return nil, err
 		}
+		for ts.Next() && ts.GetRid().Blknum == blknum {
+			if err := readRecord(); err != nil {
+				return nil, err
+			}
+		}
 	}
-	si, ok := sm.tableStats[tblname]
-	if !ok {
-		var err error
-		si, err = sm.calcTableStats(tblname, layout, tx)
+	numRecords = numRecords * numBlocks / AnalyzeSampleBlocks
+	return &StatInfo{BlocksAccessed: numBlocks, RecordsOutput: numRecords}, nil
+}
+
+// isCatalogTable returns true if the specified table is one of the
+// catalog tables maintained by the metadata managers.
+func isCatalogTable(tblname string) bool {
+	switch tblname {
+	case "tblcat", "fldcat", "viewcat", "idxcat", "colstatcat", "histcat":
+		return true
+	}
+	return false
+}
+
+// loadColumnStats reads the column statistics of the specified table from
+// the catalog. It returns nil if the table has not been analyzed.
+func (sm *StatMgr) loadColumnStats(tblname string, sch *record.Schema, tx *tx.Transaction) (map[string]*ColumnStats, error) {
+	var cols map[string]*ColumnStats
+	cscan, err := record.NewTableScan(tx, "colstatcat", sm.colLayout)
+	if err != nil {
+		return nil, err
+	}
+	defer cscan.Close()
+	for cscan.Next() {
+		fldname, ok, err := statRowField(cscan, tblname, sch)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		typ := sch.Type(fldname)
+		cs := &ColumnStats{}
+		numrecs, err := cscan.GetInt("numrecs")
 		if err != nil {
 			return nil, err
 		}
-		sm.tableStats[tblname] = si
+		distinct, err := cscan.GetInt("distinct")
+		if err != nil {
+			return nil, err
+		}
+		cs.NumRecs, cs.Distinct = int(numrecs), int(distinct)
+		if cs.Min, err = getStatVal(cscan, "minval", typ); err != nil {
+			return nil, err
+		}
+		if cs.Max, err = getStatVal(cscan, "maxval", typ); err != nil {
+			return nil, err
+		}
+		if cols == nil {
+			cols = make(map[string]*ColumnStats)
+		}
+		cols[fldname] = cs
 	}
-	return si, nil
+	if cols == nil {
+		return nil, nil
+	}
+
+	hscan, err := record.NewTableScan(tx, "histcat", sm.histLayout)
+	if err != nil {
+		return nil, err
+	}
+	defer hscan.Close()
+	bucketNums := make(map[string][]int)
+	for hscan.Next() {
+		fldname, ok, err := statRowField(hscan, tblname, sch)
+		if err != nil {
+			return nil, err
+		}
+		cs, analyzed := cols[fldname]
+		if !ok || !analyzed {
+			continue
+		}
+		bucket, err := hscan.GetInt("bucket")
+		if err != nil {
+			return nil, err
+		}
+		hi, err := getStatVal(hscan, "hival", sch.Type(fldname))
+		if err != nil {
+			return nil, err
+		}
+		numrecs, err := hscan.GetInt("numrecs")
+		if err != nil {
+			return nil, err
+		}
+		distinct, err := hscan.GetInt("distinct")
+		if err != nil {
+			return nil, err
+		}
+		cs.Histogram = append(cs.Histogram, Bucket{hi, int(numrecs), int(distinct)})
+		bucketNums[fldname] = append(bucketNums[fldname], int(bucket))
+	}
+	// The buckets of a histogram can come back in any order.
+	for fldname, cs := range cols {
+		nums := bucketNums[fldname]
+		order := make([]int, len(nums))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortFunc(order, func(a, b int) int { return nums[a] - nums[b] })
+		sorted := make([]Bucket, len(order))
+		for i, j := range order {
+			sorted[i] = cs.Histogram[j]
+		}
+		cs.Histogram = sorted
+	}
+	return cols, nil
 }
 
-// refreshStatistics refreshes the statistics for all tables.
-func (sm *StatMgr) refreshStatistics(tx *tx.Transaction) error {
-	sm.tableStats = make(map[string]*StatInfo)
-	sm.numCalls = 0
-	tcatlayout, err := sm.tm.GetLayout("tblcat", tx)
+// writeColumnStats inserts the statistics for a field into the catalog.
+func (sm *StatMgr) writeColumnStats(tblname, fldname string, cs *ColumnStats, tx *tx.Transaction) error {
+	cscan, err := record.NewTableScan(tx, "colstatcat", sm.colLayout)
 	if err != nil {
 		return err
 	}
-	tcat, err := record.NewTableScan(tx, "tblcat", tcatlayout)
+	defer cscan.Close()
+	if err := cscan.Insert(); err != nil {
+		return err
+	}
+	if err := cscan.SetString("tblname", tblname); err != nil {
+		return err
+	}
+	if err := cscan.SetString("fldname", fldname); err != nil {
+		return err
+	}
+	if err := cscan.SetInt("numrecs", int32(cs.NumRecs)); err != nil {
+		return err
+	}
+	if err := cscan.SetInt("distinct", int32(cs.Distinct)); err != nil {
+		return err
+	}
+	if err := cscan.SetString("minval", encodeStatVal(cs.Min)); err != nil {
+		return err
+	}
+	if err := cscan.SetString("maxval", encodeStatVal(cs.Max)); err != nil {
+		return err
+	}
+
+	hscan, err := record.NewTableScan(tx, "histcat", sm.histLayout)
 	if err != nil {
 		return err
 	}
-	defer tcat.Close()
-	for tcat.Next() {
-		tblname, err := tcat.GetString("tblname")
-		if err != nil {
+	defer hscan.Close()
+	for i, b := range cs.Histogram {
+		if err := hscan.Insert(); err != nil {
 			return err
 		}
-		layout, err := sm.tm.GetLayout(tblname, tx)
-		if err != nil {
+		if err := hscan.SetString("tblname", tblname); err != nil {
 			return err
 		}
-		si, err := sm.calcTableStats(tblname, layout, tx)
+		if err := hscan.SetString("fldname", fldname); err != nil {
+			return err
+		}
+		if err := hscan.SetInt("bucket", int32(i)); err != nil {
+			return err
+		}
+		if err := hscan.SetString("hival", encodeStatVal(b.Hi)); err != nil {
+			return err
+		}
+		if err := hscan.SetInt("numrecs", int32(b.NumRecs)); err != nil {
+			return err
+		}
+		if err := hscan.SetInt("distinct", int32(b.Distinct)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteColumnStats removes all statistics for the specified table from
+// the catalog.
+func (sm *StatMgr) deleteColumnStats(tblname string, tx *tx.Transaction) error {
+	for _, cat := range []struct {
+		name   string
+		layout *record.Layout
+	}{{"colstatcat", sm.colLayout}, {"histcat", sm.histLayout}} {
+		ts, err := record.NewTableScan(tx, cat.name, cat.layout)
 		if err != nil {
 			return err
 		}
-		sm.tableStats[tblname] = si
+		for ts.Next() {
+			v, err := ts.GetString("tblname")
+			if err != nil {
+				ts.Close()
+				return err
+			}
+			if v == tblname {
+				if err := ts.Delete(); err != nil {
+					ts.Close()
+					return err
+				}
+			}
+		}
+		ts.Close()
 	}
 	return nil
 }
 
-// calcTableStats calculates the statistics for a specified table.
-func (sm *StatMgr) calcTableStats(tblname string, layout *record.Layout, tx *tx.Transaction) (*StatInfo, error) {
-	var numBlocks int = 0
-	var numRecords int = 0
-	ts, err := record.NewTableScan(tx, tblname, layout)
+// statRowField returns the field name of the current statistics catalog
+// record, if the record belongs to the specified table and the field is
+// still part of its schema.
+func statRowField(ts *record.TableScan, tblname string, sch *record.Schema) (string, bool, error) {
+	v, err := ts.GetString("tblname")
+	if err != nil || v != tblname {
+		return "", false, err
+	}
+	fldname, err := ts.GetString("fldname")
 	if err != nil {
-		return nil, err
+		return "", false, err
 	}
-	defer ts.Close()
-	for ts.Next() {
-		numRecords++
-		numBlocks = ts.GetRid().Blknum + 1
+	return fldname, sch.HasField(fldname), nil
+}
+
+// encodeStatVal encodes a value as a string for storage in the
+// statistics catalog.
+func encodeStatVal(val record.Constant) string {
+	if val.Type() == record.Integer {
+		return strconv.Itoa(int(val.AsInt()))
+	}
+	return truncateStatVal(val).AsString()
+}
+
+// getStatVal reads a value of the specified type from a string field of
+// the statistics catalog.
+func getStatVal(ts *record.TableScan, fldname string, typ record.Type) (record.Constant, error) {
+	s, err := ts.GetString(fldname)
+	if err != nil {
+		return record.Constant{}, err
+	}
+	if typ == record.Integer {
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return record.Constant{}, fmt.Errorf("invalid statistics value %q: %w", s, err)
+		}
+		return record.NewIntConstant(int32(n)), nil
 	}
-	return &StatInfo{numBlocks, numRecords}, nil
+	return record.NewStringConstant(s), nil
 }
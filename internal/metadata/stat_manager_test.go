@@ -0,0 +1,491 @@
+package metadata_test
+
+import (
+	"fmt"
+	"os"
+	"simpledb/internal/metadata"
+	"simpledb/internal/record"
+	"simpledb/internal/server"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatMgrAnalyze(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("statmgrtest")
+	})
+
+	db, err := server.NewSimpleDB("statmgrtest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+
+	// A is heavily skewed: half of the records have A = 0, and the rest
+	// have distinct values. B has 20 evenly distributed values.
+	if _, err := db.Planner.ExecuteUpdate("create table T(A int, B varchar(9))", tx); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	insert, err := db.Planner.Prepare("insert into T(A, B) values(?, ?)")
+	if err != nil {
+		t.Fatalf("Failed to prepare insert: %v", err)
+	}
+	for i := 0; i < 500; i++ {
+		a := int32(0)
+		if i%2 == 1 {
+			a = int32(i)
+		}
+		b := fmt.Sprintf("b%d", i%20)
+		if _, err := insert.Execute(tx, record.NewIntConstant(a), record.NewStringConstant(b)); err != nil {
+			t.Fatalf("Failed to insert record: %v", err)
+		}
+	}
+
+	// Before ANALYZE, the number of distinct values is a guess.
+	p, err := db.Planner.CreateQueryPlan("select A from T where A = 0", tx)
+	if err != nil {
+		t.Fatalf("Failed to create plan: %v", err)
+	}
+	if got := p.RecordsOutput(); got > 10 {
+		t.Fatalf("Expected a small estimate before analyzing, got %d", got)
+	}
+
+	n, err := db.Planner.ExecuteUpdate("analyze T", tx)
+	if err != nil {
+		t.Fatalf("Failed to analyze table: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("Expected 1 table analyzed, got %d", n)
+	}
+
+	checkStats := func(si *metadata.StatInfo) {
+		t.Helper()
+		if si.RecordsOutput != 500 {
+			t.Fatalf("Expected 500 records, got %d", si.RecordsOutput)
+		}
+		if d := si.DistinctValues("A"); d < 240 || d > 262 {
+			t.Fatalf("Expected about 251 distinct values of A, got %d", d)
+		}
+		if d := si.DistinctValues("B"); d != 20 {
+			t.Fatalf("Expected 20 distinct values of B, got %d", d)
+		}
+		if n, ok := si.RecordsWithValue("A", record.NewIntConstant(0)); !ok || n < 200 || n > 300 {
+			t.Fatalf("Expected about 250 records with A = 0, got %d", n)
+		}
+		if n, ok := si.RecordsWithValue("A", record.NewIntConstant(99)); !ok || n > 5 {
+			t.Fatalf("Expected few records with A = 99, got %d", n)
+		}
+		if n, ok := si.RecordsWithValue("A", record.NewIntConstant(1000)); !ok || n != 0 {
+			t.Fatalf("Expected no records with A = 1000, got %d", n)
+		}
+		if n, ok := si.RecordsWithValue("B", record.NewStringConstant("b7")); !ok || n < 15 || n > 35 {
+			t.Fatalf("Expected about 25 records with B = 'b7', got %d", n)
+		}
+	}
+
+	layout, err := db.MetadataMgr.GetLayout("T", tx)
+	if err != nil {
+		t.Fatalf("Failed to get layout: %v", err)
+	}
+	si, err := db.MetadataMgr.GetStatInfo("T", layout, tx)
+	if err != nil {
+		t.Fatalf("Failed to get stat info: %v", err)
+	}
+	checkStats(si)
+
+	// The histogram lets the planner see the skew in A.
+	p, err = db.Planner.CreateQueryPlan("select A from T where A = 0", tx)
+	if err != nil {
+		t.Fatalf("Failed to create plan: %v", err)
+	}
+	if got := p.RecordsOutput(); got < 200 || got > 300 {
+		t.Fatalf("Expected about 250 records for A = 0, got %d", got)
+	}
+	p, err = db.Planner.CreateQueryPlan("select A from T where A = 1000", tx)
+	if err != nil {
+		t.Fatalf("Failed to create plan: %v", err)
+	}
+	if got := p.RecordsOutput(); got != 0 {
+		t.Fatalf("Expected 0 records for A = 1000, got %d", got)
+	}
+
+	// The statistics are stored in the catalog, so a new metadata manager
+	// sees them too.
+	mdm, err := metadata.NewMetadataMgr(false, tx)
+	if err != nil {
+		t.Fatalf("Failed to create metadata manager: %v", err)
+	}
+	si, err = mdm.GetStatInfo("T", layout, tx)
+	if err != nil {
+		t.Fatalf("Failed to get stat info: %v", err)
+	}
+	checkStats(si)
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
+
+func TestStatMgrIncremental(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("statmgrincrtest")
+	})
+
+	db, err := server.NewSimpleDB("statmgrincrtest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// run executes the statements in a new transaction, then commits or
+	// rolls it back.
+	run := func(commit bool, stmts ...string) {
+		t.Helper()
+		tx, err := db.NewTx()
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		for _, stmt := range stmts {
+			if _, err := db.Planner.ExecuteUpdate(stmt, tx); err != nil {
+				t.Fatalf("Failed to execute %q: %v", stmt, err)
+			}
+		}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Fatalf("Failed to end transaction: %v", err)
+		}
+	}
+	statInfo := func() *metadata.StatInfo {
+		t.Helper()
+		tx, err := db.NewTx()
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		defer tx.Commit()
+		layout, err := db.MetadataMgr.GetLayout("T", tx)
+		if err != nil {
+			t.Fatalf("Failed to get layout: %v", err)
+		}
+		si, err := db.MetadataMgr.GetStatInfo("T", layout, tx)
+		if err != nil {
+			t.Fatalf("Failed to get stat info: %v", err)
+		}
+		return si
+	}
+	inserts := func(from, to int) []string {
+		var stmts []string
+		for i := from; i < to; i++ {
+			stmts = append(stmts, fmt.Sprintf("insert into T(A) values(%d)", i))
+		}
+		return stmts
+	}
+
+	run(true, "create table T(A int)")
+	run(true, inserts(0, 10)...)
+	if si := statInfo(); si.RecordsOutput != 10 {
+		t.Fatalf("Expected 10 records, got %d", si.RecordsOutput)
+	}
+
+	// Committed changes are applied to the statistics.
+	run(true, inserts(10, 15)...)
+	run(true, "delete from T where A = 3")
+	si := statInfo()
+	if si.RecordsOutput != 14 {
+		t.Fatalf("Expected 14 records, got %d", si.RecordsOutput)
+	}
+	if si.Columns != nil {
+		t.Fatal("Expected the table not to be analyzed yet")
+	}
+
+	// Rolled back changes are not.
+	run(false, inserts(100, 110)...)
+	if si := statInfo(); si.RecordsOutput != 14 {
+		t.Fatalf("Expected 14 records after rollback, got %d", si.RecordsOutput)
+	}
+
+	// Crossing the modification threshold analyzes the table.
+	run(true, inserts(15, 15+metadata.AutoAnalyzeThreshold)...)
+	db.MetadataMgr.WaitAutoAnalyze()
+	if err := db.MetadataMgr.AutoAnalyzeErr(); err != nil {
+		t.Fatalf("Automatic analysis failed: %v", err)
+	}
+	si = statInfo()
+	if si.RecordsOutput != 64 {
+		t.Fatalf("Expected 64 records, got %d", si.RecordsOutput)
+	}
+	if si.Columns == nil {
+		t.Fatal("Expected the table to be analyzed automatically")
+	}
+	if d := si.DistinctValues("A"); d < 60 || d > 64 {
+		t.Fatalf("Expected about 64 distinct values, got %d", d)
+	}
+
+	// So does updating enough records.
+	run(true, "update T set A = 7")
+	db.MetadataMgr.WaitAutoAnalyze()
+	if err := db.MetadataMgr.AutoAnalyzeErr(); err != nil {
+		t.Fatalf("Automatic analysis failed: %v", err)
+	}
+	if d := statInfo().DistinctValues("A"); d != 1 {
+		t.Fatalf("Expected 1 distinct value after the update, got %d", d)
+	}
+}
+
+func TestStatMgrSampling(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("statmgrsampletest")
+	})
+
+	db, err := server.NewSimpleDB("statmgrsampletest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if _, err := db.Planner.ExecuteUpdate("create table T(A int, B int)", tx); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	insert, err := db.Planner.Prepare("insert into T(A, B) values(?, ?)")
+	if err != nil {
+		t.Fatalf("Failed to prepare insert: %v", err)
+	}
+	const numRecs = 3000
+	for i := 0; i < numRecs; i++ {
+		if _, err := insert.Execute(tx, record.NewIntConstant(int32(i)), record.NewIntConstant(int32(i%10))); err != nil {
+			t.Fatalf("Failed to insert record: %v", err)
+		}
+	}
+
+	layout, err := db.MetadataMgr.GetLayout("T", tx)
+	if err != nil {
+		t.Fatalf("Failed to get layout: %v", err)
+	}
+	if _, err := db.MetadataMgr.Analyze("T", tx); err != nil {
+		t.Fatalf("Failed to analyze table: %v", err)
+	}
+	si, err := db.MetadataMgr.GetStatInfo("T", layout, tx)
+	if err != nil {
+		t.Fatalf("Failed to get stat info: %v", err)
+	}
+	if si.BlocksAccessed <= metadata.AnalyzeSampleBlocks {
+		t.Fatalf("Expected more than %d blocks, got %d", metadata.AnalyzeSampleBlocks, si.BlocksAccessed)
+	}
+	if n := si.RecordsOutput; n < numRecs*8/10 || n > numRecs*12/10 {
+		t.Fatalf("Expected about %d records, got %d", numRecs, n)
+	}
+	if d := si.DistinctValues("A"); d < numRecs*7/10 {
+		t.Fatalf("Expected A to have about %d distinct values, got %d", numRecs, d)
+	}
+	if d := si.DistinctValues("B"); d != 10 {
+		t.Fatalf("Expected B to have 10 distinct values, got %d", d)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
+
+func TestStatMgrLongValues(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("statmgrlongtest")
+	})
+
+	db, err := server.NewSimpleDB("statmgrlongtest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if _, err := db.Planner.ExecuteUpdate("create table T(B varchar(60))", tx); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	// The values are longer than the statistics keep, and share a prefix
+	// of that length.
+	prefix := strings.Repeat("x", metadata.MaxStatValLen)
+	insert, err := db.Planner.Prepare("insert into T(B) values(?)")
+	if err != nil {
+		t.Fatalf("Failed to prepare insert: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		val := record.NewStringConstant(fmt.Sprintf("%s%03d", prefix, i))
+		if _, err := insert.Execute(tx, val); err != nil {
+			t.Fatalf("Failed to insert record: %v", err)
+		}
+	}
+	if _, err := db.MetadataMgr.Analyze("T", tx); err != nil {
+		t.Fatalf("Failed to analyze table: %v", err)
+	}
+	layout, err := db.MetadataMgr.GetLayout("T", tx)
+	if err != nil {
+		t.Fatalf("Failed to get layout: %v", err)
+	}
+
+	checkStats := func(si *metadata.StatInfo) {
+		t.Helper()
+		for _, s := range []string{prefix + "000", prefix + "050", prefix + "099"} {
+			if n, ok := si.RecordsWithValue("B", record.NewStringConstant(s)); !ok || n == 0 {
+				t.Fatalf("Expected records with B = %q, got %d", s, n)
+			}
+		}
+	}
+	si, err := db.MetadataMgr.GetStatInfo("T", layout, tx)
+	if err != nil {
+		t.Fatalf("Failed to get stat info: %v", err)
+	}
+	checkStats(si)
+	mdm, err := metadata.NewMetadataMgr(false, tx)
+	if err != nil {
+		t.Fatalf("Failed to create metadata manager: %v", err)
+	}
+	si, err = mdm.GetStatInfo("T", layout, tx)
+	if err != nil {
+		t.Fatalf("Failed to get stat info: %v", err)
+	}
+	checkStats(si)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
+
+func TestStatMgrAnalyzeRollback(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("statmgrrollbacktest")
+	})
+
+	db, err := server.NewSimpleDB("statmgrrollbacktest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if _, err := db.Planner.ExecuteUpdate("create table T(A int)", tx); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := db.Planner.ExecuteUpdate("insert into T(A) values(1)", tx); err != nil {
+		t.Fatalf("Failed to insert record: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	tx, err = db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if _, err := db.Planner.ExecuteUpdate("analyze T", tx); err != nil {
+		t.Fatalf("Failed to analyze table: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Failed to roll back transaction: %v", err)
+	}
+
+	// The statistics of the rolled back ANALYZE are discarded.
+	tx, err = db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	defer tx.Commit()
+	layout, err := db.MetadataMgr.GetLayout("T", tx)
+	if err != nil {
+		t.Fatalf("Failed to get layout: %v", err)
+	}
+	si, err := db.MetadataMgr.GetStatInfo("T", layout, tx)
+	if err != nil {
+		t.Fatalf("Failed to get stat info: %v", err)
+	}
+	if si.Columns != nil {
+		t.Fatal("Expected the table not to be analyzed")
+	}
+}
+
+func TestStatMgrAnalyzeWhileWriting(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("statmgrlocktest")
+	})
+
+	db, err := server.NewSimpleDB("statmgrlocktest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	setup, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if _, err := db.Planner.ExecuteUpdate("create table T(A int)", setup); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := db.Planner.ExecuteUpdate("insert into T(A) values(1)", setup); err != nil {
+		t.Fatalf("Failed to insert record: %v", err)
+	}
+	if err := setup.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	// The analyzer waits for the writer's lock on T, while the writer
+	// plans a query and commits.
+	analyzer, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	writer, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if _, err := db.Planner.ExecuteUpdate("insert into T(A) values(2)", writer); err != nil {
+		t.Fatalf("Failed to insert record: %v", err)
+	}
+	analyzed := make(chan error, 1)
+	go func() {
+		_, err := db.Planner.ExecuteUpdate("analyze T", analyzer)
+		analyzed <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	committed := make(chan error, 1)
+	go func() {
+		if _, err := db.Planner.CreateQueryPlan("select A from T", writer); err != nil {
+			committed <- err
+			return
+		}
+		committed <- writer.Commit()
+	}()
+	for _, step := range []struct {
+		name string
+		done chan error
+	}{{"writer", committed}, {"analysis", analyzed}} {
+		select {
+		case err := <-step.done:
+			if err != nil {
+				t.Fatalf("The %s failed: %v", step.name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the %s to finish without waiting for a lock timeout", step.name)
+		}
+	}
+	if err := analyzer.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
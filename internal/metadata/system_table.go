@@ -0,0 +1,166 @@
+package metadata
+
+import (
+	"math"
+	"simpledb/internal/buffer"
+	"simpledb/internal/log"
+	"simpledb/internal/record"
+)
+
+// SystemTable is a read-only table whose records are generated on demand
+// from the state of the running database, rather than read from a file.
+type SystemTable interface {
+	// Schema returns the schema of the table.
+	Schema() *record.Schema
+
+	// Rows returns the current records of the table.
+	Rows() []map[string]record.Constant
+}
+
+const (
+	// BufferFramesTable is the name of the system table that describes
+	// each buffer in the buffer pool.
+	BufferFramesTable = "sys_buffers"
+	// BufferStatsTable is the name of the system table that holds the
+	// buffer manager's counters.
+	BufferStatsTable = "sys_buffer_stats"
+	// LogStatsTable is the name of the system table that describes the
+	// size of the log.
+	LogStatsTable = "sys_log"
+)
+
+// maxSysFilenameLen is the declared length of file name fields in system
+// tables.
+const maxSysFilenameLen = 64
+
+// bufferFrames is a system table with one record per buffer, giving the
+// buffer's block, pin count, dirty flag and the transaction that last
+// modified it.
+type bufferFrames struct {
+	bm     *buffer.BufferMgr
+	schema *record.Schema
+}
+
+// NewBufferFramesTable creates a system table that describes each buffer
+// managed by the specified buffer manager.
+func NewBufferFramesTable(bm *buffer.BufferMgr) SystemTable {
+	sch := record.NewSchema()
+	sch.AddIntField("frame")
+	sch.AddStringField("filename", maxSysFilenameLen)
+	sch.AddIntField("blknum")
+	sch.AddIntField("pins")
+	sch.AddIntField("dirty")
+	sch.AddIntField("modified_by")
+	return &bufferFrames{bm, sch}
+}
+
+func (t *bufferFrames) Schema() *record.Schema {
+	return t.schema
+}
+
+func (t *bufferFrames) Rows() []map[string]record.Constant {
+	frames := t.bm.Frames()
+	rows := make([]map[string]record.Constant, len(frames))
+	for i, f := range frames {
+		blknum := f.Blk.Blknum
+		if f.Blk.Filename == "" {
+			blknum = -1
+		}
+		dirty := 0
+		if f.Dirty {
+			dirty = 1
+		}
+		rows[i] = map[string]record.Constant{
+			"frame":       record.NewIntConstant(int32(i)),
+			"filename":    record.NewStringConstant(f.Blk.Filename),
+			"blknum":      record.NewIntConstant(int32(blknum)),
+			"pins":        record.NewIntConstant(int32(f.Pins)),
+			"dirty":       record.NewIntConstant(int32(dirty)),
+			"modified_by": record.NewIntConstant(int32(f.ModifiedBy)),
+		}
+	}
+	return rows
+}
+
+// bufferStats is a system table with a single record holding the buffer
+// manager's counters.
+type bufferStats struct {
+	bm     *buffer.BufferMgr
+	schema *record.Schema
+}
+
+// NewBufferStatsTable creates a system table that holds the counters of
+// the specified buffer manager.
+func NewBufferStatsTable(bm *buffer.BufferMgr) SystemTable {
+	sch := record.NewSchema()
+	for _, fldname := range []string{"pins", "hits", "misses", "evictions", "flushes", "waits", "waitms", "aborts", "prefetches", "prefetchhits"} {
+		sch.AddIntField(fldname)
+	}
+	return &bufferStats{bm, sch}
+}
+
+func (t *bufferStats) Schema() *record.Schema {
+	return t.schema
+}
+
+func (t *bufferStats) Rows() []map[string]record.Constant {
+	s := t.bm.Stats()
+	val := func(n int64) record.Constant {
+		return record.NewIntConstant(int32(min(n, math.MaxInt32)))
+	}
+	return []map[string]record.Constant{{
+		"pins":         val(s.Pins),
+		"hits":         val(s.Hits),
+		"misses":       val(s.Misses),
+		"evictions":    val(s.Evictions),
+		"flushes":      val(s.DirtyFlushes),
+		"waits":        val(s.Waits),
+		"waitms":       val(s.PinWait.Milliseconds()),
+		"aborts":       val(s.Aborts),
+		"prefetches":   val(s.Prefetches),
+		"prefetchhits": val(s.PrefetchHits),
+	}}
+}
+
+// logStats is a system table with a single record describing the size of
+// the log.
+type logStats struct {
+	lm     *log.LogMgr
+	schema *record.Schema
+}
+
+// NewLogStatsTable creates a system table that describes the size of the
+// log managed by the specified log manager.
+func NewLogStatsTable(lm *log.LogMgr) SystemTable {
+	sch := record.NewSchema()
+	for _, fldname := range []string{"segments", "blocks", "bytes"} {
+		sch.AddIntField(fldname)
+	}
+	// LSNs are shown as segment/block/offset, since they don't fit in an
+	// integer field.
+	sch.AddStringField("oldestlsn", 24)
+	sch.AddStringField("latestlsn", 24)
+	return &logStats{lm, sch}
+}
+
+func (t *logStats) Schema() *record.Schema {
+	return t.schema
+}
+
+// Rows returns the log's statistics, or no records if they can't be read.
+func (t *logStats) Rows() []map[string]record.Constant {
+	s, err := t.lm.Stats()
+	if err != nil {
+		return nil
+	}
+	val := func(n int64) record.Constant {
+		return record.NewIntConstant(int32(min(n, math.MaxInt32)))
+	}
+	return []map[string]record.Constant{{
+		"segments":  val(int64(s.Segments)),
+		"blocks":    val(int64(s.Blocks)),
+		"bytes":     val(s.Bytes),
+		"oldestlsn": record.NewStringConstant(log.FormatLSN(s.OldestNeededLSN)),
+		"latestlsn": record.NewStringConstant(log.FormatLSN(s.LatestLSN)),
+	}}
+}
@@ -170,3 +170,22 @@ func (tm *TableMgr) GetLayout(tblname string, tx *tx.Transaction) (*record.Layou
 	}
 	return record.NewLayoutFromMetadata(sch, offsets, int(slotsize)), nil
 }
+
+// TableNames returns the names of all tables in the catalog,
+// including the catalog tables themselves.
+func (tm *TableMgr) TableNames(tx *tx.Transaction) ([]string, error) {
+	tcat, err := record.NewTableScan(tx, "tblcat", tm.tcatLayout)
+	if err != nil {
+		return nil, err
+	}
+	defer tcat.Close()
+	var tblnames []string
+	for tcat.Next() {
+		tblname, err := tcat.GetString("tblname")
+		if err != nil {
+			return nil, err
+		}
+		tblnames = append(tblnames, tblname)
+	}
+	return tblnames, nil
+}
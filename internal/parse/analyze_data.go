@@ -0,0 +1,29 @@
+package parse
+
+import (
+	"strings"
+)
+
+// AnalyzeData represents data for the SQL analyze statement.
+type AnalyzeData struct {
+	// TableName is the table to analyze, or "" to analyze all tables.
+	TableName string
+}
+
+// NewAnalyzeData creates a new AnalyzeData instance for the specified table.
+func NewAnalyzeData(tblname string) *AnalyzeData {
+	return &AnalyzeData{
+		TableName: tblname,
+	}
+}
+
+// String returns a string representation of the command
+func (ad *AnalyzeData) String() string {
+	var result strings.Builder
+	result.WriteString("ANALYZE")
+	if ad.TableName != "" {
+		result.WriteString(" ")
+		result.WriteString(ad.TableName)
+	}
+	return result.String()
+}
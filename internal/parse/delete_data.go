@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"simpledb/internal/query"
+	"simpledb/internal/record"
 )
 
 // DeleteData represents data for the SQL delete statement.
@@ -20,6 +21,16 @@ func NewDeleteData(tableName string, pred *query.Predicate) *DeleteData {
 	}
 }
 
+// Bind returns a copy of the command with its parameters replaced by the
+// specified constants.
+func (dd *DeleteData) Bind(args []record.Constant) (*DeleteData, error) {
+	pred, err := dd.Pred.Bind(args)
+	if err != nil {
+		return nil, err
+	}
+	return NewDeleteData(dd.TableName, pred), nil
+}
+
 // String returns a string representation of the command
 func (dd *DeleteData) String() string {
 	var result strings.Builder
@@ -0,0 +1,43 @@
+package parse
+
+import (
+	"strings"
+
+	"simpledb/internal/record"
+)
+
+// ExplainData represents data for the SQL explain statement.
+type ExplainData struct {
+	Query   *QueryData
+	Analyze bool
+}
+
+// NewExplainData creates a new ExplainData instance for the specified query.
+// If analyze is true, the query is run and its actual costs are reported.
+func NewExplainData(querydata *QueryData, analyze bool) *ExplainData {
+	return &ExplainData{
+		Query:   querydata,
+		Analyze: analyze,
+	}
+}
+
+// Bind returns a copy of the command with the explained query's parameters
+// replaced by the specified constants.
+func (ed *ExplainData) Bind(args []record.Constant) (*ExplainData, error) {
+	querydata, err := ed.Query.Bind(args)
+	if err != nil {
+		return nil, err
+	}
+	return NewExplainData(querydata, ed.Analyze), nil
+}
+
+// String returns a string representation of the command
+func (ed *ExplainData) String() string {
+	var result strings.Builder
+	result.WriteString("EXPLAIN ")
+	if ed.Analyze {
+		result.WriteString("ANALYZE ")
+	}
+	result.WriteString(ed.Query.String())
+	return result.String()
+}
@@ -3,6 +3,7 @@ package parse
 import (
 	"strings"
 
+	"simpledb/internal/query"
 	"simpledb/internal/record"
 )
 
@@ -10,11 +11,12 @@ import (
 type InsertData struct {
 	TableName string
 	Fields    []string
-	Values    []record.Constant
+	Values    []query.Expression
 }
 
 // NewInsertData creates a new InsertData instance with the specified table name, fields, and values.
-func NewInsertData(tblname string, fields []string, values []record.Constant) *InsertData {
+// Each value is either a constant or a bind parameter.
+func NewInsertData(tblname string, fields []string, values []query.Expression) *InsertData {
 	return &InsertData{
 		TableName: tblname,
 		Fields:    fields,
@@ -22,6 +24,20 @@ func NewInsertData(tblname string, fields []string, values []record.Constant) *I
 	}
 }
 
+// Bind returns a copy of the command with its parameters replaced by the
+// specified constants.
+func (id *InsertData) Bind(args []record.Constant) (*InsertData, error) {
+	values := make([]query.Expression, len(id.Values))
+	for i, value := range id.Values {
+		bound, err := value.Bind(args)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = bound
+	}
+	return NewInsertData(id.TableName, id.Fields, values), nil
+}
+
 // String returns a string representation of the command
 func (id *InsertData) String() string {
 	var result strings.Builder
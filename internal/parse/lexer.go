@@ -7,7 +7,7 @@ import (
 	"unicode"
 )
 
-var keywords = []string{"select", "from", "where", "and", "insert", "into", "values", "delete", "update", "set", "create", "table", "int", "varchar", "view", "as", "index", "on"}
+var keywords = []string{"select", "from", "where", "and", "insert", "into", "values", "delete", "update", "set", "create", "table", "int", "varchar", "view", "as", "index", "on", "explain", "analyze", "savepoint", "rollback", "to", "release"}
 
 type TokenType string
 
@@ -59,7 +59,10 @@ const (
 	Comma      TokenType = "COMMA"
 	OpenParen  TokenType = "OPEN_PAREN"
 	CloseParen TokenType = "CLOSE_PAREN"
-	LexerError TokenType = "LEXER_ERROR" // used for syntax errors
+	// Placeholder is a bind parameter, written either as "?" (numbered in
+	// order of appearance) or as "$n" (numbered explicitly).
+	Placeholder TokenType = "PLACEHOLDER"
+	LexerError  TokenType = "LEXER_ERROR" // used for syntax errors
 )
 
 func NewLexer(query string) *Lexer {
@@ -151,6 +154,18 @@ func (l *Lexer) NextToken() Token {
 		t = NewToken(OpenParen, "(")
 	} else if ch == ')' {
 		t = NewToken(CloseParen, ")")
+	} else if ch == '?' {
+		t = NewToken(Placeholder, "?")
+	} else if ch == '$' {
+		l.readChar() // consume the '$'
+		if ch := l.peek(); ch < '0' || ch > '9' {
+			return NewToken(LexerError, "expected parameter number after $")
+		}
+		i, err := l.readInt()
+		if err != nil {
+			return NewToken(LexerError, err.Error())
+		}
+		return NewToken(Placeholder, "$"+i)
 	} else if ch >= '0' && ch <= '9' {
 		i, err := l.readInt()
 		if err != nil {
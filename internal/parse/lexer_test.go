@@ -37,6 +37,18 @@ func TestLexer3(t *testing.T) {
 	checkToken(t, lexer, EOF, "")
 }
 
+func TestLexerPlaceholders(t *testing.T) {
+	lexer := NewLexer("a = ? and b = $12")
+	checkToken(t, lexer, Identifier, "a")
+	checkToken(t, lexer, Equal, "=")
+	checkToken(t, lexer, Placeholder, "?")
+	checkToken(t, lexer, Keyword, "and")
+	checkToken(t, lexer, Identifier, "b")
+	checkToken(t, lexer, Equal, "=")
+	checkToken(t, lexer, Placeholder, "$12")
+	checkToken(t, lexer, EOF, "")
+}
+
 func checkToken(t *testing.T, lexer *Lexer, typ TokenType, lit string) {
 	token := lexer.NextToken()
 	if token.Literal != lit {
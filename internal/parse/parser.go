@@ -12,6 +12,12 @@ type Parser struct {
 	lex     *Lexer
 	curTok  Token
 	prevTok Token
+	// numParams is the highest parameter number seen so far.
+	// Positional ("?") and numbered ("$n") parameters cannot be mixed
+	// within a statement.
+	numParams  int
+	positional bool
+	numbered   bool
 }
 
 func NewParser(lex *Lexer) *Parser {
@@ -45,6 +51,10 @@ func (p *Parser) matchDelim(delim TokenType) bool {
 	return p.curTok.Type == delim
 }
 
+func (p *Parser) matchParam() bool {
+	return p.curTok.Type == Placeholder
+}
+
 func (p *Parser) eatInt() (int32, error) {
 	if !p.matchInt() {
 		return 0, NewSyntaxError("expected integer")
@@ -89,6 +99,38 @@ func (p *Parser) eatDelim(delim TokenType) error {
 	return nil
 }
 
+// eatParam consumes a placeholder token and returns its parameter number.
+func (p *Parser) eatParam() (int, error) {
+	if !p.matchParam() {
+		return 0, NewSyntaxError("expected parameter")
+	}
+	p.nextToken()
+	lit := p.prevTok.Literal
+	if lit == "?" {
+		if p.numbered {
+			return 0, NewSyntaxError("cannot mix ? and $n parameters")
+		}
+		p.positional = true
+		p.numParams++
+		return p.numParams, nil
+	}
+	if p.positional {
+		return 0, NewSyntaxError("cannot mix ? and $n parameters")
+	}
+	n, err := strconv.Atoi(lit[1:])
+	if err != nil || n < 1 {
+		return 0, NewSyntaxError(fmt.Sprintf("invalid parameter: %s", lit))
+	}
+	p.numbered = true
+	p.numParams = max(p.numParams, n)
+	return n, nil
+}
+
+// NumParams returns the number of bind parameters in the parsed statement.
+func (p *Parser) NumParams() int {
+	return p.numParams
+}
+
 func (p *Parser) Field() (string, error) {
 	return p.eatId()
 }
@@ -118,6 +160,18 @@ func (p *Parser) Expression() (query.Expression, error) {
 		}
 		return query.NewFieldExpression(field), nil
 	}
+	return p.value()
+}
+
+// value parses a constant or a bind parameter.
+func (p *Parser) value() (query.Expression, error) {
+	if p.matchParam() {
+		n, err := p.eatParam()
+		if err != nil {
+			return query.Expression{}, err
+		}
+		return query.NewParamExpression(n), nil
+	}
 	constant, err := p.Constant()
 	if err != nil {
 		return query.Expression{}, err
@@ -183,6 +237,42 @@ func (p *Parser) Query() (*QueryData, error) {
 	return NewQueryData(fields, tables, pred), nil
 }
 
+// Statement parses a query, an explain statement, or an update command,
+// depending on the statement's first keyword.
+func (p *Parser) Statement() (interface{}, error) {
+	if p.matchKeyword("explain") {
+		data, err := p.Explain()
+		if err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	if p.matchKeyword("select") {
+		data, err := p.Query()
+		if err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	return p.UpdateCmd()
+}
+
+func (p *Parser) Explain() (*ExplainData, error) {
+	if err := p.eatKeyword("explain"); err != nil {
+		return nil, err
+	}
+	analyze := false
+	if p.matchKeyword("analyze") {
+		p.nextToken()
+		analyze = true
+	}
+	query, err := p.Query()
+	if err != nil {
+		return nil, err
+	}
+	return NewExplainData(query, analyze), nil
+}
+
 func (p *Parser) UpdateCmd() (interface{}, error) {
 	if p.matchKeyword("insert") {
 		return p.Insert()
@@ -192,8 +282,18 @@ func (p *Parser) UpdateCmd() (interface{}, error) {
 		return p.Delete()
 	} else if p.matchKeyword("create") {
 		return p.Create()
+	} else if p.matchKeyword("analyze") {
+		return p.Analyze()
+	} else if p.matchKeyword("set") {
+		return p.Set()
+	} else if p.matchKeyword("savepoint") {
+		return p.Savepoint()
+	} else if p.matchKeyword("rollback") {
+		return p.RollbackTo()
+	} else if p.matchKeyword("release") {
+		return p.Release()
 	}
-	return nil, NewSyntaxError("expected insert, update, delete, or create")
+	return nil, NewSyntaxError("expected insert, update, delete, create, analyze, set, savepoint, rollback, or release")
 }
 
 func (p *Parser) Create() (interface{}, error) {
@@ -232,6 +332,84 @@ func (p *Parser) Delete() (*DeleteData, error) {
 	return NewDeleteData(tblname, pred), nil
 }
 
+func (p *Parser) Analyze() (*AnalyzeData, error) {
+	if err := p.eatKeyword("analyze"); err != nil {
+		return nil, err
+	}
+	tblname := ""
+	if p.matchId() {
+		var err error
+		tblname, err = p.eatId()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewAnalyzeData(tblname), nil
+}
+
+func (p *Parser) Set() (*SetData, error) {
+	if err := p.eatKeyword("set"); err != nil {
+		return nil, err
+	}
+	name, err := p.eatId()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.eatDelim(Equal); err != nil {
+		return nil, err
+	}
+	val, err := p.Constant()
+	if err != nil {
+		return nil, err
+	}
+	return NewSetData(name, val), nil
+}
+
+func (p *Parser) Savepoint() (*SavepointData, error) {
+	if err := p.eatKeyword("savepoint"); err != nil {
+		return nil, err
+	}
+	name, err := p.eatId()
+	if err != nil {
+		return nil, err
+	}
+	return NewSavepointData(name), nil
+}
+
+func (p *Parser) RollbackTo() (*RollbackToData, error) {
+	if err := p.eatKeyword("rollback"); err != nil {
+		return nil, err
+	}
+	if err := p.eatKeyword("to"); err != nil {
+		return nil, err
+	}
+	name, err := p.savepointName()
+	if err != nil {
+		return nil, err
+	}
+	return NewRollbackToData(name), nil
+}
+
+func (p *Parser) Release() (*ReleaseData, error) {
+	if err := p.eatKeyword("release"); err != nil {
+		return nil, err
+	}
+	name, err := p.savepointName()
+	if err != nil {
+		return nil, err
+	}
+	return NewReleaseData(name), nil
+}
+
+// savepointName parses the name of a savepoint, which may be preceded by
+// the optional SAVEPOINT keyword.
+func (p *Parser) savepointName() (string, error) {
+	if p.matchKeyword("savepoint") {
+		p.nextToken()
+	}
+	return p.eatId()
+}
+
 func (p *Parser) Insert() (*InsertData, error) {
 	if err := p.eatKeyword("insert"); err != nil {
 		return nil, err
@@ -259,7 +437,7 @@ func (p *Parser) Insert() (*InsertData, error) {
 	if err := p.eatDelim(OpenParen); err != nil {
 		return nil, err
 	}
-	values, err := p.constList()
+	values, err := p.valueList()
 	if err != nil {
 		return nil, err
 	}
@@ -338,6 +516,9 @@ func (p *Parser) CreateView() (*CreateViewData, error) {
 	if err != nil {
 		return nil, err
 	}
+	if p.numParams > 0 {
+		return nil, NewSyntaxError("parameters are not allowed in view definitions")
+	}
 	return NewCreateViewData(viewname, query), nil
 }
 
@@ -417,14 +598,14 @@ func (p *Parser) fieldList() ([]string, error) {
 	return fields, nil
 }
 
-func (p *Parser) constList() ([]record.Constant, error) {
-	values := []record.Constant{}
+func (p *Parser) valueList() ([]query.Expression, error) {
+	values := []query.Expression{}
 	for {
-		constant, err := p.Constant()
+		value, err := p.value()
 		if err != nil {
 			return nil, err
 		}
-		values = append(values, constant)
+		values = append(values, value)
 		if !p.matchDelim(Comma) {
 			break
 		}
@@ -47,6 +47,13 @@ func TestParserUpdate(t *testing.T) {
 		"CREATE VIEW view2 AS SELECT col1, col2 FROM table1 WHERE col1 = 'value'",
 		"CREATE INDEX index1 ON table1 (col1)",
 		"CREATE INDEX index2 ON table1 (col2)",
+		"ANALYZE",
+		"ANALYZE table1",
+		"SET buffers = 64",
+		"SET mode = 'fast'",
+		"SAVEPOINT sp1",
+		"ROLLBACK TO SAVEPOINT sp1",
+		"RELEASE SAVEPOINT sp1",
 	}
 	for _, stmt := range stmts {
 		lexer := NewLexer(stmt)
@@ -64,3 +71,45 @@ func TestParserUpdate(t *testing.T) {
 		}
 	}
 }
+
+func TestParserParams(t *testing.T) {
+	cases := []struct {
+		stmt      string
+		expected  string
+		numParams int
+	}{
+		{"SELECT col1 FROM table1 WHERE col1 = ?", "SELECT col1 FROM table1 WHERE col1 = $1", 1},
+		{"SELECT col1 FROM table1 WHERE col1 = ? AND col2 = ?", "SELECT col1 FROM table1 WHERE col1 = $1 AND col2 = $2", 2},
+		{"SELECT col1 FROM table1 WHERE col1 = $2 AND col2 = $1", "SELECT col1 FROM table1 WHERE col1 = $2 AND col2 = $1", 2},
+		{"INSERT INTO table1 (col1, col2) VALUES (?, 'value2')", "INSERT INTO table1 (col1, col2) VALUES ($1, 'value2')", 1},
+		{"UPDATE table1 SET col1 = $1 WHERE col2 = $1", "UPDATE table1 SET col1 = $1 WHERE col2 = $1", 1},
+		{"DELETE FROM table1 WHERE col1 = ?", "DELETE FROM table1 WHERE col1 = $1", 1},
+		{"EXPLAIN SELECT col1 FROM table1 WHERE col1 = ?", "EXPLAIN SELECT col1 FROM table1 WHERE col1 = $1", 1},
+		{"EXPLAIN ANALYZE SELECT col1 FROM table1", "EXPLAIN ANALYZE SELECT col1 FROM table1", 0},
+	}
+	for _, c := range cases {
+		parser := NewParser(NewLexer(c.stmt))
+		cmd, err := parser.Statement()
+		if err != nil {
+			t.Fatalf("case %s: expected nil, got %v", c.stmt, err)
+		}
+		if got := cmd.(fmt.Stringer).String(); got != c.expected {
+			t.Fatalf("case %s: expected %s, got %s", c.stmt, c.expected, got)
+		}
+		if parser.NumParams() != c.numParams {
+			t.Fatalf("case %s: expected %d params, got %d", c.stmt, c.numParams, parser.NumParams())
+		}
+	}
+
+	invalid := []string{
+		"SELECT col1 FROM table1 WHERE col1 = ? AND col2 = $1",
+		"SELECT col1 FROM table1 WHERE col1 = $0",
+		"CREATE VIEW view1 AS SELECT col1 FROM table1 WHERE col1 = ?",
+	}
+	for _, stmt := range invalid {
+		parser := NewParser(NewLexer(stmt))
+		if _, err := parser.Statement(); err == nil {
+			t.Fatalf("case %s: expected syntax error, got nil", stmt)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"simpledb/internal/query"
+	"simpledb/internal/record"
 )
 
 // QueryData represents data for the SQL select statement.
@@ -22,6 +23,16 @@ func NewQueryData(fields []string, tables []string, pred *query.Predicate) *Quer
 	}
 }
 
+// Bind returns a copy of the query with its parameters replaced by the
+// specified constants.
+func (q *QueryData) Bind(args []record.Constant) (*QueryData, error) {
+	pred, err := q.Pred.Bind(args)
+	if err != nil {
+		return nil, err
+	}
+	return NewQueryData(q.Fields, q.Tables, pred), nil
+}
+
 // String returns a string representation of the query
 func (q *QueryData) String() string {
 	var result strings.Builder
@@ -0,0 +1,27 @@
+package parse
+
+import (
+	"strings"
+)
+
+// ReleaseData represents data for the SQL release savepoint statement,
+// which removes a savepoint while keeping the changes made since it was set.
+type ReleaseData struct {
+	Name string
+}
+
+// NewReleaseData creates a new ReleaseData instance with the specified
+// savepoint name.
+func NewReleaseData(name string) *ReleaseData {
+	return &ReleaseData{
+		Name: name,
+	}
+}
+
+// String returns a string representation of the command
+func (rd *ReleaseData) String() string {
+	var result strings.Builder
+	result.WriteString("RELEASE SAVEPOINT ")
+	result.WriteString(rd.Name)
+	return result.String()
+}
@@ -0,0 +1,27 @@
+package parse
+
+import (
+	"strings"
+)
+
+// RollbackToData represents data for the SQL rollback to savepoint
+// statement, which undoes the changes made since a savepoint was set.
+type RollbackToData struct {
+	Name string
+}
+
+// NewRollbackToData creates a new RollbackToData instance with the
+// specified savepoint name.
+func NewRollbackToData(name string) *RollbackToData {
+	return &RollbackToData{
+		Name: name,
+	}
+}
+
+// String returns a string representation of the command
+func (rd *RollbackToData) String() string {
+	var result strings.Builder
+	result.WriteString("ROLLBACK TO SAVEPOINT ")
+	result.WriteString(rd.Name)
+	return result.String()
+}
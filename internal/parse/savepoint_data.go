@@ -0,0 +1,27 @@
+package parse
+
+import (
+	"strings"
+)
+
+// SavepointData represents data for the SQL savepoint statement, which sets
+// a savepoint in the current transaction.
+type SavepointData struct {
+	Name string
+}
+
+// NewSavepointData creates a new SavepointData instance with the specified
+// savepoint name.
+func NewSavepointData(name string) *SavepointData {
+	return &SavepointData{
+		Name: name,
+	}
+}
+
+// String returns a string representation of the command
+func (sd *SavepointData) String() string {
+	var result strings.Builder
+	result.WriteString("SAVEPOINT ")
+	result.WriteString(sd.Name)
+	return result.String()
+}
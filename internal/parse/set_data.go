@@ -0,0 +1,32 @@
+package parse
+
+import (
+	"simpledb/internal/record"
+	"strings"
+)
+
+// SetData represents data for the SQL set statement, which changes a
+// setting of the database.
+type SetData struct {
+	Name string
+	Val  record.Constant
+}
+
+// NewSetData creates a new SetData instance with the specified setting name
+// and value.
+func NewSetData(name string, val record.Constant) *SetData {
+	return &SetData{
+		Name: name,
+		Val:  val,
+	}
+}
+
+// String returns a string representation of the command
+func (sd *SetData) String() string {
+	var result strings.Builder
+	result.WriteString("SET ")
+	result.WriteString(sd.Name)
+	result.WriteString(" = ")
+	result.WriteString(sd.Val.String())
+	return result.String()
+}
@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"simpledb/internal/query"
+	"simpledb/internal/record"
 )
 
 // UpdateData represents data for the SQL update statement.
@@ -25,6 +26,20 @@ func NewUpdateData(tblname string, fldname string, newval query.Expression, pred
 	}
 }
 
+// Bind returns a copy of the command with its parameters replaced by the
+// specified constants.
+func (ud *UpdateData) Bind(args []record.Constant) (*UpdateData, error) {
+	newval, err := ud.NewValue.Bind(args)
+	if err != nil {
+		return nil, err
+	}
+	pred, err := ud.Pred.Bind(args)
+	if err != nil {
+		return nil, err
+	}
+	return NewUpdateData(ud.TableName, ud.TargetField, newval, pred), nil
+}
+
 // String returns a string representation of the command
 func (ud *UpdateData) String() string {
 	var result strings.Builder
@@ -0,0 +1,88 @@
+package plan
+
+import (
+	"simpledb/internal/query"
+	"simpledb/internal/record"
+	"simpledb/internal/tx"
+	"time"
+)
+
+// AnalyzePlan wraps a plan so that the scans it opens record what they
+// actually do. It is used by EXPLAIN ANALYZE.
+// All methods other than Open delegate their work to the wrapped plan.
+type AnalyzePlan struct {
+	p  query.Plan
+	tx *tx.Transaction
+	// Stats holds the statistics collected by the scans opened by this plan.
+	Stats query.ScanStats
+}
+
+var _ query.Plan = (*AnalyzePlan)(nil)
+
+// NewAnalyzePlan instruments the specified plan and all of its subplans.
+// The subplans are rebuilt so that each of them is wrapped in its own
+// AnalyzePlan; plan types that aren't known to this package are
+// instrumented as a single node.
+func NewAnalyzePlan(p query.Plan, tx *tx.Transaction) *AnalyzePlan {
+	ap := &AnalyzePlan{tx: tx}
+	switch p := p.(type) {
+	case *SelectPlan:
+		ap.p = NewSelectPlan(NewAnalyzePlan(p.p, tx), p.pred)
+	case *ProjectPlan:
+		ap.p = &ProjectPlan{NewAnalyzePlan(p.p, tx), p.schema}
+	case *ProductPlan:
+		ap.p = &ProductPlan{p1: NewAnalyzePlan(p.p1, tx), p2: NewAnalyzePlan(p.p2, tx), schema: p.schema}
+	default:
+		ap.p = p
+	}
+	return ap
+}
+
+// Open opens a scan for the wrapped plan, and records the work done by the
+// scan in the plan's statistics.
+func (ap *AnalyzePlan) Open() (record.Scan, error) {
+	start, startBlocks := time.Now(), ap.tx.BlocksPinned()
+	s, err := ap.p.Open()
+	ap.Stats.Time += time.Since(start)
+	ap.Stats.Blocks += ap.tx.BlocksPinned() - startBlocks
+	if err != nil {
+		return nil, err
+	}
+	return query.NewInstrumentedScan(s, &ap.Stats, ap.tx.BlocksPinned), nil
+}
+
+// BlocksAccessed returns the wrapped plan's estimate.
+func (ap *AnalyzePlan) BlocksAccessed() int {
+	return ap.p.BlocksAccessed()
+}
+
+// RecordsOutput returns the wrapped plan's estimate.
+func (ap *AnalyzePlan) RecordsOutput() int {
+	return ap.p.RecordsOutput()
+}
+
+// DistinctValues returns the wrapped plan's estimate.
+func (ap *AnalyzePlan) DistinctValues(fldname string) int {
+	return ap.p.DistinctValues(fldname)
+}
+
+// RecordsWithValue returns the wrapped plan's estimate.
+func (ap *AnalyzePlan) RecordsWithValue(fldname string, val record.Constant) (int, bool) {
+	return recordsWithValue(ap.p, fldname, val)
+}
+
+// Schema returns the schema of the wrapped plan.
+func (ap *AnalyzePlan) Schema() *record.Schema {
+	return ap.p.Schema()
+}
+
+// Describe returns the description of the wrapped plan.
+func (ap *AnalyzePlan) Describe() string {
+	return ap.p.Describe()
+}
+
+// Children returns the subplans of the wrapped plan, which are themselves
+// instrumented.
+func (ap *AnalyzePlan) Children() []query.Plan {
+	return ap.p.Children()
+}
@@ -26,6 +26,10 @@ func (p *BasicQueryPlanner) CreatePlan(data *parse.QueryData, tx *tx.Transaction
 	// Step 1: create a plan for each mentioned table or view.
 	plans := make([]query.Plan, 0, len(data.Tables))
 	for _, tblname := range data.Tables {
+		if st, ok := p.mdm.GetSystemTable(tblname); ok {
+			plans = append(plans, NewSystemTablePlan(tblname, st))
+			continue
+		}
 		viewdef, err := p.mdm.GetViewDef(tblname, tx)
 		if err != nil {
 			return nil, err
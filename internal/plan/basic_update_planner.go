@@ -28,6 +28,10 @@ func (p *BasicUpdatePlanner) ExecuteDelete(data *parse.DeleteData, tx *tx.Transa
 		return 0, err
 	}
 	plan = NewSelectPlan(plan, data.Pred)
+	// Scan for the records under update locks, so that two statements
+	// modifying the same block can't deadlock upgrading their locks.
+	tx.SetReadForUpdate(true)
+	defer tx.SetReadForUpdate(false)
 	s, err := plan.Open()
 	if err != nil {
 		return 0, err
@@ -53,6 +57,9 @@ func (p *BasicUpdatePlanner) ExecuteUpdate(data *parse.UpdateData, tx *tx.Transa
 		return 0, err
 	}
 	plan = NewSelectPlan(plan, data.Pred)
+	// As for a delete, scan for the records under update locks.
+	tx.SetReadForUpdate(true)
+	defer tx.SetReadForUpdate(false)
 	s, err := plan.Open()
 	if err != nil {
 		return 0, err
@@ -89,7 +96,11 @@ func (p *BasicUpdatePlanner) ExecuteInsert(data *parse.InsertData, tx *tx.Transa
 	if err != nil {
 		return 0, err
 	}
-	for i, val := range data.Values {
+	for i, expr := range data.Values {
+		val, err := expr.Evaluate(us)
+		if err != nil {
+			return 0, err
+		}
 		err = us.SetVal(data.Fields[i], val)
 		if err != nil {
 			return 0, err
@@ -122,3 +133,7 @@ func (p *BasicUpdatePlanner) ExecuteCreateIndex(data *parse.CreateIndexData, tx
 	}
 	return 0, nil
 }
+
+func (p *BasicUpdatePlanner) ExecuteAnalyze(data *parse.AnalyzeData, tx *tx.Transaction) (int, error) {
+	return p.mdm.Analyze(data.TableName, tx)
+}
@@ -0,0 +1,138 @@
+package plan
+
+import (
+	"fmt"
+	"simpledb/internal/query"
+	"simpledb/internal/record"
+	"simpledb/internal/tx"
+	"strings"
+)
+
+// ExplainField is the name of the single field output by an EXPLAIN
+// statement.
+const ExplainField = "plan"
+
+// maxExplainLen is the declared length of the EXPLAIN output field.
+const maxExplainLen = 200
+
+// ExplainPlan is the plan for an EXPLAIN or EXPLAIN ANALYZE statement.
+// Its output has one record per node of the explained plan, each holding
+// a line of text in the "plan" field.
+type ExplainPlan struct {
+	p       query.Plan
+	analyze bool
+	tx      *tx.Transaction
+	schema  *record.Schema
+}
+
+var _ query.Plan = (*ExplainPlan)(nil)
+
+// NewExplainPlan creates a new ExplainPlan for the specified plan.
+// If analyze is true, the plan is run when the ExplainPlan is opened and
+// the output includes the actual rows, blocks and time of each node.
+func NewExplainPlan(p query.Plan, analyze bool, tx *tx.Transaction) *ExplainPlan {
+	schema := record.NewSchema()
+	schema.AddStringField(ExplainField, maxExplainLen)
+	return &ExplainPlan{p: p, analyze: analyze, tx: tx, schema: schema}
+}
+
+// Open describes the explained plan, running it first if this is an
+// EXPLAIN ANALYZE, and returns a scan over the lines of the description.
+func (ep *ExplainPlan) Open() (record.Scan, error) {
+	var lines []string
+	if ep.analyze {
+		ap := NewAnalyzePlan(ep.p, ep.tx)
+		s, err := ap.Open()
+		if err != nil {
+			return nil, err
+		}
+		for s.Next() {
+		}
+		s.Close()
+		lines = Explain(ap)
+	} else {
+		lines = Explain(ep.p)
+	}
+	rows := make([]map[string]record.Constant, len(lines))
+	for i, line := range lines {
+		rows[i] = map[string]record.Constant{ExplainField: record.NewStringConstant(line)}
+	}
+	return query.NewValuesScan(ep.schema, rows), nil
+}
+
+// BlocksAccessed returns the cost of the explained plan if it will be run,
+// and 0 otherwise.
+func (ep *ExplainPlan) BlocksAccessed() int {
+	if ep.analyze {
+		return ep.p.BlocksAccessed()
+	}
+	return 0
+}
+
+// RecordsOutput returns the number of nodes in the explained plan.
+func (ep *ExplainPlan) RecordsOutput() int {
+	return countNodes(ep.p)
+}
+
+// DistinctValues returns the number of output records, since each line of
+// the description is distinct.
+func (ep *ExplainPlan) DistinctValues(fldname string) int {
+	return ep.RecordsOutput()
+}
+
+// Schema returns the schema of the output of this plan.
+func (ep *ExplainPlan) Schema() *record.Schema {
+	return ep.schema
+}
+
+// Describe returns a description of this plan node.
+func (ep *ExplainPlan) Describe() string {
+	if ep.analyze {
+		return "Explain Analyze"
+	}
+	return "Explain"
+}
+
+// Children returns the explained plan.
+func (ep *ExplainPlan) Children() []query.Plan {
+	return []query.Plan{ep.p}
+}
+
+// Explain returns a description of the specified plan tree, one line per
+// plan node. Each line shows the node's estimated cost in blocks accessed
+// and its estimated number of output records. Nodes of an AnalyzePlan
+// that has been run also show their actual rows, blocks and time.
+func Explain(p query.Plan) []string {
+	var lines []string
+	explainNode(p, 0, &lines)
+	return lines
+}
+
+// explainNode appends the description of a plan node and its children
+// to lines.
+func explainNode(p query.Plan, depth int, lines *[]string) {
+	var line strings.Builder
+	if depth > 0 {
+		line.WriteString(strings.Repeat("  ", depth))
+		line.WriteString("-> ")
+	}
+	line.WriteString(p.Describe())
+	line.WriteString(fmt.Sprintf("  (cost=%d rows=%d)", p.BlocksAccessed(), p.RecordsOutput()))
+	if ap, ok := p.(*AnalyzePlan); ok {
+		ms := float64(ap.Stats.Time.Microseconds()) / 1000
+		line.WriteString(fmt.Sprintf(" (actual rows=%d blocks=%d time=%.3fms)", ap.Stats.Rows, ap.Stats.Blocks, ms))
+	}
+	*lines = append(*lines, line.String())
+	for _, child := range p.Children() {
+		explainNode(child, depth+1, lines)
+	}
+}
+
+// countNodes returns the number of nodes in the specified plan tree.
+func countNodes(p query.Plan) int {
+	n := 1
+	for _, child := range p.Children() {
+		n += countNodes(child)
+	}
+	return n
+}
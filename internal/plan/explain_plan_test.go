@@ -0,0 +1,87 @@
+package plan_test
+
+import (
+	"os"
+	"simpledb/internal/plan"
+	"simpledb/internal/server"
+	"simpledb/internal/testutil"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("explaintest")
+	})
+
+	db, err := server.NewSimpleDB("explaintest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	testutil.SetupUniversityDB(t, db)
+
+	tx, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+
+	explain := func(qry string) []string {
+		p, err := db.Planner.CreateQueryPlan(qry, tx)
+		if err != nil {
+			t.Fatalf("Failed to create query plan: %v", err)
+		}
+		s, err := p.Open()
+		if err != nil {
+			t.Fatalf("Failed to open query plan: %v", err)
+		}
+		defer s.Close()
+		var lines []string
+		for s.Next() {
+			line, err := s.GetString(plan.ExplainField)
+			if err != nil {
+				t.Fatalf("Failed to get plan line: %v", err)
+			}
+			t.Log(line)
+			lines = append(lines, line)
+		}
+		return lines
+	}
+
+	lines := explain("explain select sname, dname from student, department where majorid = did")
+	expected := []string{
+		"Project (sname, dname)",
+		"  -> Select (majorid = did)",
+		"    -> Product",
+		"      -> Table Scan on student",
+		"      -> Table Scan on department",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d plan lines, got %d", len(expected), len(lines))
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("Expected line %d to start with %q, got %q", i, prefix, lines[i])
+		}
+		if strings.Contains(lines[i], "actual") {
+			t.Errorf("Expected line %d to have no actual statistics, got %q", i, lines[i])
+		}
+	}
+
+	lines = explain("explain analyze select sname from student where majorid = 20")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 plan lines, got %d", len(lines))
+	}
+	// Four students have majorid 20.
+	if !strings.Contains(lines[0], "actual rows=4 ") {
+		t.Errorf("Expected the project node to output 4 rows, got %q", lines[0])
+	}
+	if !strings.Contains(lines[2], "actual rows=8 ") {
+		t.Errorf("Expected the table scan to output 8 rows, got %q", lines[2])
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
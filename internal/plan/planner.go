@@ -1,33 +1,79 @@
 package plan
 
 import (
-	"errors"
+	"fmt"
 	"simpledb/internal/parse"
 	"simpledb/internal/query"
+	"simpledb/internal/record"
 	"simpledb/internal/tx"
 )
 
 // Planner executes SQL statements.
+// Parsed statements are kept in a statement cache, so executing the same SQL
+// text again does not re-parse it.
 type Planner struct {
-	qp QueryPlanner
-	up UpdatePlanner
+	qp       QueryPlanner
+	up       UpdatePlanner
+	cache    *StatementCache
+	settings map[string]Setting
 }
 
+// Setting applies a new value of a database setting that can be changed
+// with the SQL set statement.
+type Setting func(val record.Constant) error
+
 // NewPlanner creates a new Planner.
 func NewPlanner(qp QueryPlanner, up UpdatePlanner) *Planner {
-	return &Planner{qp: qp, up: up}
+	return &Planner{qp: qp, up: up, cache: NewStatementCache(DefaultStatementCacheSize), settings: make(map[string]Setting)}
+}
+
+// RegisterSetting makes the specified setting available to the SQL set
+// statement under the given name. Settings should be registered before
+// the planner is used.
+func (p *Planner) RegisterSetting(name string, setting Setting) {
+	p.settings[name] = setting
+}
+
+// applySetting changes the value of a registered setting.
+func (p *Planner) applySetting(data *parse.SetData) error {
+	setting, ok := p.settings[data.Name]
+	if !ok {
+		return fmt.Errorf("unknown setting: %s", data.Name)
+	}
+	return setting(data.Val)
+}
+
+// Prepare parses the specified SQL statement and returns a PreparedStatement
+// that can be executed repeatedly with different parameter values.
+// If the statement is already in the statement cache, the cached statement is
+// returned.
+func (p *Planner) Prepare(sql string) (*PreparedStatement, error) {
+	if ps, ok := p.cache.Get(sql); ok {
+		return ps, nil
+	}
+	lexer := parse.NewLexer(sql)
+	parser := parse.NewParser(lexer)
+	stmt, err := parser.Statement()
+	if err != nil {
+		return nil, err
+	}
+	ps := &PreparedStatement{planner: p, sql: sql, stmt: stmt, numParams: parser.NumParams()}
+	p.cache.Put(ps)
+	return ps, nil
+}
+
+// StatementCache returns the planner's cache of prepared statements.
+func (p *Planner) StatementCache() *StatementCache {
+	return p.cache
 }
 
 // CreateQueryPlan creates a query plan for the given SQL query.
 func (p *Planner) CreateQueryPlan(query string, tx *tx.Transaction) (query.Plan, error) {
-	lexer := parse.NewLexer(query)
-	parser := parse.NewParser(lexer)
-	data, err := parser.Query()
+	ps, err := p.Prepare(query)
 	if err != nil {
 		return nil, err
 	}
-	// TODO: verify the query
-	return p.qp.CreatePlan(data, tx)
+	return ps.Query(tx)
 }
 
 // ExecuteUpdate executes a SQL insert, delete, modify, or create statement.
@@ -35,30 +81,9 @@ func (p *Planner) CreateQueryPlan(query string, tx *tx.Transaction) (query.Plan,
 // update planner, depending on what the parser returns.
 // It returns the number of records affected by the update.
 func (p *Planner) ExecuteUpdate(query string, tx *tx.Transaction) (int, error) {
-	lexer := parse.NewLexer(query)
-	parser := parse.NewParser(lexer)
-	cmd, err := parser.UpdateCmd()
+	ps, err := p.Prepare(query)
 	if err != nil {
 		return 0, err
 	}
-	// TODO: verify the query
-	if insertCmd, ok := cmd.(*parse.InsertData); ok {
-		return p.up.ExecuteInsert(insertCmd, tx)
-	}
-	if deleteCmd, ok := cmd.(*parse.DeleteData); ok {
-		return p.up.ExecuteDelete(deleteCmd, tx)
-	}
-	if updateCmd, ok := cmd.(*parse.UpdateData); ok {
-		return p.up.ExecuteUpdate(updateCmd, tx)
-	}
-	if createTableCmd, ok := cmd.(*parse.CreateTableData); ok {
-		return p.up.ExecuteCreateTable(createTableCmd, tx)
-	}
-	if createViewCmd, ok := cmd.(*parse.CreateViewData); ok {
-		return p.up.ExecuteCreateView(createViewCmd, tx)
-	}
-	if createIndexCmd, ok := cmd.(*parse.CreateIndexData); ok {
-		return p.up.ExecuteCreateIndex(createIndexCmd, tx)
-	}
-	return 0, errors.New("invalid update command")
+	return ps.Execute(tx)
 }
@@ -1,10 +1,14 @@
 package plan_test
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"simpledb/internal/server"
+	"simpledb/internal/tx"
+	"simpledb/internal/tx/concurrency"
 	"testing"
+	"time"
 
 	"math/rand"
 )
@@ -151,3 +155,373 @@ func TestPlanner2(t *testing.T) {
 		t.Fatalf("Failed to commit transaction: %v", err)
 	}
 }
+
+func TestPlannerSet(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("plannersettest")
+	})
+
+	db, err := server.NewSimpleDB("plannersettest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if _, err := db.Planner.ExecuteUpdate("set buffers = 20", tx); err != nil {
+		t.Fatalf("Failed to execute set: %v", err)
+	}
+	if n := db.BufferMgr.Size(); n != 20 {
+		t.Fatalf("Expected 20 buffers, got %d", n)
+	}
+	if _, err := db.Planner.ExecuteUpdate("set group_commit_window = 100", tx); err != nil {
+		t.Fatalf("Failed to execute set: %v", err)
+	}
+	if _, err := db.Planner.ExecuteUpdate("set deadlock_policy = 'wound-wait'", tx); err != nil {
+		t.Fatalf("Failed to execute set: %v", err)
+	}
+	if p := db.LockTable.DeadlockPolicy(); p != concurrency.WoundWait {
+		t.Fatalf("Expected the wound-wait policy, got %v", p)
+	}
+	for _, cmd := range []string{"set buffers = 'many'", "set buffers = 0", "set group_commit_window = 'x'", "set deadlock_policy = 'never'", "set deadlock_policy = 1", "set nosuchsetting = 1"} {
+		if _, err := db.Planner.ExecuteUpdate(cmd, tx); err == nil {
+			t.Fatalf("case %s: expected an error, got nil", cmd)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
+
+func TestPlannerSavepoint(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("plannersavepointtest")
+	})
+
+	db, err := server.NewSimpleDB("plannersavepointtest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	cmds := []string{
+		"create table T(A int, B varchar(9))",
+		"insert into T(A, B) values(1, 'one')",
+		"savepoint sp1",
+		"insert into T(A, B) values(2, 'two')",
+		"update T set B = 'changed'",
+		"rollback to savepoint sp1",
+		"insert into T(A, B) values(3, 'three')",
+		"release savepoint sp1",
+	}
+	for _, cmd := range cmds {
+		if _, err := db.Planner.ExecuteUpdate(cmd, tx); err != nil {
+			t.Fatalf("case %s: failed to execute update: %v", cmd, err)
+		}
+	}
+	if _, err := db.Planner.ExecuteUpdate("rollback to sp1", tx); err == nil {
+		t.Fatal("Expected an error rolling back to a released savepoint")
+	}
+
+	p, err := db.Planner.CreateQueryPlan("select A, B from T", tx)
+	if err != nil {
+		t.Fatalf("Failed to create query plan: %v", err)
+	}
+	s, err := p.Open()
+	if err != nil {
+		t.Fatalf("Failed to open scan: %v", err)
+	}
+	got := make(map[int32]string)
+	for s.Next() {
+		a, err := s.GetInt("A")
+		if err != nil {
+			t.Fatalf("Failed to get int: %v", err)
+		}
+		b, err := s.GetString("B")
+		if err != nil {
+			t.Fatalf("Failed to get string: %v", err)
+		}
+		got[a] = b
+	}
+	s.Close()
+	if len(got) != 2 || got[1] != "one" || got[3] != "three" {
+		t.Fatalf("Expected rows 1 and 3 unchanged, got %v", got)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
+
+func TestPlannerStatementAtomicity(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("planneratomictest")
+	})
+
+	db, err := server.NewSimpleDB("planneratomictest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if _, err := db.Planner.ExecuteUpdate("create table T(A int, B varchar(4), C varchar(20))", tx); err != nil {
+		t.Fatalf("Failed to execute update: %v", err)
+	}
+	for i, c := range []string{"a", "b", "c", "d", "much too long", "f"} {
+		cmd := fmt.Sprintf("insert into T(A, B, C) values(%d, 'old', '%s')", i, c)
+		if _, err := db.Planner.ExecuteUpdate(cmd, tx); err != nil {
+			t.Fatalf("case %s: failed to execute update: %v", cmd, err)
+		}
+	}
+	available := tx.AvailableBufs()
+
+	// The update fails on the fifth row, after changing four of them.
+	if _, err := db.Planner.ExecuteUpdate("update T set B = C", tx); err == nil {
+		t.Fatal("Expected the update to fail")
+	}
+	// The insert fails in SetVal, after claiming a slot.
+	if _, err := db.Planner.ExecuteUpdate("insert into T(A, B, C) values(9, 'much too long', 'x')", tx); err == nil {
+		t.Fatal("Expected the insert to fail")
+	}
+	if n := tx.AvailableBufs(); n != available {
+		t.Fatalf("Expected %d available buffers, got %d", available, n)
+	}
+
+	// The transaction is still usable.
+	if _, err := db.Planner.ExecuteUpdate("update T set B = 'new' where A = 0", tx); err != nil {
+		t.Fatalf("Failed to execute update: %v", err)
+	}
+	p, err := db.Planner.CreateQueryPlan("select A, B from T", tx)
+	if err != nil {
+		t.Fatalf("Failed to create query plan: %v", err)
+	}
+	s, err := p.Open()
+	if err != nil {
+		t.Fatalf("Failed to open scan: %v", err)
+	}
+	count := 0
+	for s.Next() {
+		a, err := s.GetInt("A")
+		if err != nil {
+			t.Fatalf("Failed to get int: %v", err)
+		}
+		b, err := s.GetString("B")
+		if err != nil {
+			t.Fatalf("Failed to get string: %v", err)
+		}
+		expected := "old"
+		if a == 0 {
+			expected = "new"
+		}
+		if b != expected {
+			t.Fatalf("Expected row %d to have B = %s, got %s", a, expected, b)
+		}
+		count++
+	}
+	s.Close()
+	if count != 6 {
+		t.Fatalf("Expected 6 rows, got %d", count)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
+
+func TestPlannerUpdateLocks(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("plannerulocktest")
+	})
+
+	db, err := server.NewSimpleDB("plannerulocktest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	for _, cmd := range []string{"create table T(A int, B varchar(9))", "insert into T(A, B) values(1, 'one')"} {
+		if _, err := db.Planner.ExecuteUpdate(cmd, tx); err != nil {
+			t.Fatalf("case %s: failed to execute update: %v", cmd, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	// An update that modifies no records still holds update locks on the
+	// blocks it scanned.
+	tx1, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if n, err := db.Planner.ExecuteUpdate("update T set B = 'none' where A = 99", tx1); err != nil || n != 0 {
+		t.Fatalf("Expected no records updated, got %d, %v", n, err)
+	}
+
+	// Queries can still read the blocks.
+	tx2, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	p, err := db.Planner.CreateQueryPlan("select B from T", tx2)
+	if err != nil {
+		t.Fatalf("Failed to create query plan: %v", err)
+	}
+	s, err := p.Open()
+	if err != nil {
+		t.Fatalf("Failed to open scan: %v", err)
+	}
+	for s.Next() {
+	}
+	s.Close()
+	if err := tx2.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	// Another update waits for the first one to finish, even though it
+	// doesn't modify any records either.
+	tx3, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := db.Planner.ExecuteUpdate("update T set B = 'none' where A = 98", tx3)
+		if err == nil {
+			err = tx3.Commit()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the second update to wait, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Second update failed: %v", err)
+	}
+}
+
+func TestPlannerDeadlockRollsBack(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("plannerdeadlocktest")
+	})
+
+	db, err := server.NewSimpleDB("plannerdeadlocktest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	setup, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	// Planning a query on an empty view catalog appends a block to it,
+	// under an exclusive lock, so the setup creates a view.
+	setupCmds := []string{
+		"create table T(A int)",
+		"create table U(A int)",
+		"create table V(A int)",
+		"insert into T(A) values(1)",
+		"insert into U(A) values(1)",
+		"create view W as select A from T",
+	}
+	for _, cmd := range setupCmds {
+		if _, err := db.Planner.ExecuteUpdate(cmd, setup); err != nil {
+			t.Fatalf("Failed to execute %q: %v", cmd, err)
+		}
+	}
+	if err := setup.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	count := func(tx *tx.Transaction, tblname string) int {
+		t.Helper()
+		p, err := db.Planner.CreateQueryPlan("select A from "+tblname, tx)
+		if err != nil {
+			t.Fatalf("Failed to create query plan: %v", err)
+		}
+		s, err := p.Open()
+		if err != nil {
+			t.Fatalf("Failed to open scan: %v", err)
+		}
+		defer s.Close()
+		n := 0
+		for s.Next() {
+			n++
+		}
+		return n
+	}
+
+	// Each transaction reads one table and then updates the other, so each
+	// waits for the other. The younger one has also changed V.
+	older, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	younger, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if _, err := db.Planner.ExecuteUpdate("insert into V(A) values(5)", younger); err != nil {
+		t.Fatalf("Failed to execute update: %v", err)
+	}
+	count(older, "T")
+	count(younger, "U")
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		if _, err := db.Planner.ExecuteUpdate("update U set A = 10", older); err != nil {
+			done <- err
+			return
+		}
+		done <- older.Commit()
+	}()
+	time.Sleep(200 * time.Millisecond)
+	var dlerr *concurrency.DeadlockError
+	if _, err := db.Planner.ExecuteUpdate("update T set A = 20", younger); !errors.As(err, &dlerr) {
+		t.Fatalf("Expected a deadlock error, got %v", err)
+	}
+
+	// The younger transaction was rolled back, releasing its locks.
+	if err := <-done; err != nil {
+		t.Fatalf("Older transaction failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected the older transaction to go on right away, took %v", elapsed)
+	}
+
+	// The younger transaction can be used again, as a new transaction,
+	// and its earlier change is gone.
+	txnum := younger.TxNum()
+	if n := count(younger, "V"); n != 0 {
+		t.Fatalf("Expected the insert into V to be rolled back, got %d rows", n)
+	}
+	if younger.TxNum() == txnum {
+		t.Fatalf("Expected the transaction to restart with a new number, but it kept %d", txnum)
+	}
+	if _, err := db.Planner.ExecuteUpdate("update T set A = 20", younger); err != nil {
+		t.Fatalf("Failed to execute update: %v", err)
+	}
+	if err := younger.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
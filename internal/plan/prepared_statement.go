@@ -0,0 +1,173 @@
+package plan
+
+import (
+	"errors"
+	"fmt"
+	"simpledb/internal/parse"
+	"simpledb/internal/query"
+	"simpledb/internal/record"
+	"simpledb/internal/tx"
+	"simpledb/internal/tx/concurrency"
+)
+
+// PreparedStatement is a SQL statement that has been parsed once and can be
+// executed many times with different parameter values.
+// Parameters are written in the SQL text as "?" or "$n", and are bound to
+// the constants supplied on each execution.
+type PreparedStatement struct {
+	planner   *Planner
+	sql       string
+	stmt      interface{}
+	numParams int
+}
+
+// SQL returns the text of the statement.
+func (ps *PreparedStatement) SQL() string {
+	return ps.sql
+}
+
+// NumParams returns the number of parameters in the statement.
+func (ps *PreparedStatement) NumParams() int {
+	return ps.numParams
+}
+
+// IsQuery returns true if the statement is a select or explain statement.
+func (ps *PreparedStatement) IsQuery() bool {
+	switch ps.stmt.(type) {
+	case *parse.QueryData, *parse.ExplainData:
+		return true
+	}
+	return false
+}
+
+// Query binds the specified values to the statement's parameters and
+// creates a query plan for it.
+// It returns an error if the statement is not a query.
+func (ps *PreparedStatement) Query(tx *tx.Transaction, args ...record.Constant) (query.Plan, error) {
+	if !ps.IsQuery() {
+		return nil, errors.New("statement is not a query")
+	}
+	if err := ps.checkArgs(args); err != nil {
+		return nil, err
+	}
+	// TODO: verify the query
+	if explain, ok := ps.stmt.(*parse.ExplainData); ok {
+		data, err := explain.Bind(args)
+		if err != nil {
+			return nil, err
+		}
+		p, err := ps.planner.qp.CreatePlan(data.Query, tx)
+		if err != nil {
+			return nil, err
+		}
+		return NewExplainPlan(p, data.Analyze, tx), nil
+	}
+	data, err := ps.stmt.(*parse.QueryData).Bind(args)
+	if err != nil {
+		return nil, err
+	}
+	return ps.planner.qp.CreatePlan(data, tx)
+}
+
+// statementSavepoint is the name of the savepoint that Execute sets before
+// each statement. It is not an identifier, so it can't be used in SQL.
+const statementSavepoint = "(statement)"
+
+// Execute binds the specified values to the statement's parameters and
+// executes it as an insert, delete, modify, create, analyze, set, or
+// savepoint statement.
+// Each statement is atomic: if it fails, the changes it made are undone,
+// and the transaction can still be used. If it fails because the
+// transaction couldn't get a lock, having been aborted to deal with a
+// deadlock or having waited too long, the whole transaction is rolled back
+// instead, so that the transactions waiting for its locks can go on. The
+// transaction can then be used again, like after any rollback: it restarts
+// as a new transaction, so that the changes it makes from then on are
+// recovered separately from those that were rolled back.
+// It returns the number of records affected.
+func (ps *PreparedStatement) Execute(tx *tx.Transaction, args ...record.Constant) (int, error) {
+	if err := ps.checkArgs(args); err != nil {
+		return 0, err
+	}
+	switch cmd := ps.stmt.(type) {
+	case *parse.SetData:
+		return 0, ps.planner.applySetting(cmd)
+	case *parse.SavepointData:
+		tx.Savepoint(cmd.Name)
+		return 0, nil
+	case *parse.RollbackToData:
+		return 0, tx.RollbackToSavepoint(cmd.Name)
+	case *parse.ReleaseData:
+		return 0, tx.ReleaseSavepoint(cmd.Name)
+	}
+
+	tx.Savepoint(statementSavepoint)
+	defer tx.ReleaseSavepoint(statementSavepoint)
+	n, err := ps.execute(tx, args)
+	if isLockError(err) {
+		if rerr := tx.Rollback(); rerr != nil {
+			return 0, errors.Join(err, rerr)
+		}
+		return 0, err
+	}
+	if err != nil {
+		if rerr := tx.RollbackToSavepoint(statementSavepoint); rerr != nil {
+			return 0, errors.Join(err, rerr)
+		}
+		return 0, err
+	}
+	return n, nil
+}
+
+// isLockError returns true if the error means that a transaction couldn't
+// get a lock and has to be rolled back.
+func isLockError(err error) bool {
+	var dlerr *concurrency.DeadlockError
+	var laerr *concurrency.LockAbortError
+	return errors.As(err, &dlerr) || errors.As(err, &laerr)
+}
+
+// execute executes the statement as an insert, delete, modify, create, or
+// analyze statement.
+func (ps *PreparedStatement) execute(tx *tx.Transaction, args []record.Constant) (int, error) {
+	// TODO: verify the query
+	up := ps.planner.up
+	switch cmd := ps.stmt.(type) {
+	case *parse.InsertData:
+		data, err := cmd.Bind(args)
+		if err != nil {
+			return 0, err
+		}
+		return up.ExecuteInsert(data, tx)
+	case *parse.DeleteData:
+		data, err := cmd.Bind(args)
+		if err != nil {
+			return 0, err
+		}
+		return up.ExecuteDelete(data, tx)
+	case *parse.UpdateData:
+		data, err := cmd.Bind(args)
+		if err != nil {
+			return 0, err
+		}
+		return up.ExecuteUpdate(data, tx)
+	case *parse.CreateTableData:
+		return up.ExecuteCreateTable(cmd, tx)
+	case *parse.CreateViewData:
+		return up.ExecuteCreateView(cmd, tx)
+	case *parse.CreateIndexData:
+		return up.ExecuteCreateIndex(cmd, tx)
+	case *parse.AnalyzeData:
+		return up.ExecuteAnalyze(cmd, tx)
+	}
+	return 0, errors.New("invalid update command")
+}
+
+// checkArgs returns an error if the number of arguments does not match the
+// number of parameters in the statement.
+func (ps *PreparedStatement) checkArgs(args []record.Constant) error {
+	if len(args) != ps.numParams {
+		return fmt.Errorf("statement has %d parameters, but %d values were supplied", ps.numParams, len(args))
+	}
+	return nil
+}
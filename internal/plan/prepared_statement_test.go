@@ -0,0 +1,101 @@
+package plan_test
+
+import (
+	"fmt"
+	"os"
+	"simpledb/internal/record"
+	"simpledb/internal/server"
+	"testing"
+)
+
+func TestPreparedStatement(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("preparedtest")
+	})
+
+	db, err := server.NewSimpleDB("preparedtest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+
+	if _, err := db.Planner.ExecuteUpdate("create table T(A int, B varchar(9))", tx); err != nil {
+		t.Fatalf("Failed to execute update: %v", err)
+	}
+
+	insert, err := db.Planner.Prepare("insert into T(A, B) values(?, ?)")
+	if err != nil {
+		t.Fatalf("Failed to prepare insert: %v", err)
+	}
+	if insert.NumParams() != 2 {
+		t.Fatalf("Expected 2 parameters, got %d", insert.NumParams())
+	}
+	for i := 0; i < 20; i++ {
+		a := record.NewIntConstant(int32(i % 5))
+		b := record.NewStringConstant(fmt.Sprintf("it's %d", i))
+		if _, err := insert.Execute(tx, a, b); err != nil {
+			t.Fatalf("Failed to execute insert: %v", err)
+		}
+	}
+	if _, err := insert.Execute(tx, record.NewIntConstant(1)); err == nil {
+		t.Fatal("Expected an error when too few values are supplied")
+	}
+
+	// Preparing the same text again should return the cached statement.
+	again, err := db.Planner.Prepare("insert into T(A, B) values(?, ?)")
+	if err != nil {
+		t.Fatalf("Failed to prepare insert: %v", err)
+	}
+	if again != insert {
+		t.Fatal("Expected the prepared statement to be served from the statement cache")
+	}
+
+	sel, err := db.Planner.Prepare("select B from T where A = $1")
+	if err != nil {
+		t.Fatalf("Failed to prepare query: %v", err)
+	}
+	for a := int32(0); a < 5; a++ {
+		p, err := sel.Query(tx, record.NewIntConstant(a))
+		if err != nil {
+			t.Fatalf("Failed to create query plan: %v", err)
+		}
+		s, err := p.Open()
+		if err != nil {
+			t.Fatalf("Failed to open query plan: %v", err)
+		}
+		count := 0
+		for s.Next() {
+			count++
+		}
+		s.Close()
+		if count != 4 {
+			t.Errorf("Expected 4 records with A=%d, got %d", a, count)
+		}
+	}
+
+	// A statement is planned against the catalog when it is executed, so a
+	// cached statement can refer to a table created after it was prepared.
+	insertU, err := db.Planner.Prepare("insert into U(C) values(?)")
+	if err != nil {
+		t.Fatalf("Failed to prepare insert: %v", err)
+	}
+	n := db.Planner.StatementCache().Len()
+	if _, err := db.Planner.ExecuteUpdate("create table U(C int)", tx); err != nil {
+		t.Fatalf("Failed to execute update: %v", err)
+	}
+	if got := db.Planner.StatementCache().Len(); got != n+1 {
+		t.Errorf("Expected %d statements in the cache after DDL, got %d", n+1, got)
+	}
+	if _, err := insertU.Execute(tx, record.NewIntConstant(1)); err != nil {
+		t.Fatalf("Failed to execute insert: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
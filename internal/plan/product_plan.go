@@ -56,7 +56,29 @@ func (pp *ProductPlan) DistinctValues(fldname string) int {
 	return pp.p2.DistinctValues(fldname)
 }
 
+// RecordsWithValue estimates the number of output records with the
+// specified value for a field. Each matching record of the subquery that
+// has the field is combined with every record of the other subquery.
+func (pp *ProductPlan) RecordsWithValue(fldname string, val record.Constant) (int, bool) {
+	if pp.p1.Schema().HasField(fldname) {
+		n, ok := recordsWithValue(pp.p1, fldname, val)
+		return n * pp.p2.RecordsOutput(), ok
+	}
+	n, ok := recordsWithValue(pp.p2, fldname, val)
+	return n * pp.p1.RecordsOutput(), ok
+}
+
 // Schema returns the schema of the output of this plan.
 func (pp *ProductPlan) Schema() *record.Schema {
 	return pp.schema
 }
+
+// Describe returns a description of this plan node.
+func (pp *ProductPlan) Describe() string {
+	return "Product"
+}
+
+// Children returns the two subqueries.
+func (pp *ProductPlan) Children() []query.Plan {
+	return []query.Plan{pp.p1, pp.p2}
+}
@@ -1,8 +1,10 @@
 package plan
 
 import (
+	"fmt"
 	"simpledb/internal/query"
 	"simpledb/internal/record"
+	"strings"
 )
 
 // ProjectPlan represents a query plan that projects a subset of fields
@@ -52,7 +54,23 @@ func (pp *ProjectPlan) DistinctValues(fldname string) int {
 	return pp.p.DistinctValues(fldname)
 }
 
+// RecordsWithValue returns the underlying query's estimate of the number
+// of records with the specified value for a field.
+func (pp *ProjectPlan) RecordsWithValue(fldname string, val record.Constant) (int, bool) {
+	return recordsWithValue(pp.p, fldname, val)
+}
+
 // Schema returns the schema of the output of this plan.
 func (pp *ProjectPlan) Schema() *record.Schema {
 	return pp.schema
 }
+
+// Describe returns a description of this plan node.
+func (pp *ProjectPlan) Describe() string {
+	return fmt.Sprintf("Project (%s)", strings.Join(pp.schema.Fields, ", "))
+}
+
+// Children returns the underlying subquery.
+func (pp *ProjectPlan) Children() []query.Plan {
+	return []query.Plan{pp.p}
+}
@@ -1,6 +1,7 @@
 package plan
 
 import (
+	"fmt"
 	"simpledb/internal/query"
 	"simpledb/internal/record"
 )
@@ -12,6 +13,8 @@ type SelectPlan struct {
 	pred *query.Predicate
 }
 
+var _ query.Plan = (*SelectPlan)(nil)
+
 // NewSelectPlan creates a new SelectPlan with the specified
 // subquery and predicate.
 func NewSelectPlan(p query.Plan, pred *query.Predicate) *SelectPlan {
@@ -60,7 +63,39 @@ func (sp *SelectPlan) DistinctValues(fldname string) int {
 	return sp.p.DistinctValues(fldname)
 }
 
+// RecordsWithValue estimates the number of output records with the
+// specified value for a field.
+// If the predicate equates the field to a constant, then either all or
+// none of the output records have the value. Otherwise the estimate of the
+// underlying query is scaled down to the size of the output table.
+func (sp *SelectPlan) RecordsWithValue(fldname string, val record.Constant) (int, bool) {
+	if c := sp.pred.EquatesWithConstant(fldname); c != nil {
+		if c.Equal(val) {
+			return sp.RecordsOutput(), true
+		}
+		return 0, true
+	}
+	n, ok := recordsWithValue(sp.p, fldname, val)
+	if !ok {
+		return 0, false
+	}
+	if total := sp.p.RecordsOutput(); total > 0 {
+		n = n * sp.RecordsOutput() / total
+	}
+	return n, true
+}
+
 // Schema returns the schema of the output of this query.
 func (sp *SelectPlan) Schema() *record.Schema {
 	return sp.p.Schema()
 }
+
+// Describe returns a description of this plan node.
+func (sp *SelectPlan) Describe() string {
+	return fmt.Sprintf("Select (%s)", sp.pred.String())
+}
+
+// Children returns the underlying subquery.
+func (sp *SelectPlan) Children() []query.Plan {
+	return []query.Plan{sp.p}
+}
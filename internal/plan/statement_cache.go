@@ -0,0 +1,76 @@
+package plan
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultStatementCacheSize is the number of prepared statements a Planner
+// keeps in its statement cache.
+const DefaultStatementCacheSize = 64
+
+// StatementCache is a fixed-size cache of prepared statements, keyed by
+// their SQL text. When the cache is full, the least recently used statement
+// is evicted to make room for a new one.
+//
+// The cache stores parsed statements rather than query.Plan objects, since a
+// plan is tied to the transaction that created it. A statement is planned
+// each time it is executed, so it is planned against the current catalog and
+// statistics, and the cache never has to be invalidated.
+type StatementCache struct {
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // most recently used statement at the front
+	mu       sync.Mutex
+}
+
+// NewStatementCache creates a new StatementCache that holds up to capacity
+// statements.
+func NewStatementCache(capacity int) *StatementCache {
+	return &StatementCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the cached statement for the specified SQL text, if any,
+// and marks it as the most recently used.
+func (sc *StatementCache) Get(sql string) (*PreparedStatement, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	e, ok := sc.entries[sql]
+	if !ok {
+		return nil, false
+	}
+	sc.order.MoveToFront(e)
+	return e.Value.(*PreparedStatement), true
+}
+
+// Put adds a statement to the cache, evicting the least recently used
+// statement if the cache is full.
+func (sc *StatementCache) Put(ps *PreparedStatement) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.capacity <= 0 {
+		return
+	}
+	if e, ok := sc.entries[ps.sql]; ok {
+		e.Value = ps
+		sc.order.MoveToFront(e)
+		return
+	}
+	if sc.order.Len() >= sc.capacity {
+		oldest := sc.order.Back()
+		sc.order.Remove(oldest)
+		delete(sc.entries, oldest.Value.(*PreparedStatement).sql)
+	}
+	sc.entries[ps.sql] = sc.order.PushFront(ps)
+}
+
+// Len returns the number of statements in the cache.
+func (sc *StatementCache) Len() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.order.Len()
+}
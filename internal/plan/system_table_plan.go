@@ -0,0 +1,59 @@
+package plan
+
+import (
+	"fmt"
+	"simpledb/internal/metadata"
+	"simpledb/internal/query"
+	"simpledb/internal/record"
+)
+
+// SystemTablePlan is a plan that corresponds to a system table.
+// The table's records are generated when the plan is opened.
+type SystemTablePlan struct {
+	name string
+	st   metadata.SystemTable
+}
+
+var _ query.Plan = (*SystemTablePlan)(nil)
+
+// NewSystemTablePlan creates a new SystemTablePlan for the specified
+// system table.
+func NewSystemTablePlan(name string, st metadata.SystemTable) *SystemTablePlan {
+	return &SystemTablePlan{name: name, st: st}
+}
+
+// Open opens a scan over the current records of the system table.
+func (sp *SystemTablePlan) Open() (record.Scan, error) {
+	return query.NewValuesScan(sp.st.Schema(), sp.st.Rows()), nil
+}
+
+// BlocksAccessed returns 0, since system tables are held in memory.
+func (sp *SystemTablePlan) BlocksAccessed() int {
+	return 0
+}
+
+// RecordsOutput returns the current number of records in the system table.
+func (sp *SystemTablePlan) RecordsOutput() int {
+	return len(sp.st.Rows())
+}
+
+// DistinctValues returns an estimate of the number of distinct values for
+// the specified field, which is assumed to be the number of records.
+func (sp *SystemTablePlan) DistinctValues(fldname string) int {
+	return sp.RecordsOutput()
+}
+
+// Schema returns the schema of the system table.
+func (sp *SystemTablePlan) Schema() *record.Schema {
+	return sp.st.Schema()
+}
+
+// Describe returns a description of this plan node.
+func (sp *SystemTablePlan) Describe() string {
+	return fmt.Sprintf("System Table Scan on %s", sp.name)
+}
+
+// Children returns nil, since a system table plan has no subplans.
+func (sp *SystemTablePlan) Children() []query.Plan {
+	return nil
+}
@@ -0,0 +1,91 @@
+package plan_test
+
+import (
+	"os"
+	"simpledb/internal/server"
+	"testing"
+)
+
+func TestSystemTables(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("systabletest")
+	})
+
+	db, err := server.NewSimpleDB("systabletest")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if _, err := db.Planner.ExecuteUpdate("create table T(A int)", tx); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := db.Planner.ExecuteUpdate("insert into T(A) values(1)", tx); err != nil {
+		t.Fatalf("Failed to insert record: %v", err)
+	}
+
+	// The block of T that was just modified is in a dirty buffer, last
+	// modified by tx.
+	p, err := db.Planner.CreateQueryPlan("select frame, blknum, pins, dirty, modified_by from sys_buffers where filename = 'T.tbl'", tx)
+	if err != nil {
+		t.Fatalf("Failed to create plan: %v", err)
+	}
+	s, err := p.Open()
+	if err != nil {
+		t.Fatalf("Failed to open scan: %v", err)
+	}
+	if !s.Next() {
+		t.Fatal("Expected a buffer holding a block of T")
+	}
+	dirty, err := s.GetInt("dirty")
+	if err != nil {
+		t.Fatalf("Failed to get dirty flag: %v", err)
+	}
+	modifiedBy, err := s.GetInt("modified_by")
+	if err != nil {
+		t.Fatalf("Failed to get modified_by: %v", err)
+	}
+	if dirty != 1 || int(modifiedBy) != tx.TxNum() {
+		t.Fatalf("Expected the buffer to be dirty and modified by transaction %d, got dirty=%d modified_by=%d", tx.TxNum(), dirty, modifiedBy)
+	}
+	s.Close()
+
+	p, err = db.Planner.CreateQueryPlan("select pins, hits, misses from sys_buffer_stats", tx)
+	if err != nil {
+		t.Fatalf("Failed to create plan: %v", err)
+	}
+	s, err = p.Open()
+	if err != nil {
+		t.Fatalf("Failed to open scan: %v", err)
+	}
+	if !s.Next() {
+		t.Fatal("Expected a record of buffer statistics")
+	}
+	pins, err := s.GetInt("pins")
+	if err != nil {
+		t.Fatalf("Failed to get pins: %v", err)
+	}
+	hits, err := s.GetInt("hits")
+	if err != nil {
+		t.Fatalf("Failed to get hits: %v", err)
+	}
+	misses, err := s.GetInt("misses")
+	if err != nil {
+		t.Fatalf("Failed to get misses: %v", err)
+	}
+	if pins == 0 || hits+misses != pins {
+		t.Fatalf("Expected hits and misses to add up to pins, got pins=%d hits=%d misses=%d", pins, hits, misses)
+	}
+	s.Close()
+
+	if _, err := db.Planner.ExecuteUpdate("create table sys_buffers(A int)", tx); err == nil {
+		t.Fatal("Expected an error creating a table named after a system table")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package plan
 
 import (
+	"fmt"
 	"simpledb/internal/metadata"
 	"simpledb/internal/query"
 	"simpledb/internal/record"
@@ -56,7 +57,33 @@ func (tp *TablePlan) DistinctValues(fldname string) int {
 	return tp.si.DistinctValues(fldname)
 }
 
+// RecordsWithValue estimates the number of records with the specified
+// value for a field, using the table's column statistics.
+// It returns false if the table has not been analyzed.
+func (tp *TablePlan) RecordsWithValue(fldname string, val record.Constant) (int, bool) {
+	return tp.si.RecordsWithValue(fldname, val)
+}
+
 // Schema returns the schema of this plan.
 func (tp *TablePlan) Schema() *record.Schema {
 	return tp.layout.Schema
 }
+
+// Describe returns a description of this plan node.
+func (tp *TablePlan) Describe() string {
+	return fmt.Sprintf("Table Scan on %s", tp.tblname)
+}
+
+// Children returns nil, since a table plan has no subplans.
+func (tp *TablePlan) Children() []query.Plan {
+	return nil
+}
+
+// recordsWithValue returns the specified plan's estimate of the number of
+// records with the specified value for a field, if it has one.
+func recordsWithValue(p query.Plan, fldname string, val record.Constant) (int, bool) {
+	if ve, ok := p.(query.ValueEstimator); ok {
+		return ve.RecordsWithValue(fldname, val)
+	}
+	return 0, false
+}
@@ -31,4 +31,8 @@ type UpdatePlanner interface {
 	// ExecuteCreateIndex creates a plan for a create index statement,
 	// returning the number of affected records.
 	ExecuteCreateIndex(data *parse.CreateIndexData, tx *tx.Transaction) (int, error)
+
+	// ExecuteAnalyze gathers statistics for the tables named by an analyze
+	// statement, returning the number of tables analyzed.
+	ExecuteAnalyze(data *parse.AnalyzeData, tx *tx.Transaction) (int, error)
 }
@@ -1,10 +1,14 @@
 package query
 
-import "simpledb/internal/record"
+import (
+	"fmt"
+	"simpledb/internal/record"
+)
 
 type Expression struct {
 	val     *record.Constant // using pointer to represent nullable constant
 	fldname *string          // using pointer to represent nullable string
+	param   *int             // using pointer to represent nullable parameter index
 }
 
 // NewConstantExpression creates a new expression that evaluates to a constant value.
@@ -17,16 +21,39 @@ func NewFieldExpression(fldname string) Expression {
 	return Expression{fldname: &fldname}
 }
 
+// NewParamExpression creates a new expression that refers to a bind
+// parameter. Parameters are numbered starting from 1.
+func NewParamExpression(n int) Expression {
+	return Expression{param: &n}
+}
+
 // Evaluate evaluates the expression with respect to the
 // current record of the specified scan.
+// It returns an error if the expression is an unbound parameter.
 func (e Expression) Evaluate(s record.Scan) (record.Constant, error) {
 	if e.val != nil {
 		return *e.val, nil
+	} else if e.param != nil {
+		return record.Constant{}, fmt.Errorf("parameter $%d is not bound", *e.param)
 	} else {
 		return s.GetVal(*e.fldname)
 	}
 }
 
+// Bind returns a copy of the expression in which a parameter reference is
+// replaced by the corresponding constant in args. Field and constant
+// expressions are returned unchanged.
+func (e Expression) Bind(args []record.Constant) (Expression, error) {
+	if e.param == nil {
+		return e, nil
+	}
+	n := *e.param
+	if n < 1 || n > len(args) {
+		return Expression{}, fmt.Errorf("no value supplied for parameter $%d", n)
+	}
+	return NewConstantExpression(args[n-1]), nil
+}
+
 // Returns the constant corresponding to the constant expression,
 // or nil if the expression is a field reference.
 func (e Expression) Constant() *record.Constant {
@@ -39,10 +66,16 @@ func (e Expression) FieldName() *string {
 	return e.fldname
 }
 
+// Returns the parameter number corresponding to the parameter expression,
+// or nil if the expression is not a parameter.
+func (e Expression) Param() *int {
+	return e.param
+}
+
 // AppliesTo determines if all of hte fields mentioned in this expression
 // are contained in the specified schema.
 func (e Expression) AppliesTo(sch *record.Schema) bool {
-	if e.val != nil {
+	if e.val != nil || e.param != nil {
 		return true
 	} else {
 		return sch.HasField(*e.fldname)
@@ -53,6 +86,8 @@ func (e Expression) AppliesTo(sch *record.Schema) bool {
 func (e Expression) String() string {
 	if e.val != nil {
 		return e.val.String()
+	} else if e.param != nil {
+		return fmt.Sprintf("$%d", *e.param)
 	} else {
 		return *e.fldname
 	}
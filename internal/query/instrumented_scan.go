@@ -0,0 +1,80 @@
+package query
+
+import (
+	"simpledb/internal/record"
+	"time"
+)
+
+// ScanStats records what a scan actually did while it was running.
+// The block and time measurements are inclusive: they also count the work
+// done by the scan's subscans.
+type ScanStats struct {
+	// Rows is the number of records output by the scan.
+	Rows int
+	// Blocks is the number of blocks pinned while the scan was running.
+	Blocks int
+	// Time is the time spent opening and iterating through the scan.
+	Time time.Duration
+}
+
+// InstrumentedScan wraps a scan and records statistics about it.
+// All methods delegate their work to the underlying scan.
+type InstrumentedScan struct {
+	s      record.Scan
+	stats  *ScanStats
+	blocks func() int
+}
+
+// Check that InstrumentedScan implements Scan
+var _ record.Scan = (*InstrumentedScan)(nil)
+
+// NewInstrumentedScan creates a new InstrumentedScan that records its
+// statistics in stats. The blocks function returns a running count of
+// the blocks pinned so far, and is used to attribute block accesses to
+// the scan.
+func NewInstrumentedScan(s record.Scan, stats *ScanStats, blocks func() int) *InstrumentedScan {
+	return &InstrumentedScan{s: s, stats: stats, blocks: blocks}
+}
+
+// Scan methods
+
+func (is *InstrumentedScan) BeforeFirst() error {
+	defer is.measure(time.Now(), is.blocks())
+	return is.s.BeforeFirst()
+}
+
+func (is *InstrumentedScan) Next() bool {
+	defer is.measure(time.Now(), is.blocks())
+	ok := is.s.Next()
+	if ok {
+		is.stats.Rows++
+	}
+	return ok
+}
+
+func (is *InstrumentedScan) GetInt(fldname string) (int32, error) {
+	return is.s.GetInt(fldname)
+}
+
+func (is *InstrumentedScan) GetString(fldname string) (string, error) {
+	return is.s.GetString(fldname)
+}
+
+func (is *InstrumentedScan) GetVal(fldname string) (record.Constant, error) {
+	return is.s.GetVal(fldname)
+}
+
+func (is *InstrumentedScan) HasField(fldname string) bool {
+	return is.s.HasField(fldname)
+}
+
+func (is *InstrumentedScan) Close() {
+	is.s.Close()
+}
+
+// measure adds the time and blocks used since the specified starting point
+// to the scan's statistics.
+func (is *InstrumentedScan) measure(start time.Time, startBlocks int) {
+	is.stats.Time += time.Since(start)
+	is.stats.Blocks += is.blocks() - startBlocks
+}
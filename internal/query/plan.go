@@ -22,4 +22,21 @@ type Plan interface {
 
 	// Schema returns the schema of the query's output table.
 	Schema() *record.Schema
+
+	// Describe returns a one-line description of this plan node,
+	// not including its subplans.
+	Describe() string
+
+	// Children returns the subplans that this plan reads from.
+	Children() []Plan
+}
+
+// ValueEstimator is implemented by plans that can estimate how many of
+// their output records have a particular value for a field, such as plans
+// over tables whose statistics include a histogram.
+type ValueEstimator interface {
+	// RecordsWithValue returns an estimate of the number of output records
+	// whose value for the specified field equals val.
+	// It returns false if no estimate is available.
+	RecordsWithValue(fldname string, val record.Constant) (int, bool)
 }
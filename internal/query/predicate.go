@@ -110,6 +110,20 @@ func (p *Predicate) EquatesWithField(fldname string) *string {
 	return nil
 }
 
+// Bind returns a copy of the predicate with any parameters replaced by the
+// corresponding constants in args.
+func (p *Predicate) Bind(args []record.Constant) (*Predicate, error) {
+	terms := make([]*Term, len(p.terms))
+	for i, term := range p.terms {
+		bound, err := term.Bind(args)
+		if err != nil {
+			return nil, err
+		}
+		terms[i] = bound
+	}
+	return NewPredicate(terms), nil
+}
+
 // String returns a string representation of this predicate.
 func (p *Predicate) String() string {
 	terms := make([]string, len(p.terms))
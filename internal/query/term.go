@@ -42,11 +42,11 @@ func (t *Term) ReductionFactor(p Plan) (int, error) {
 	}
 	if t.lhs.FieldName() != nil {
 		lhsname := *t.lhs.FieldName()
-		return p.DistinctValues(lhsname), nil
+		return valueReductionFactor(p, lhsname, t.rhs), nil
 	}
 	if t.rhs.FieldName() != nil {
 		rhsname := *t.rhs.FieldName()
-		return p.DistinctValues(rhsname), nil
+		return valueReductionFactor(p, rhsname, t.lhs), nil
 	}
 	// otherwise, the term equates two constants
 	if t.lhs.Constant().Equal(*t.rhs.Constant()) {
@@ -55,6 +55,23 @@ func (t *Term) ReductionFactor(p Plan) (int, error) {
 	return 0, fmt.Errorf("cannot calculate reduction factor for term %s", t.String())
 }
 
+// valueReductionFactor calculates the reduction factor of a term that
+// equates a field with a constant. If the plan can estimate the number of
+// records with that value, the estimate is used; otherwise every distinct
+// value is assumed to be equally likely.
+func valueReductionFactor(p Plan, fldname string, val Expression) int {
+	if ve, ok := p.(ValueEstimator); ok && val.Constant() != nil {
+		if n, ok := ve.RecordsWithValue(fldname, *val.Constant()); ok {
+			if n == 0 {
+				// No records match, so reduce the output to nothing.
+				return p.RecordsOutput() + 1
+			}
+			return max(1, p.RecordsOutput()/n)
+		}
+	}
+	return p.DistinctValues(fldname)
+}
+
 // EquatesWithConstant determines if this term is of the form "F=c"
 // where F is the specified field and c is some constant.
 // If so, the method returns that constant, otherwise it returns nil.
@@ -81,6 +98,20 @@ func (t *Term) EquatesWithField(fldname string) *string {
 	return nil
 }
 
+// Bind returns a copy of the term with any parameters replaced by the
+// corresponding constants in args.
+func (t *Term) Bind(args []record.Constant) (*Term, error) {
+	lhs, err := t.lhs.Bind(args)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := t.rhs.Bind(args)
+	if err != nil {
+		return nil, err
+	}
+	return NewTerm(lhs, rhs), nil
+}
+
 // AppliesTo returns true if both of the term's expressions apply to the
 // specified schema.
 func (t *Term) AppliesTo(sch *record.Schema) bool {
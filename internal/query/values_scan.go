@@ -0,0 +1,71 @@
+package query
+
+import (
+	"fmt"
+	"simpledb/internal/record"
+)
+
+// ValuesScan is a scan over a list of records held in memory.
+// Each record maps the field names of the scan's schema to their values.
+type ValuesScan struct {
+	schema  *record.Schema
+	rows    []map[string]record.Constant
+	current int
+}
+
+// Check that ValuesScan implements Scan
+var _ record.Scan = (*ValuesScan)(nil)
+
+// NewValuesScan creates a new ValuesScan over the specified records.
+func NewValuesScan(schema *record.Schema, rows []map[string]record.Constant) *ValuesScan {
+	return &ValuesScan{schema: schema, rows: rows, current: -1}
+}
+
+// Scan methods
+
+func (vs *ValuesScan) BeforeFirst() error {
+	vs.current = -1
+	return nil
+}
+
+func (vs *ValuesScan) Next() bool {
+	if vs.current+1 >= len(vs.rows) {
+		vs.current = len(vs.rows)
+		return false
+	}
+	vs.current++
+	return true
+}
+
+func (vs *ValuesScan) GetInt(fldname string) (int32, error) {
+	val, err := vs.GetVal(fldname)
+	if err != nil {
+		return 0, err
+	}
+	return val.AsInt(), nil
+}
+
+func (vs *ValuesScan) GetString(fldname string) (string, error) {
+	val, err := vs.GetVal(fldname)
+	if err != nil {
+		return "", err
+	}
+	return val.AsString(), nil
+}
+
+func (vs *ValuesScan) GetVal(fldname string) (record.Constant, error) {
+	if vs.current < 0 || vs.current >= len(vs.rows) {
+		return record.Constant{}, fmt.Errorf("scan is not positioned on a record")
+	}
+	val, ok := vs.rows[vs.current][fldname]
+	if !ok {
+		return record.Constant{}, fmt.Errorf("field %s not found", fldname)
+	}
+	return val, nil
+}
+
+func (vs *ValuesScan) HasField(fldname string) bool {
+	return vs.schema.HasField(fldname)
+}
+
+func (vs *ValuesScan) Close() {}
@@ -37,6 +37,14 @@ func (c Constant) AsString() string {
 	return *c.sval
 }
 
+// Type returns the type of the constant's value.
+func (c Constant) Type() Type {
+	if c.ival != nil {
+		return Integer
+	}
+	return String
+}
+
 // Equal implements value comparison for Constant
 func (c Constant) Equal(other Constant) bool {
 	if c.ival != nil && other.ival != nil {
@@ -46,6 +46,10 @@ type UpdateScan interface {
 	MoveToRid(rid RID) error
 }
 
+// ReadAheadBlocks is the number of blocks that a sequential table scan
+// asks to be prefetched beyond the block it is reading.
+const ReadAheadBlocks = 4
+
 // Check that TableScan implements Scan, UpdateScan
 var _ Scan = (*TableScan)(nil)
 var _ UpdateScan = (*TableScan)(nil)
@@ -56,14 +60,20 @@ type TableScan struct {
 	tx          *tx.Transaction
 	layout      *Layout
 	rp          *RecordPage
+	tblname     string
 	filename    string
 	currentslot int
+	// The last block that has been prefetched for a sequential scan.
+	readAheadTo int
+	// The last record counted as modified, so that a record whose fields
+	// are set one after another is counted once.
+	modified RID
 }
 
 // NewTableScan creates a new TableScan object.
 func NewTableScan(tx *tx.Transaction, tblname string, layout *Layout) (*TableScan, error) {
 	filename := fmt.Sprintf("%s.tbl", tblname)
-	ts := &TableScan{tx, layout, nil, filename, 0}
+	ts := &TableScan{tx, layout, nil, tblname, filename, 0, 0, NewRID(-1, -1)}
 	size, err := tx.Size(filename)
 	if err != nil {
 		return nil, err
@@ -82,6 +92,7 @@ func NewTableScan(tx *tx.Transaction, tblname string, layout *Layout) (*TableSca
 
 // BeforeFirst moves the table scan before the first record.
 func (ts *TableScan) BeforeFirst() error {
+	ts.readAheadTo = 0
 	return ts.moveToBlock(0)
 }
 
@@ -95,7 +106,9 @@ func (ts *TableScan) Next() bool {
 		if ok := ts.atLastBlock(); ok {
 			return false
 		}
-		if err := ts.moveToBlock(ts.rp.Blk.Blknum + 1); err != nil {
+		next := ts.rp.Blk.Blknum + 1
+		ts.readAhead(next)
+		if err := ts.moveToBlock(next); err != nil {
 			return false
 		}
 		ts.currentslot = ts.rp.NextAfter(ts.currentslot)
@@ -148,12 +161,20 @@ func (ts *TableScan) Close() {
 
 // SetInt sets the value of the specified field in the current record.
 func (ts *TableScan) SetInt(fldname string, val int32) error {
-	return ts.rp.SetInt(ts.currentslot, fldname, val)
+	if err := ts.rp.SetInt(ts.currentslot, fldname, val); err != nil {
+		return err
+	}
+	ts.recordUpdate()
+	return nil
 }
 
 // SetString sets the value of the specified field in the current record.
 func (ts *TableScan) SetString(fldname string, val string) error {
-	return ts.rp.SetString(ts.currentslot, fldname, val)
+	if err := ts.rp.SetString(ts.currentslot, fldname, val); err != nil {
+		return err
+	}
+	ts.recordUpdate()
+	return nil
 }
 
 // SetVal sets the value of the specified field in the current record.
@@ -187,12 +208,19 @@ func (ts *TableScan) Insert() error {
 		}
 		ts.currentslot = ts.rp.InsertAfter(ts.currentslot)
 	}
+	// Setting the fields of the new record doesn't count as an update.
+	ts.modified = ts.GetRid()
+	ts.tx.RecordTableChange(ts.tblname, tx.TableDelta{Rows: 1, Modifications: 1})
 	return nil
 }
 
 // Delete deletes the current record.
 func (ts *TableScan) Delete() error {
-	return ts.rp.Delete(ts.currentslot)
+	if err := ts.rp.Delete(ts.currentslot); err != nil {
+		return err
+	}
+	ts.tx.RecordTableChange(ts.tblname, tx.TableDelta{Rows: -1, Modifications: 1})
+	return nil
 }
 
 // GetRid returns the RID of the current record.
@@ -213,6 +241,15 @@ func (ts *TableScan) MoveToRid(rid RID) error {
 	return nil
 }
 
+// recordUpdate counts the current record as modified, unless it already
+// has been.
+func (ts *TableScan) recordUpdate() {
+	if rid := ts.GetRid(); !rid.Equal(ts.modified) {
+		ts.modified = rid
+		ts.tx.RecordTableChange(ts.tblname, tx.TableDelta{Modifications: 1})
+	}
+}
+
 // moveToBlock moves the table scan internally to the specified block.
 func (ts *TableScan) moveToBlock(blknum int) error {
 	ts.Close()
@@ -226,6 +263,29 @@ func (ts *TableScan) moveToBlock(blknum int) error {
 	return nil
 }
 
+// readAhead prefetches the blocks that follow the specified block, once a
+// sequential scan has used up half of the blocks prefetched so far.
+func (ts *TableScan) readAhead(blknum int) {
+	if blknum+ReadAheadBlocks/2 <= ts.readAheadTo {
+		return
+	}
+	numblks, err := ts.tx.Size(ts.filename)
+	if err != nil {
+		return
+	}
+	start := max(blknum, ts.readAheadTo) + 1
+	end := min(blknum+ReadAheadBlocks, numblks-1)
+	if start > end {
+		return
+	}
+	blks := make([]file.BlockID, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		blks = append(blks, file.NewBlockID(ts.filename, i))
+	}
+	ts.tx.Prefetch(blks)
+	ts.readAheadTo = end
+}
+
 // moveToNewBlock moves the table scan to a new, empty block.
 func (ts *TableScan) moveToNewBlock() error {
 	ts.Close()
@@ -233,6 +293,7 @@ func (ts *TableScan) moveToNewBlock() error {
 	if err != nil {
 		return err
 	}
+	ts.tx.RecordTableChange(ts.tblname, tx.TableDelta{Blocks: 1})
 	rp, err := NewRecordPage(ts.tx, blk, ts.layout)
 	if err != nil {
 		return err
@@ -95,3 +95,86 @@ func TestTableScan(t *testing.T) {
 		t.Fatalf("Failed to commit transaction: %v", err)
 	}
 }
+
+func TestTableScanReadAhead(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("tablereadaheadtest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("tablereadaheadtest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	sch := record.NewSchema()
+	sch.AddIntField("A")
+	sch.AddStringField("B", 80)
+	layout := record.NewLayout(sch)
+
+	tx, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	ts, err := record.NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatalf("Failed to create table scan: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := ts.Insert(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.SetInt("A", int32(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+	numblks, err := db.FileMgr.Length("T.tbl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numblks < 2*len(db.BufferMgr.Frames()) {
+		t.Fatalf("Expected the table to be larger than the buffer pool, but it has %d blocks", numblks)
+	}
+
+	tx, err = db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	ts, err = record.NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatalf("Failed to create table scan: %v", err)
+	}
+	before := db.BufferMgr.Stats()
+	count := 0
+	for ts.Next() {
+		a, err := ts.GetInt("A")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(a) != count {
+			t.Fatalf("Expected record %d, but got %d", count, a)
+		}
+		count++
+	}
+	ts.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+	if count != 100 {
+		t.Fatalf("Expected 100 records, but got %d", count)
+	}
+
+	db.BufferMgr.WaitPrefetches()
+	after := db.BufferMgr.Stats()
+	if after.Prefetches == before.Prefetches {
+		t.Fatal("Expected the scan to prefetch blocks")
+	}
+	// Prefetching never holds more than the budget of unused blocks.
+	if unused := after.Prefetches - after.PrefetchHits; unused > 2 {
+		t.Errorf("Expected at most 2 unused prefetched blocks, but got %d", unused)
+	}
+}
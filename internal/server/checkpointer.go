@@ -0,0 +1,64 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// checkpointer periodically writes a checkpoint, so that recovery doesn't
+// have to read the whole log. The checkpoint is nonquiescent if the
+// database is busy.
+type checkpointer struct {
+	db       *SimpleDB
+	interval time.Duration
+	done     chan struct{}
+	wg       sync.WaitGroup
+	// The error of the most recent checkpoint that failed.
+	err error
+	mu  sync.Mutex
+}
+
+func newCheckpointer(db *SimpleDB, interval time.Duration) *checkpointer {
+	return &checkpointer{db: db, interval: interval}
+}
+
+func (c *checkpointer) start() {
+	c.done = make(chan struct{})
+	c.wg.Add(1)
+	go c.run()
+}
+
+// stop stops the checkpointer, waiting for any checkpoint in progress to be
+// written. It returns the error of the most recent checkpoint that failed,
+// if any.
+func (c *checkpointer) stop() error {
+	close(c.done)
+	c.wg.Wait()
+	return c.lastErr()
+}
+
+// lastErr returns the error of the most recent checkpoint that failed, or
+// nil if none has.
+func (c *checkpointer) lastErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *checkpointer) run() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.db.Checkpoint(); err != nil {
+				c.mu.Lock()
+				c.err = err
+				c.mu.Unlock()
+			}
+		}
+	}
+}
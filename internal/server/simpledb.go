@@ -1,14 +1,19 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"simpledb/internal/buffer"
 	"simpledb/internal/file"
 	"simpledb/internal/log"
 	"simpledb/internal/metadata"
 	"simpledb/internal/plan"
+	"simpledb/internal/record"
 	"simpledb/internal/tx"
 	"simpledb/internal/tx/concurrency"
+	"simpledb/internal/tx/recovery"
+	"sync"
+	"time"
 )
 
 const (
@@ -16,6 +21,38 @@ const (
 	DefaultBufferSize = 8
 )
 
+// BufferPoolSizeSetting is the name of the setting that resizes the buffer
+// pool, as in "SET buffers = 64".
+const BufferPoolSizeSetting = "buffers"
+
+// GroupCommitWindowSetting is the name of the setting that sets the log
+// manager's group commit window, in microseconds, as in
+// "SET group_commit_window = 500". A window of 0 turns group commit off.
+const GroupCommitWindowSetting = "group_commit_window"
+
+// DeadlockPolicySetting is the name of the setting that chooses how the
+// lock table deals with deadlocks, as in "SET deadlock_policy = 'wait-die'".
+// The policy is "detect", "wait-die" or "wound-wait".
+const DeadlockPolicySetting = "deadlock_policy"
+
+// BackgroundConfig configures the goroutines that SimpleDB runs in the
+// background. A zero interval disables the corresponding goroutine.
+type BackgroundConfig struct {
+	// How often the background writer flushes unpinned dirty buffers.
+	WriterInterval time.Duration
+	// The maximum number of buffers flushed each time, or 0 for no limit.
+	WriterBatch int
+	// How often the checkpointer writes a checkpoint.
+	CheckpointInterval time.Duration
+}
+
+// DefaultBackgroundConfig is the configuration used by NewSimpleDB.
+var DefaultBackgroundConfig = BackgroundConfig{
+	WriterInterval:     200 * time.Millisecond,
+	WriterBatch:        DefaultBufferSize / 2,
+	CheckpointInterval: 30 * time.Second,
+}
+
 type SimpleDB struct {
 	FileMgr     *file.FileMgr
 	LogMgr      *log.LogMgr
@@ -23,16 +60,35 @@ type SimpleDB struct {
 	LockTable   *concurrency.LockTable
 	MetadataMgr *metadata.MetadataMgr
 	Planner     *plan.Planner
+
+	// The LSN of the start record of each active transaction, by
+	// transaction number. txMu is held while writing a checkpoint, so that
+	// no transaction can start meanwhile.
+	activeTxs map[int]int
+	txMu      sync.Mutex
+
+	writer       *buffer.BackgroundWriter
+	checkpointer *checkpointer
 }
 
 // NewSimpleDBWithConfig creates a new SimpleDB instance with the given directory name and blocksize.
 // The instance should be closed by calling Close() when it's no longer needed.
 func NewSimpleDBWithConfig(dirname string, blocksize int, numbufs int) (*SimpleDB, error) {
-	fm, err := file.NewFileMgr(dirname, blocksize)
+	return NewSimpleDBWithOptions(dirname, blocksize, numbufs, file.DefaultOptions)
+}
+
+// NewSimpleDBWithOptions is like NewSimpleDBWithConfig, but creates a new
+// database with the specified options, such as whether pages carry
+// checksums. An existing database keeps the options it was created with.
+func NewSimpleDBWithOptions(dirname string, blocksize int, numbufs int, opts file.Options) (*SimpleDB, error) {
+	fm, err := file.NewFileMgrWithOptions(dirname, blocksize, opts)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := recovery.UpgradeLog(fm, log.DefaultLogFile); err != nil {
+		return nil, err
+	}
 	lm, err := log.NewLogMgr(fm, log.DefaultLogFile)
 	if err != nil {
 		return nil, err
@@ -45,7 +101,7 @@ func NewSimpleDBWithConfig(dirname string, blocksize int, numbufs int) (*SimpleD
 
 	lt := concurrency.NewLockTable()
 
-	db := &SimpleDB{fm, lm, bm, lt, nil, nil}
+	db := &SimpleDB{FileMgr: fm, LogMgr: lm, BufferMgr: bm, LockTable: lt, activeTxs: make(map[int]int)}
 	return db, nil
 }
 
@@ -75,18 +131,154 @@ func NewSimpleDB(dirname string) (*SimpleDB, error) {
 		return nil, err
 	}
 	db.MetadataMgr = mdm
+	mdm.EnableAutoAnalyze(db.NewTx)
+	mdm.RegisterSystemTable(metadata.BufferFramesTable, metadata.NewBufferFramesTable(db.BufferMgr))
+	mdm.RegisterSystemTable(metadata.BufferStatsTable, metadata.NewBufferStatsTable(db.BufferMgr))
+	mdm.RegisterSystemTable(metadata.LogStatsTable, metadata.NewLogStatsTable(db.LogMgr))
 	db.Planner = plan.NewPlanner(plan.NewBasicQueryPlanner(mdm), plan.NewBasicUpdatePlanner(mdm))
+	db.Planner.RegisterSetting(BufferPoolSizeSetting, db.setBufferPoolSize)
+	db.Planner.RegisterSetting(GroupCommitWindowSetting, db.setGroupCommitWindow)
+	db.Planner.RegisterSetting(DeadlockPolicySetting, db.setDeadlockPolicy)
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
+	db.StartBackground(DefaultBackgroundConfig)
 	return db, nil
 }
 
+// StartBackground starts the background writer and the checkpointer, as
+// configured. They are stopped by Close.
+func (db *SimpleDB) StartBackground(cfg BackgroundConfig) {
+	if cfg.WriterInterval > 0 && db.writer == nil {
+		db.writer = buffer.NewBackgroundWriter(db.BufferMgr, cfg.WriterInterval, cfg.WriterBatch)
+		db.writer.Start()
+	}
+	if cfg.CheckpointInterval > 0 && db.checkpointer == nil {
+		db.checkpointer = newCheckpointer(db, cfg.CheckpointInterval)
+		db.checkpointer.start()
+	}
+}
+
+// Checkpoint writes a checkpoint, so that recovery doesn't have to read the
+// whole log. If there are no active transactions, the checkpoint is
+// quiescent; otherwise, it is nonquiescent and lists them. New
+// transactions wait until the checkpoint has been written.
+func (db *SimpleDB) Checkpoint() error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+	if len(db.activeTxs) == 0 {
+		return recovery.QuiescentCheckpoint(db.LogMgr, db.BufferMgr)
+	}
+	return recovery.NonquiescentCheckpoint(db.LogMgr, db.BufferMgr, db.activeTxs)
+}
+
+// setBufferPoolSize resizes the buffer pool to the specified number of
+// buffers.
+func (db *SimpleDB) setBufferPoolSize(val record.Constant) error {
+	if val.Type() != record.Integer {
+		return fmt.Errorf("%s must be an integer", BufferPoolSizeSetting)
+	}
+	return db.BufferMgr.Resize(int(val.AsInt()))
+}
+
+// setGroupCommitWindow sets the group commit window to the specified number
+// of microseconds.
+func (db *SimpleDB) setGroupCommitWindow(val record.Constant) error {
+	if val.Type() != record.Integer || val.AsInt() < 0 {
+		return fmt.Errorf("%s must be a non-negative integer", GroupCommitWindowSetting)
+	}
+	db.LogMgr.SetGroupCommitWindow(time.Duration(val.AsInt()) * time.Microsecond)
+	return nil
+}
+
+// setDeadlockPolicy sets how the lock table deals with deadlocks.
+func (db *SimpleDB) setDeadlockPolicy(val record.Constant) error {
+	if val.Type() != record.String {
+		return fmt.Errorf("%s must be a string", DeadlockPolicySetting)
+	}
+	p, err := concurrency.ParseDeadlockPolicy(val.AsString())
+	if err != nil {
+		return err
+	}
+	db.LockTable.SetDeadlockPolicy(p)
+	return nil
+}
+
+// NewTx creates a new transaction. If the metadata has been initialized,
+// the transaction's changes to each table are reported to the metadata
+// manager when it ends, to keep the table statistics up to date.
 func (db *SimpleDB) NewTx() (*tx.Transaction, error) {
-	return tx.NewTransaction(db.FileMgr, db.LogMgr, db.BufferMgr, db.LockTable)
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+	t, err := tx.NewTransaction(db.FileMgr, db.LogMgr, db.BufferMgr, db.LockTable)
+	if err != nil {
+		return nil, err
+	}
+	db.register(t)
+	t.OnRestart(func(start func() error) error {
+		db.txMu.Lock()
+		defer db.txMu.Unlock()
+		if err := start(); err != nil {
+			return err
+		}
+		db.register(t)
+		return nil
+	})
+	if db.MetadataMgr != nil {
+		t.SetTableChangeListener(db.MetadataMgr)
+	}
+	return t, nil
+}
+
+// register adds a transaction that has just started to the active
+// transactions, until it ends. The caller must hold txMu.
+func (db *SimpleDB) register(t *tx.Transaction) {
+	txnum := t.TxNum()
+	db.activeTxs[txnum] = t.StartLSN()
+	t.OnEnd(func(bool) {
+		db.txMu.Lock()
+		delete(db.activeTxs, txnum)
+		db.txMu.Unlock()
+	})
 }
 
-// Close closes the SimpleDB instance
-func (db *SimpleDB) Close() {
+// BackgroundErr returns the errors of the most recent flush of the
+// background writer and the most recent checkpoint of the checkpointer
+// that failed, or nil if none has.
+func (db *SimpleDB) BackgroundErr() error {
+	var errs []error
+	if db.writer != nil {
+		errs = append(errs, db.writer.Err())
+	}
+	if db.checkpointer != nil {
+		errs = append(errs, db.checkpointer.lastErr())
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops the background goroutines and closes the SimpleDB instance.
+// It first writes a checkpoint, so that recovery has less of the log to
+// read when the database is opened again. It returns the errors of the
+// background writer and the checkpointer, as reported by BackgroundErr,
+// along with any error writing the final checkpoint. The instance is
+// closed either way.
+func (db *SimpleDB) Close() error {
+	var errs []error
+	if db.MetadataMgr != nil {
+		db.MetadataMgr.StopAutoAnalyze()
+	}
+	if db.writer != nil {
+		errs = append(errs, db.writer.Stop())
+		db.writer = nil
+	}
+	if db.checkpointer != nil {
+		errs = append(errs, db.checkpointer.stop())
+		db.checkpointer = nil
+	}
+	db.BufferMgr.WaitPrefetches()
+	if err := db.Checkpoint(); err != nil {
+		errs = append(errs, fmt.Errorf("checkpoint on close: %w", err))
+	}
 	db.FileMgr.Close()
+	return errors.Join(errs...)
 }
@@ -0,0 +1,136 @@
+package server_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"simpledb/internal/file"
+	"simpledb/internal/log"
+	"simpledb/internal/server"
+	"simpledb/internal/tx/recovery"
+	"testing"
+)
+
+// copyDatabase copies a database in testdata to a new directory.
+func copyDatabase(t *testing.T, name, dir string) {
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	if err := os.CopyFS(dir, os.DirFS(filepath.Join("testdata", name))); err != nil {
+		t.Fatalf("Failed to copy database: %v", err)
+	}
+}
+
+func TestOpenLegacyLog(t *testing.T) {
+	copyDatabase(t, "baseline", "legacylogtest")
+
+	db, err := server.NewSimpleDBWithConfig("legacylogtest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if v := db.FileMgr.LogVersion(); v != log.FormatVersion {
+		t.Errorf("Expected log version %d, got %d", log.FormatVersion, v)
+	}
+	tx, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx.Recover(); err != nil {
+		t.Fatalf("Failed to recover: %v", err)
+	}
+	db.Close()
+
+	// The upgraded log is opened like any other.
+	db, err = server.NewSimpleDBWithConfig("legacylogtest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	db.Close()
+}
+
+func TestOpenUnfinishedLegacyLog(t *testing.T) {
+	copyDatabase(t, "baseline-unfinished", "legacyunfinishedtest")
+	before, err := os.ReadFile(filepath.Join("legacyunfinishedtest", log.DefaultLogFile))
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+
+	_, err = server.NewSimpleDBWithConfig("legacyunfinishedtest", 400, 8)
+	if !errors.Is(err, recovery.ErrUnfinishedLegacyLog) {
+		t.Fatalf("Expected ErrUnfinishedLegacyLog, got %v", err)
+	}
+
+	after, err := os.ReadFile(filepath.Join("legacyunfinishedtest", log.DefaultLogFile))
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if string(before) != string(after) {
+		t.Error("The log was changed")
+	}
+	fm, err := file.NewFileMgr("legacyunfinishedtest", 400)
+	if err != nil {
+		t.Fatalf("Failed to open files: %v", err)
+	}
+	defer fm.Close()
+	if v := fm.LogVersion(); v != 0 {
+		t.Errorf("Expected no log version, got %d", v)
+	}
+}
+
+func TestOpenBaselineDatabase(t *testing.T) {
+	copyDatabase(t, "baseline", "baselinetest")
+
+	db, err := server.NewSimpleDB("baselinetest")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	tx, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	p, err := db.Planner.CreateQueryPlan("select A, B from T", tx)
+	if err != nil {
+		t.Fatalf("Failed to create query plan: %v", err)
+	}
+	s, err := p.Open()
+	if err != nil {
+		t.Fatalf("Failed to open scan: %v", err)
+	}
+	count := 0
+	for s.Next() {
+		count++
+	}
+	s.Close()
+	if count != 20 {
+		t.Errorf("Expected 20 records, got %d", count)
+	}
+
+	// The statistics catalog tables were added, so the table can be
+	// analyzed.
+	if _, err := db.Planner.ExecuteUpdate("analyze T", tx); err != nil {
+		t.Fatalf("Failed to analyze table: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+}
+
+func TestCloseReturnsErrors(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("closeerrortest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("closeerrortest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if err := db.BackgroundErr(); err != nil {
+		t.Fatalf("Expected no background errors, got %v", err)
+	}
+	// The final checkpoint can't be written once the files are closed.
+	db.FileMgr.Close()
+	if err := db.Close(); err == nil {
+		t.Fatal("Expected Close to return the checkpoint error, but got nil")
+	}
+}
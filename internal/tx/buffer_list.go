@@ -65,6 +65,28 @@ func (bl *BufferList) UnpinAll() {
 	bl.pins = make([]file.BlockID, 0)
 }
 
+// Pins returns the blocks that are currently pinned, once for each pin.
+func (bl *BufferList) Pins() []file.BlockID {
+	return slices.Clone(bl.pins)
+}
+
+// RestorePins unpins the buffers that were pinned after the specified pins
+// were returned by Pins. The blocks that have been unpinned since then stay
+// unpinned.
+func (bl *BufferList) RestorePins(saved []file.BlockID) {
+	kept := make(map[file.BlockID]int)
+	for _, blk := range saved {
+		kept[blk]++
+	}
+	for _, blk := range slices.Clone(bl.pins) {
+		if kept[blk] > 0 {
+			kept[blk]--
+			continue
+		}
+		bl.Unpin(blk)
+	}
+}
+
 // removePin removes a block from the pins slice.
 func (bl *BufferList) removePin(blk file.BlockID) {
 	for i, pin := range bl.pins {
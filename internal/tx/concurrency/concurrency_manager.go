@@ -9,18 +9,24 @@ type LockType int
 const (
 	SharedLock    LockType = 1
 	ExclusiveLock LockType = 2
+	UpdateLock    LockType = 3
 )
 
 type ConcurrencyMgr struct {
 	lt    *LockTable
+	txnum int
 	locks map[file.BlockID]LockType
+	// The transaction's abort signal, or nil if it hasn't been requested
+	// since the transaction last released its locks.
+	abortSignal <-chan struct{}
 }
 
-// NewConcurrencyMgr creates a new ConcurrencyMgr that references the given
-// database-wide lock table.
-func NewConcurrencyMgr(lt *LockTable) *ConcurrencyMgr {
+// NewConcurrencyMgr creates a new ConcurrencyMgr for the specified
+// transaction that references the given database-wide lock table.
+func NewConcurrencyMgr(lt *LockTable, txnum int) *ConcurrencyMgr {
 	return &ConcurrencyMgr{
 		lt:    lt,
+		txnum: txnum,
 		locks: make(map[file.BlockID]LockType),
 	}
 }
@@ -30,7 +36,7 @@ func NewConcurrencyMgr(lt *LockTable) *ConcurrencyMgr {
 // if the transaction currently has no locks on the block.
 func (cm *ConcurrencyMgr) SLock(blk file.BlockID) error {
 	if _, ok := cm.locks[blk]; !ok {
-		if err := cm.lt.SLock(blk); err != nil {
+		if err := cm.lt.SLock(cm.txnum, blk); err != nil {
 			return err
 		}
 		cm.locks[blk] = SharedLock
@@ -38,19 +44,34 @@ func (cm *ConcurrencyMgr) SLock(blk file.BlockID) error {
 	return nil
 }
 
+// ULock obtains an update lock on the specified block.
+// The method will ask the lock table for a ULock if the transaction
+// currently has no lock on the block, or only an SLock.
+func (cm *ConcurrencyMgr) ULock(blk file.BlockID) error {
+	lock, ok := cm.locks[blk]
+	if !ok || lock == SharedLock {
+		if err := cm.lt.ULock(cm.txnum, blk); err != nil {
+			return err
+		}
+		cm.locks[blk] = UpdateLock
+	}
+	return nil
+}
+
 // XLock obtains an exclusive lock on the specified block.
 // If the transaction doesn't already have an XLock on the block,
-// it will first get an SLock on the block (if necessary) and then
-// upgrade it to an XLock.
-// This could result in deadlock if two transactions both try
-// upgrading from an SLock to an XLock for the same block.
+// it will first get an SLock on the block (if it has neither an SLock nor a
+// ULock) and then upgrade it to an XLock.
+// If two transactions both try upgrading from an SLock to an XLock for
+// the same block, the lock table detects the deadlock and aborts the
+// younger one with a DeadlockError.
 func (cm *ConcurrencyMgr) XLock(blk file.BlockID) error {
 	lock := cm.locks[blk]
 	if lock != ExclusiveLock {
 		if err := cm.SLock(blk); err != nil {
 			return err
 		}
-		if err := cm.lt.XLock(blk); err != nil {
+		if err := cm.lt.XLock(cm.txnum, blk); err != nil {
 			return err
 		}
 		cm.locks[blk] = ExclusiveLock
@@ -58,10 +79,26 @@ func (cm *ConcurrencyMgr) XLock(blk file.BlockID) error {
 	return nil
 }
 
+// Aborted returns a DeadlockError if the transaction has been aborted to
+// deal with a deadlock, and so must be rolled back.
+func (cm *ConcurrencyMgr) Aborted() error {
+	if cm.abortSignal == nil {
+		cm.abortSignal = cm.lt.AbortSignal(cm.txnum)
+	}
+	select {
+	case <-cm.abortSignal:
+		return NewDeadlockError(cm.txnum)
+	default:
+		return nil
+	}
+}
+
 // Release releases all locks by asking the lock table to unlock each one.
 func (cm *ConcurrencyMgr) Release() {
 	for blk := range cm.locks {
-		cm.lt.Unlock(blk)
+		cm.lt.Unlock(cm.txnum, blk)
 	}
 	cm.locks = make(map[file.BlockID]LockType)
+	cm.lt.Done(cm.txnum)
+	cm.abortSignal = nil
 }
@@ -1,5 +1,7 @@
 package concurrency
 
+import "fmt"
+
 // LockAbortError represents an error indicating that a lock could not
 // be obtained and the transaction needs to abort.
 type LockAbortError struct{}
@@ -13,3 +15,21 @@ func (e *LockAbortError) Error() string {
 func NewLockAbortError() *LockAbortError {
 	return &LockAbortError{}
 }
+
+// DeadlockError represents an error indicating that the transaction was
+// chosen to abort, to break a deadlock or to prevent one. The transaction
+// must be rolled back, so that the others can get its locks.
+type DeadlockError struct {
+	TxNum int
+}
+
+// Error implements the error interface for DeadlockError.
+func (e *DeadlockError) Error() string {
+	return fmt.Sprintf("transaction %d aborted to avoid deadlock", e.TxNum)
+}
+
+// NewDeadlockError creates a new DeadlockError for the specified
+// transaction.
+func NewDeadlockError(txnum int) *DeadlockError {
+	return &DeadlockError{TxNum: txnum}
+}
@@ -1,13 +1,51 @@
 package concurrency
 
 import (
+	"fmt"
 	"simpledb/internal/file"
+	"slices"
 	"sync"
 	"time"
 )
 
 const maxWaitTime = 10 * time.Second
 
+// DeadlockPolicy determines how a lock table deals with deadlocks.
+type DeadlockPolicy int
+
+const (
+	// DetectDeadlocks lets transactions wait for each other, and aborts the
+	// youngest transaction in a cycle of the waits-for graph.
+	DetectDeadlocks DeadlockPolicy = iota
+	// WaitDie lets a transaction wait only for younger transactions. A
+	// transaction that conflicts with an older one is aborted ("dies").
+	WaitDie
+	// WoundWait lets a transaction wait only for older transactions. When
+	// a transaction conflicts with younger ones, they are aborted
+	// ("wounded"), and it waits for them to release their locks.
+	WoundWait
+)
+
+var deadlockPolicyNames = []string{"detect", "wait-die", "wound-wait"}
+
+// String returns the name of the policy.
+func (p DeadlockPolicy) String() string {
+	if p < 0 || int(p) >= len(deadlockPolicyNames) {
+		return fmt.Sprintf("DeadlockPolicy(%d)", int(p))
+	}
+	return deadlockPolicyNames[p]
+}
+
+// ParseDeadlockPolicy returns the policy with the specified name:
+// "detect", "wait-die" or "wound-wait".
+func ParseDeadlockPolicy(name string) (DeadlockPolicy, error) {
+	i := slices.Index(deadlockPolicyNames, name)
+	if i < 0 {
+		return 0, fmt.Errorf("unknown deadlock policy: %s", name)
+	}
+	return DeadlockPolicy(i), nil
+}
+
 // LockTable provides methods to lock and unlock blocks.
 // If a transaction requests a lock that causes a conflict with an
 // existing lock, then that transaction is placed on a wait list.
@@ -16,95 +54,175 @@ const maxWaitTime = 10 * time.Second
 // are removed from the wait list and rescheduled.
 // If one of those transactions discovers that the lock it is waiting for
 // is still locked, it will place itself back on the wait list.
+//
+// Besides shared (S) and exclusive (X) locks, a transaction can hold an
+// update (U) lock on a block, which it intends to upgrade to an X lock.
+// A U lock is compatible with S locks, but not with other U or X locks, so
+// two transactions can't both read a block under U locks and then deadlock
+// upgrading them.
+//
+// The lock table also records which transactions hold each lock, and which
+// lock each waiting transaction is waiting for. These form a waits-for
+// graph, in which a waiting transaction waits for the holders of the locks
+// that conflict with the one it requested. By default, whenever a transaction has to wait, the lock table
+// looks for a cycle in the graph that passes through it; if it finds one,
+// the youngest transaction in the cycle is aborted with a DeadlockError.
+// The lock table can instead prevent deadlocks with the wait-die or
+// wound-wait policy, which use transaction numbers as timestamps: the
+// lower the number, the older the transaction.
+//
+// The lock table can't undo an aborted transaction's changes, so it can't
+// take its locks away: the transaction must be rolled back, which releases
+// them. Until then it gets a DeadlockError for each lock it requests, and
+// can't commit. If it is waiting for a lock, it is woken up to get the
+// error right away, so a deadlock is broken as soon as the victim rolls
+// back, without waiting for any lock request to time out. A transaction
+// that isn't waiting, such as one wounded under wound-wait, is signalled
+// through the channel returned by AbortSignal.
 type LockTable struct {
 	mu      sync.Mutex
+	policy  DeadlockPolicy
 	locks   map[file.BlockID]int
 	waiters map[file.BlockID]chan struct{}
+	// The transactions that hold a lock on each block.
+	holders map[file.BlockID]map[int]bool
+	// The transaction that holds the U lock on each block, if any.
+	updaters map[file.BlockID]int
+	// The lock that each waiting transaction is waiting for.
+	waiting map[int]lockRequest
+	// The transactions that have been aborted to break or prevent a
+	// deadlock.
+	aborted map[int]bool
+	// The channels that are closed when each transaction is aborted.
+	abortSignals map[int]chan struct{}
 }
 
 // NewLockTable creates a new LockTable.
 func NewLockTable() *LockTable {
 	return &LockTable{
-		locks:   make(map[file.BlockID]int),
-		waiters: make(map[file.BlockID]chan struct{}),
+		locks:        make(map[file.BlockID]int),
+		waiters:      make(map[file.BlockID]chan struct{}),
+		holders:      make(map[file.BlockID]map[int]bool),
+		updaters:     make(map[file.BlockID]int),
+		waiting:      make(map[int]lockRequest),
+		aborted:      make(map[int]bool),
+		abortSignals: make(map[int]chan struct{}),
 	}
 }
 
-// SLock grants a shared lock on the specified block.
+// lockRequest is a request for a lock of some type on a block.
+type lockRequest struct {
+	blk      file.BlockID
+	lockType LockType
+}
+
+// SetDeadlockPolicy sets how the lock table deals with deadlocks. It
+// applies to the transactions that have to wait from then on.
+func (lt *LockTable) SetDeadlockPolicy(p DeadlockPolicy) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	lt.policy = p
+}
+
+// DeadlockPolicy returns how the lock table deals with deadlocks.
+func (lt *LockTable) DeadlockPolicy() DeadlockPolicy {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	return lt.policy
+}
+
+// SLock grants the specified transaction a shared lock on the specified
+// block.
 // If an exclusive lock on it already exists when the method is called,
 // then the calling goroutine will be placed on a wait list until
 // the lock is released. If the goroutine remains on the wait list for
 // more than a certain amount of time (currently 10 seconds),
-// then the method will return an error.
-func (lt *LockTable) SLock(blk file.BlockID) error {
+// then the method will return an error. If the transaction is aborted to
+// deal with a deadlock, it returns a DeadlockError instead.
+func (lt *LockTable) SLock(txnum int, blk file.BlockID) error {
 	lt.mu.Lock()
+	defer lt.mu.Unlock()
 
+	if lt.aborted[txnum] {
+		return NewDeadlockError(txnum)
+	}
 	start := time.Now()
 	for lt.locks[blk] == -1 {
-		ch := lt.getOrCreateWaitChannel(blk)
-		lt.mu.Unlock()
-
-		if time.Since(start) > maxWaitTime {
-			return NewLockAbortError()
-		}
-
-		// Wait on the channel with a timeout
-		select {
-		case <-ch:
-			// Continue when the lock is released
-		case <-time.After(maxWaitTime):
-			return NewLockAbortError()
+		if err := lt.wait(txnum, lockRequest{blk, SharedLock}, start); err != nil {
+			return err
 		}
-
-		lt.mu.Lock()
 	}
 	val := lt.locks[blk] // will not be negative
 	lt.locks[blk] = val + 1
-	lt.mu.Unlock()
+	lt.addHolder(txnum, blk)
 	return nil
 }
 
-// XLock grants an exclusive lock on the specified block.
+// XLock grants the specified transaction an exclusive lock on the
+// specified block.
 // If a lock of any kind already exists on it when the method is called,
 // then the calling goroutine will be placed on a wait list until
 // the lock is released. If the goroutine remains on the wait list for
 // more than a certain amount of time (currently 10 seconds), then the method
-// will return an error.
-func (lt *LockTable) XLock(blk file.BlockID) error {
+// will return an error. If the transaction is aborted to deal with a
+// deadlock, it returns a DeadlockError instead.
+func (lt *LockTable) XLock(txnum int, blk file.BlockID) error {
 	lt.mu.Lock()
+	defer lt.mu.Unlock()
 
+	if lt.aborted[txnum] {
+		return NewDeadlockError(txnum)
+	}
 	start := time.Now()
 
 	// We assume the concurrency manager will obtain an SLock
 	// before obtaining an XLock, so we only need to wait if
 	// another transaction is also holding an SLock.
 	for lt.locks[blk] > 1 {
-		ch := lt.getOrCreateWaitChannel(blk)
-		lt.mu.Unlock()
-
-		if time.Since(start) > maxWaitTime {
-			return NewLockAbortError()
+		if err := lt.wait(txnum, lockRequest{blk, ExclusiveLock}, start); err != nil {
+			return err
 		}
+	}
+	lt.locks[blk] = -1
+	lt.addHolder(txnum, blk)
+	return nil
+}
 
-		// Wait on the channel with a timeout
-		select {
-		case <-ch:
-			// Continue when the lock is released
-		case <-time.After(maxWaitTime):
-			return NewLockAbortError()
-		}
+// ULock grants the specified transaction an update lock on the specified
+// block. If the transaction already holds a shared lock on it, the lock is
+// converted.
+// If an exclusive lock or another update lock on it exists when the method
+// is called, then the calling goroutine will be placed on a wait list until
+// the lock is released. If the goroutine remains on the wait list for more
+// than a certain amount of time (currently 10 seconds), then the method will
+// return an error. If the transaction is aborted to deal with a deadlock, it
+// returns a DeadlockError instead.
+func (lt *LockTable) ULock(txnum int, blk file.BlockID) error {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
 
-		lt.mu.Lock()
+	if lt.aborted[txnum] {
+		return NewDeadlockError(txnum)
 	}
-	lt.locks[blk] = -1
-	lt.mu.Unlock()
+	start := time.Now()
+	for lt.locks[blk] == -1 || lt.hasOtherUpdater(txnum, blk) {
+		if err := lt.wait(txnum, lockRequest{blk, UpdateLock}, start); err != nil {
+			return err
+		}
+	}
+	// A U lock counts as one of the shared locks on the block.
+	if !lt.holders[blk][txnum] {
+		lt.locks[blk]++
+	}
+	lt.updaters[blk] = txnum
+	lt.addHolder(txnum, blk)
 	return nil
 }
 
-// Unlock releases a lock on the specified block.
+// Unlock releases the specified transaction's lock on the specified block.
 // If this lock is the last lock on the block, then all goroutines waiting
 // for that lock are removed from the wait list and rescheduled.
-func (lt *LockTable) Unlock(blk file.BlockID) {
+func (lt *LockTable) Unlock(txnum int, blk file.BlockID) {
 	lt.mu.Lock()
 	defer lt.mu.Unlock()
 
@@ -114,7 +232,238 @@ func (lt *LockTable) Unlock(blk file.BlockID) {
 	} else {
 		delete(lt.locks, blk)
 	}
+	delete(lt.holders[blk], txnum)
+	if len(lt.holders[blk]) == 0 {
+		delete(lt.holders, blk)
+	}
+	if lt.hasUpdater(txnum, blk) {
+		delete(lt.updaters, blk)
+	}
 	// Signal all goroutines waiting for this block (and remove the channel)
+	lt.wakeWaiters(blk)
+}
+
+// AbortSignal returns a channel that is closed when the specified
+// transaction is aborted to deal with a deadlock, so that it can find out
+// without requesting a lock. The channel is valid until Done is called.
+func (lt *LockTable) AbortSignal(txnum int) <-chan struct{} {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	return lt.abortSignal(txnum)
+}
+
+// abortSignal returns the abort signal of the specified transaction,
+// creating it if necessary.
+func (lt *LockTable) abortSignal(txnum int) chan struct{} {
+	ch, ok := lt.abortSignals[txnum]
+	if !ok {
+		ch = make(chan struct{})
+		if lt.aborted[txnum] {
+			close(ch)
+		}
+		lt.abortSignals[txnum] = ch
+	}
+	return ch
+}
+
+// Done forgets that the specified transaction was aborted, and its abort
+// signal, once it has released its locks.
+func (lt *LockTable) Done(txnum int) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	delete(lt.aborted, txnum)
+	delete(lt.abortSignals, txnum)
+}
+
+// wait makes the specified transaction wait until the locks on the block it
+// requested a lock on change, having started to wait for it at the specified time.
+// It is called with the mutex held, and returns with it held.
+// It returns a DeadlockError if the transaction is aborted, before or
+// while it waits, and a LockAbortError if it has waited too long.
+func (lt *LockTable) wait(txnum int, req lockRequest, start time.Time) error {
+	lt.waiting[txnum] = req
+	defer delete(lt.waiting, txnum)
+	var err error
+	switch lt.policy {
+	case WaitDie:
+		err = lt.waitOrDie(txnum)
+	case WoundWait:
+		lt.woundYounger(txnum)
+	default:
+		err = lt.detectDeadlock(txnum)
+	}
+	if err != nil {
+		return err
+	}
+
+	remaining := maxWaitTime - time.Since(start)
+	if remaining <= 0 {
+		return NewLockAbortError()
+	}
+	ch := lt.getOrCreateWaitChannel(req.blk)
+	lt.mu.Unlock()
+
+	// Wait on the channel with a timeout
+	timedOut := false
+	select {
+	case <-ch:
+		// Continue when the lock is released
+	case <-time.After(remaining):
+		timedOut = true
+	}
+
+	lt.mu.Lock()
+	if lt.aborted[txnum] {
+		return NewDeadlockError(txnum)
+	}
+	if timedOut {
+		return NewLockAbortError()
+	}
+	return nil
+}
+
+// detectDeadlock looks for a cycle in the waits-for graph that passes
+// through the specified waiting transaction, and aborts the youngest
+// transaction in the cycle. If that is the specified transaction, it
+// returns a DeadlockError; otherwise the victim is woken up, and returns
+// the error itself.
+func (lt *LockTable) detectDeadlock(txnum int) error {
+	cycle := lt.findCycle(txnum)
+	if cycle == nil {
+		return nil
+	}
+	victim := slices.Max(cycle)
+	lt.abort(victim)
+	if victim == txnum {
+		return NewDeadlockError(txnum)
+	}
+	return nil
+}
+
+// waitOrDie returns a DeadlockError if the specified transaction is waiting
+// for an older one, under the wait-die policy.
+func (lt *LockTable) waitOrDie(txnum int) error {
+	for _, holder := range lt.waitsFor(txnum) {
+		if holder < txnum {
+			lt.abort(txnum)
+			return NewDeadlockError(txnum)
+		}
+	}
+	return nil
+}
+
+// woundYounger aborts the younger transactions that the specified
+// transaction is waiting for, under the wound-wait policy.
+func (lt *LockTable) woundYounger(txnum int) {
+	for _, holder := range lt.waitsFor(txnum) {
+		if holder > txnum {
+			lt.abort(holder)
+		}
+	}
+}
+
+// abort marks the specified transaction as aborted, closes its abort
+// signal, and wakes it up if it is waiting for a lock.
+func (lt *LockTable) abort(txnum int) {
+	if lt.aborted[txnum] {
+		return
+	}
+	lt.aborted[txnum] = true
+	if ch, ok := lt.abortSignals[txnum]; ok {
+		close(ch)
+	}
+	if req, ok := lt.waiting[txnum]; ok {
+		lt.wakeWaiters(req.blk)
+	}
+}
+
+// findCycle returns the transactions in a cycle of the waits-for graph
+// that starts and ends at the specified transaction, or nil if there isn't
+// one.
+func (lt *LockTable) findCycle(txnum int) []int {
+	visited := make(map[int]bool)
+	var path []int
+	var visit func(t int) bool
+	visit = func(t int) bool {
+		path = append(path, t)
+		for _, next := range lt.waitsFor(t) {
+			if next == txnum {
+				return true
+			}
+			if !visited[next] {
+				visited[next] = true
+				if visit(next) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if visit(txnum) {
+		return path
+	}
+	return nil
+}
+
+// waitsFor returns the transactions that the specified transaction is
+// waiting for: the other holders of locks on the block that conflict with
+// the lock it requested. An S lock conflicts with an X lock, a U lock with
+// a U or X lock, and an X lock with every lock.
+// A transaction that has already been aborted doesn't wait for any others,
+// since it is about to release its locks.
+func (lt *LockTable) waitsFor(txnum int) []int {
+	req, ok := lt.waiting[txnum]
+	if !ok || lt.aborted[txnum] {
+		return nil
+	}
+	exclusive := lt.locks[req.blk] == -1
+	var txnums []int
+	for holder := range lt.holders[req.blk] {
+		if holder == txnum {
+			continue
+		}
+		switch req.lockType {
+		case SharedLock:
+			if !exclusive {
+				continue
+			}
+		case UpdateLock:
+			if !exclusive && !lt.hasUpdater(holder, req.blk) {
+				continue
+			}
+		}
+		txnums = append(txnums, holder)
+	}
+	return txnums
+}
+
+// hasUpdater returns true if the specified transaction holds the U lock
+// on the specified block.
+func (lt *LockTable) hasUpdater(txnum int, blk file.BlockID) bool {
+	updater, ok := lt.updaters[blk]
+	return ok && updater == txnum
+}
+
+// hasOtherUpdater returns true if a transaction other than the specified
+// one holds the U lock on the specified block.
+func (lt *LockTable) hasOtherUpdater(txnum int, blk file.BlockID) bool {
+	updater, ok := lt.updaters[blk]
+	return ok && updater != txnum
+}
+
+// addHolder records that the specified transaction holds a lock on the
+// specified block.
+func (lt *LockTable) addHolder(txnum int, blk file.BlockID) {
+	if lt.holders[blk] == nil {
+		lt.holders[blk] = make(map[int]bool)
+	}
+	lt.holders[blk][txnum] = true
+}
+
+// wakeWaiters wakes up the goroutines waiting for the lock on the specified
+// block, so that they check it again.
+func (lt *LockTable) wakeWaiters(blk file.BlockID) {
 	if ch, exists := lt.waiters[blk]; exists {
 		close(ch)
 		delete(lt.waiters, blk)
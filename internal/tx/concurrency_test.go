@@ -1,10 +1,14 @@
 package tx_test
 
 import (
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"os"
 	"simpledb/internal/file"
 	"simpledb/internal/server"
+	"simpledb/internal/tx"
+	"simpledb/internal/tx/concurrency"
 	"sync"
 	"testing"
 	"time"
@@ -37,6 +41,432 @@ func TestConcurrency(t *testing.T) {
 	}
 }
 
+func TestDeadlockDetection(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("deadlocktest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("deadlocktest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// Each case has two transactions upgrade their shared locks on the same
+	// block. The younger one is aborted, whichever of them closes the cycle.
+	for _, olderWaitsFirst := range []bool{true, false} {
+		blk := file.NewBlockID("testfile", 1)
+		older, err := db.NewTx()
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		younger, err := db.NewTx()
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		for _, tx := range []*tx.Transaction{older, younger} {
+			if err := tx.Pin(blk); err != nil {
+				t.Fatalf("Failed to pin block: %v", err)
+			}
+			if _, err := tx.GetInt(blk, 0); err != nil {
+				t.Fatalf("Failed to get int: %v", err)
+			}
+		}
+		first, second := younger, older
+		if olderWaitsFirst {
+			first, second = older, younger
+		}
+
+		start := time.Now()
+		errs := make(chan error, 2)
+		upgrade := func(tx *tx.Transaction) {
+			err := tx.SetInt(blk, 0, 1, false)
+			if err != nil {
+				tx.Rollback()
+			} else {
+				err = tx.Commit()
+			}
+			errs <- err
+		}
+		go upgrade(first)
+		time.Sleep(200 * time.Millisecond)
+		go upgrade(second)
+
+		var dlerr *concurrency.DeadlockError
+		for range 2 {
+			if err := <-errs; err != nil && !errors.As(err, &dlerr) {
+				t.Fatalf("Expected a deadlock error, got %v", err)
+			}
+		}
+		if dlerr == nil {
+			t.Fatal("Expected one transaction to be aborted")
+		}
+		if dlerr.TxNum != younger.TxNum() {
+			t.Fatalf("Expected transaction %d to be aborted, got %d", younger.TxNum(), dlerr.TxNum)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("Expected the deadlock to be broken right away, took %v", elapsed)
+		}
+	}
+}
+
+func TestDeadlockVictimMustRollBack(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("deadlockvictimtest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("deadlockvictimtest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	blk := file.NewBlockID("testfile", 1)
+	older, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	younger, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	for _, tx := range []*tx.Transaction{older, younger} {
+		if err := tx.Pin(blk); err != nil {
+			t.Fatalf("Failed to pin block: %v", err)
+		}
+		if _, err := tx.GetInt(blk, 0); err != nil {
+			t.Fatalf("Failed to get int: %v", err)
+		}
+	}
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		if err := older.SetInt(blk, 0, 1, true); err != nil {
+			done <- err
+			return
+		}
+		done <- older.Commit()
+	}()
+	time.Sleep(200 * time.Millisecond)
+	var dlerr *concurrency.DeadlockError
+	if err := younger.SetInt(blk, 0, 2, true); !errors.As(err, &dlerr) {
+		t.Fatalf("Expected a deadlock error, got %v", err)
+	}
+
+	// The victim can't commit, so it keeps its locks until it rolls back.
+	if err := younger.Commit(); !errors.As(err, &dlerr) {
+		t.Fatalf("Expected a deadlock error on commit, got %v", err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the older transaction to still be waiting, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := younger.Rollback(); err != nil {
+		t.Fatalf("Failed to rollback: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Older transaction failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected the deadlock to be broken right away, took %v", elapsed)
+	}
+}
+
+func TestDeadlockPolicies(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("deadlockpolicytest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("deadlockpolicytest", 400, 20)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// Without a way to deal with deadlocks, this workload stalls until the
+	// lock requests time out: each transaction reads two blocks, in an order
+	// that depends on its worker, and then upgrades its locks to write them.
+	const workers, iterations = 4, 3
+	blks := []file.BlockID{file.NewBlockID("testfile", 1), file.NewBlockID("testfile", 2)}
+	for i, policy := range []concurrency.DeadlockPolicy{concurrency.DetectDeadlocks, concurrency.WaitDie, concurrency.WoundWait} {
+		db.LockTable.SetDeadlockPolicy(policy)
+		start := time.Now()
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for w := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				order := blks
+				if w%2 == 1 {
+					order = []file.BlockID{blks[1], blks[0]}
+				}
+				for range iterations {
+					if err := incrementUntilCommitted(db, order); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatalf("case %v: transaction failed: %v", policy, err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("case %v: expected the workload to finish without stalling, took %v", policy, elapsed)
+		}
+
+		tx, err := db.NewTx()
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		for _, blk := range blks {
+			if err := tx.Pin(blk); err != nil {
+				t.Fatalf("Failed to pin block: %v", err)
+			}
+			expected := int32((i + 1) * workers * iterations)
+			if n, err := tx.GetInt(blk, 0); err != nil || n != expected {
+				t.Fatalf("case %v: expected counter %d, got %d, %v", policy, expected, n, err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Failed to commit: %v", err)
+		}
+	}
+}
+
+// incrementUntilCommitted increments the counters at the start of the
+// specified blocks in a transaction, reading them in order before writing
+// them. If the transaction is aborted to avoid a deadlock, it is rolled back
+// and tried again after a short random delay, so that the transactions it
+// conflicted with can finish first.
+func incrementUntilCommitted(db *server.SimpleDB, blks []file.BlockID) error {
+	for {
+		tx, err := db.NewTx()
+		if err != nil {
+			return err
+		}
+		err = increment(tx, blks)
+		var dlerr *concurrency.DeadlockError
+		if errors.As(err, &dlerr) {
+			if err := tx.Rollback(); err != nil {
+				return err
+			}
+			time.Sleep(time.Duration(rand.IntN(20)) * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+}
+
+func increment(tx *tx.Transaction, blks []file.BlockID) error {
+	vals := make([]int32, len(blks))
+	for i, blk := range blks {
+		if err := tx.Pin(blk); err != nil {
+			return err
+		}
+		val, err := tx.GetInt(blk, 0)
+		if err != nil {
+			return err
+		}
+		vals[i] = val
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i, blk := range blks {
+		if err := tx.SetInt(blk, 0, vals[i]+1, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestWoundWait(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("woundwaittest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("woundwaittest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	db.LockTable.SetDeadlockPolicy(concurrency.WoundWait)
+
+	blk1 := file.NewBlockID("testfile", 1)
+	blk2 := file.NewBlockID("testfile", 2)
+	older, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	younger, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := younger.Pin(blk1); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if err := younger.SetInt(blk1, 0, 1, true); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+
+	// The older transaction wounds the younger one, which is idle rather
+	// than waiting for a lock, and waits for it to release its lock.
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		if err := older.Pin(blk1); err != nil {
+			done <- err
+			return
+		}
+		if _, err := older.GetInt(blk1, 0); err != nil {
+			done <- err
+			return
+		}
+		done <- older.Commit()
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// The younger transaction finds out as soon as it uses a block, even
+	// one it already holds a lock on.
+	var dlerr *concurrency.DeadlockError
+	if _, err := younger.GetInt(blk1, 0); !errors.As(err, &dlerr) {
+		t.Fatalf("Expected a deadlock error, got %v", err)
+	}
+	if err := younger.SetInt(blk1, 0, 2, true); !errors.As(err, &dlerr) {
+		t.Fatalf("Expected a deadlock error, got %v", err)
+	}
+	if err := younger.Pin(blk2); !errors.As(err, &dlerr) {
+		t.Fatalf("Expected a deadlock error, got %v", err)
+	}
+	if err := younger.Rollback(); err != nil {
+		t.Fatalf("Failed to rollback: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Older transaction failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected the older transaction to get its lock right away, took %v", elapsed)
+	}
+
+	// Under wait-die, a younger transaction that conflicts with an older
+	// one is aborted instead of waiting.
+	db.LockTable.SetDeadlockPolicy(concurrency.WaitDie)
+	older, err = db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	younger, err = db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := older.Pin(blk1); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if err := older.SetInt(blk1, 0, 2, true); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+	if err := younger.Pin(blk1); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if _, err := younger.GetInt(blk1, 0); !errors.As(err, &dlerr) {
+		t.Fatalf("Expected a deadlock error, got %v", err)
+	}
+	if err := younger.Rollback(); err != nil {
+		t.Fatalf("Failed to rollback: %v", err)
+	}
+	if err := older.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+}
+
+func TestUpdateLocks(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("updatelocktest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("updatelocktest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	blk := file.NewBlockID("testfile", 1)
+	txs := make([]*tx.Transaction, 3)
+	for i := range txs {
+		txs[i], err = db.NewTx()
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		if err := txs[i].Pin(blk); err != nil {
+			t.Fatalf("Failed to pin block: %v", err)
+		}
+	}
+	updater, reader, other := txs[0], txs[1], txs[2]
+
+	// A ULock is compatible with an SLock.
+	updater.SetReadForUpdate(true)
+	if _, err := updater.GetInt(blk, 0); err != nil {
+		t.Fatalf("Failed to get int: %v", err)
+	}
+	if _, err := reader.GetInt(blk, 0); err != nil {
+		t.Fatalf("Failed to get int: %v", err)
+	}
+
+	// A second ULock waits, rather than deadlocking later when both
+	// transactions upgrade their locks.
+	other.SetReadForUpdate(true)
+	done := make(chan error, 1)
+	go func() {
+		val, err := other.GetInt(blk, 0)
+		if err == nil {
+			err = other.SetInt(blk, 0, val+1, true)
+		}
+		if err == nil {
+			err = other.Commit()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the second ULock to wait, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := reader.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if err := updater.SetInt(blk, 0, 1, true); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+	if err := updater.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Second updater failed: %v", err)
+	}
+
+	check, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := check.Pin(blk); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if val, err := check.GetInt(blk, 0); err != nil || val != 2 {
+		t.Fatalf("Expected int value 2, got %d, %v", val, err)
+	}
+	if err := check.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+}
+
 func runTx(db *server.SimpleDB, wg *sync.WaitGroup, errChan chan error, txFunc func(db *server.SimpleDB) error) {
 	defer wg.Done()
 	errChan <- txFunc(db)
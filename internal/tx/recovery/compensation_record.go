@@ -0,0 +1,136 @@
+package recovery
+
+import (
+	"fmt"
+	"simpledb/internal/file"
+	"simpledb/internal/log"
+)
+
+// Check that CompensationRecord implements UpdateRecord
+var _ UpdateRecord = (*CompensationRecord)(nil)
+
+// CompensationRecord represents a compensation log record (CLR), which is
+// written when an update is undone. It holds the value that the undo
+// restored, so that the undo can be redone, and the LSN of the update it
+// undid. The updates of the transaction from that LSN onwards have all been
+// undone, so a rollback that is interrupted can resume before it.
+// A CLR is never undone itself.
+type CompensationRecord struct {
+	txnum  int
+	offset int
+	// SetInt or SetString, depending on the type of the value.
+	valtype  LogRecordType
+	intval   int
+	strval   string
+	blk      file.BlockID
+	undoNext int
+}
+
+// NewCompensationRecord creates a new CompensationRecord by reading values
+// from the log.
+func NewCompensationRecord(p *file.Page) *CompensationRecord {
+	tpos := 4
+	txnum := int(p.GetInt(tpos))
+	fpos := tpos + 4
+	filename := p.GetString(fpos)
+	bpos := fpos + file.MaxLength(len(filename))
+	blknum := int(p.GetInt(bpos))
+	blk := file.NewBlockID(filename, blknum)
+	opos := bpos + 4
+	offset := int(p.GetInt(opos))
+	upos := opos + 4
+	undoNext := int(p.GetLong(upos))
+	typos := upos + 8
+	valtype := LogRecordType(p.GetInt(typos))
+	vpos := typos + 4
+	r := &CompensationRecord{txnum: txnum, offset: offset, valtype: valtype, blk: blk, undoNext: undoNext}
+	if valtype == SetInt {
+		r.intval = int(p.GetInt(vpos))
+	} else {
+		r.strval = p.GetString(vpos)
+	}
+	return r
+}
+
+// Op returns the log record's type.
+func (r *CompensationRecord) Op() LogRecordType {
+	return Compensation
+}
+
+// TxNumber returns the transaction number.
+func (r *CompensationRecord) TxNumber() int {
+	return r.txnum
+}
+
+// Block returns the block that the undo modified.
+func (r *CompensationRecord) Block() file.BlockID {
+	return r.blk
+}
+
+// UndoNext returns the LSN of the update that was undone. Only the
+// transaction's updates before it remain to be undone.
+func (r *CompensationRecord) UndoNext() int {
+	return r.undoNext
+}
+
+// Undo does nothing, because an undo is never undone.
+func (r *CompensationRecord) Undo(tx Transaction) error {
+	return nil
+}
+
+// Redo writes the value restored by the undo to the page.
+func (r *CompensationRecord) Redo(p *file.Page) {
+	if r.valtype == SetInt {
+		p.SetInt(r.offset, int32(r.intval))
+	} else {
+		p.SetString(r.offset, r.strval)
+	}
+}
+
+// String returns a string representation of the CompensationRecord.
+func (r *CompensationRecord) String() string {
+	var val any = r.intval
+	if r.valtype != SetInt {
+		val = r.strval
+	}
+	return fmt.Sprintf("<CLR %d %s %d %v %s>", r.txnum, r.blk.String(), r.offset, val, log.FormatLSN(r.undoNext))
+}
+
+// WriteCompensationToLog writes a compensation record to the log.
+// This log record contains the CLR operator, followed by the transaction
+// id, the filename, number, and offset of the modified block, the undo-next
+// LSN, and the type and value that the undo restored, which must be an int
+// or a string.
+// It returns the LSN of the last log value.
+func WriteCompensationToLog(lm *log.LogMgr, txnum int, blk file.BlockID, offset int, val any, undoNext int) (int, error) {
+	tpos := 4
+	fpos := tpos + 4
+	bpos := fpos + file.MaxLength(len(blk.Filename))
+	opos := bpos + 4
+	upos := opos + 4
+	typos := upos + 8
+	vpos := typos + 4
+	reclen := vpos + 4
+	if s, ok := val.(string); ok {
+		reclen = vpos + file.MaxLength(len(s))
+	}
+	rec := make([]byte, reclen)
+	p := file.NewPageFromBytes(rec)
+	p.SetInt(0, int32(Compensation))
+	p.SetInt(4, int32(txnum))
+	p.SetString(fpos, blk.Filename)
+	p.SetInt(bpos, int32(blk.Blknum))
+	p.SetInt(opos, int32(offset))
+	p.SetLong(upos, int64(undoNext))
+	switch v := val.(type) {
+	case int32:
+		p.SetInt(typos, int32(SetInt))
+		p.SetInt(vpos, v)
+	case string:
+		p.SetInt(typos, int32(SetString))
+		p.SetString(vpos, v)
+	default:
+		return 0, fmt.Errorf("cannot log a compensation for a %T", val)
+	}
+	return lm.Append(rec)
+}
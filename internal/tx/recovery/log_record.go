@@ -15,6 +15,8 @@ const (
 	Rollback
 	SetInt
 	SetString
+	NQCheckpoint
+	Compensation
 )
 
 // Transaction is an interface used to decouple the recovery package from the tx package.
@@ -35,6 +37,18 @@ type LogRecord interface {
 	Undo(tx Transaction) error
 }
 
+// UpdateRecord is implemented by the log records that describe a change to
+// a block. Both the old and new values are logged, so the change can be
+// undone or redone.
+type UpdateRecord interface {
+	LogRecord
+	// Block returns the block that was changed.
+	Block() file.BlockID
+	// Redo makes the change again to the specified page, which holds the
+	// contents of the block.
+	Redo(p *file.Page)
+}
+
 // CreateLogRecord interprets the bytes returned by the log iterator and creates the appropriate LogRecord
 func CreateLogRecord(bytes []byte) (LogRecord, error) {
 	p := file.NewPageFromBytes(bytes)
@@ -51,6 +65,10 @@ func CreateLogRecord(bytes []byte) (LogRecord, error) {
 		return NewSetIntRecord(p), nil
 	case SetString:
 		return NewSetStringRecord(p), nil
+	case NQCheckpoint:
+		return NewNQCheckpointRecord(p), nil
+	case Compensation:
+		return NewCompensationRecord(p), nil
 	default:
 		return nil, fmt.Errorf("unknown log record type: %d", p.GetInt(0))
 	}
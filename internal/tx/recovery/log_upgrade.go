@@ -0,0 +1,52 @@
+package recovery
+
+import (
+	"errors"
+	"simpledb/internal/file"
+	"simpledb/internal/log"
+)
+
+// ErrUnfinishedLegacyLog is returned by UpgradeLog when a log with an older
+// format holds changes of unfinished transactions.
+var ErrUnfinishedLegacyLog = errors.New("the log was written by an older version of SimpleDB and has unfinished transactions; recover the database with that version first")
+
+// UpgradeLog prepares the specified log for the log manager if it has an
+// older format. Such a log can only be read, so it is replaced by a new
+// one, which is only safe if every transaction in it has finished: the
+// database files already hold all of their changes. Otherwise the log is
+// left as it is and ErrUnfinishedLegacyLog is returned.
+// It must be called before the log manager is created.
+func UpgradeLog(fm *file.FileMgr, logfile string) error {
+	v, err := log.Version(fm, logfile)
+	if err != nil || v == log.FormatVersion {
+		return err
+	}
+
+	// The start, commit and rollback records of the older format are the
+	// same as the current ones, and a checkpoint record means that every
+	// earlier transaction has finished.
+	finished := make(map[int]bool)
+	for bytes, err := range log.LegacyRecords(fm, logfile) {
+		if err != nil {
+			return err
+		}
+		p := file.NewPageFromBytes(bytes)
+		op := LogRecordType(p.GetInt(0))
+		if op == Checkpoint {
+			break
+		}
+		switch op {
+		case Commit, Rollback:
+			finished[int(p.GetInt(4))] = true
+		case Start:
+			if !finished[int(p.GetInt(4))] {
+				return ErrUnfinishedLegacyLog
+			}
+		}
+	}
+
+	if err := fm.Remove(logfile); err != nil {
+		return err
+	}
+	return fm.SetLogVersion(log.FormatVersion)
+}
@@ -0,0 +1,84 @@
+package recovery
+
+import (
+	"fmt"
+	"simpledb/internal/file"
+	"simpledb/internal/log"
+)
+
+// Check that NQCheckpointRecord implements LogRecord
+var _ LogRecord = (*NQCheckpointRecord)(nil)
+
+// NQCheckpointRecord represents a nonquiescent checkpoint (NQCKPT) log
+// record. It lists the transactions that were active when it was written,
+// and the LSN of the oldest log record that recovery may need: the start of
+// one of those transactions, or a change that hadn't been flushed yet.
+type NQCheckpointRecord struct {
+	oldestLSN int
+	txnums    []int
+}
+
+// NewNQCheckpointRecord creates a new NQCheckpointRecord by reading values
+// from the log.
+func NewNQCheckpointRecord(p *file.Page) *NQCheckpointRecord {
+	lpos := 4
+	oldestLSN := int(p.GetLong(lpos))
+	npos := lpos + 8
+	n := int(p.GetInt(npos))
+	txnums := make([]int, n)
+	for i := range txnums {
+		txnums[i] = int(p.GetInt(npos + 4 + 4*i))
+	}
+	return &NQCheckpointRecord{oldestLSN, txnums}
+}
+
+// Op returns the log record's type.
+func (r *NQCheckpointRecord) Op() LogRecordType {
+	return NQCheckpoint
+}
+
+// TxNumber returns a dummy transaction number, since checkpoint records
+// have no associated transaction.
+func (r *NQCheckpointRecord) TxNumber() int {
+	return -1 // dummy value
+}
+
+// Undo does nothing, because a checkpoint record contains no undo information.
+func (r *NQCheckpointRecord) Undo(tx Transaction) error {
+	return nil
+}
+
+// OldestLSN returns the LSN of the oldest log record that recovery may
+// need, or 0 if it needs nothing before the checkpoint.
+func (r *NQCheckpointRecord) OldestLSN() int {
+	return r.oldestLSN
+}
+
+// Transactions returns the numbers of the transactions that were active
+// when the checkpoint was written.
+func (r *NQCheckpointRecord) Transactions() []int {
+	return r.txnums
+}
+
+// String returns a string representation of the NQCheckpointRecord.
+func (r *NQCheckpointRecord) String() string {
+	return fmt.Sprintf("<NQCKPT %s %v>", log.FormatLSN(r.oldestLSN), r.txnums)
+}
+
+// WriteNQCheckpointToLog writes a nonquiescent checkpoint record to the log.
+// This log record contains the NQCKPT operator, followed by the oldest LSN
+// that recovery may need, the number of active transactions, and their ids.
+// It returns the LSN of the last log value.
+func WriteNQCheckpointToLog(lm *log.LogMgr, oldestLSN int, txnums []int) (int, error) {
+	lpos := 4
+	npos := lpos + 8
+	rec := make([]byte, npos+4+4*len(txnums))
+	p := file.NewPageFromBytes(rec)
+	p.SetInt(0, int32(NQCheckpoint))
+	p.SetLong(lpos, int64(oldestLSN))
+	p.SetInt(npos, int32(len(txnums)))
+	for i, txnum := range txnums {
+		p.SetInt(npos+4+4*i, int32(txnum))
+	}
+	return lm.Append(rec)
+}
@@ -1,6 +1,8 @@
 package recovery
 
 import (
+	"iter"
+	"math"
 	"simpledb/internal/buffer"
 	"simpledb/internal/log"
 	"slices"
@@ -13,31 +15,45 @@ type RecoveryMgr struct {
 	bm    *buffer.BufferMgr
 	tx    Transaction
 	txnum int
+	// The LSN of the transaction's start record.
+	startLSN int
+	// The LSN of the latest record written by this recovery manager.
+	lastLSN int
+	// The update being undone, while one is. The changes made meanwhile
+	// are logged as compensation records.
+	undoing *undoing
+}
+
+// undoing identifies an update that is being undone.
+type undoing struct {
+	txnum int
+	lsn   int
 }
 
 // NewRecoveryMgr creaters a recovery manager for the specified transaction.
 func NewRecoveryMgr(tx Transaction, txnum int, lm *log.LogMgr, bm *buffer.BufferMgr) (*RecoveryMgr, error) {
-	rm := &RecoveryMgr{lm, bm, tx, txnum}
-	_, err := WriteStartToLog(lm, txnum)
+	lsn, err := WriteStartToLog(lm, txnum)
 	if err != nil {
 		return nil, err
 	}
-	return rm, nil
+	return &RecoveryMgr{lm: lm, bm: bm, tx: tx, txnum: txnum, startLSN: lsn, lastLSN: lsn}, nil
+}
+
+// StartLSN returns the LSN of the transaction's start record.
+func (rm *RecoveryMgr) StartLSN() int {
+	return rm.startLSN
 }
 
 // Commit writes a commit record to the log, and flushes it to disk.
+// The transaction's modified buffers don't need to be flushed, since
+// recovery can redo their changes from the log.
 func (rm *RecoveryMgr) Commit() error {
-	err := rm.bm.FlushAll(rm.txnum)
-	if err != nil {
-		return err
-	}
-
 	lsn, err := WriteCommitToLog(rm.lm, rm.txnum)
 	if err != nil {
 		return err
 	}
 
-	err = rm.lm.Flush(lsn)
+	err = rm.lm.FlushCommit(lsn)
 	if err != nil {
 		return err
 	}
@@ -45,14 +61,12 @@ func (rm *RecoveryMgr) Commit() error {
 	return nil
 }
 
-// Rollback writes a rollback record to the log and flushes it to disk.
+// Rollback undoes the transaction's changes, logging each undo in a
+// compensation record, and then writes a rollback record to the log and
+// flushes it to disk. The modified buffers don't need to be flushed, since
+// recovery can redo the undos from the log.
 func (rm *RecoveryMgr) Rollback() error {
-	err := rm.doRollback()
-	if err != nil {
-		return err
-	}
-
-	err = rm.bm.FlushAll(rm.txnum)
+	err := rm.doRollback(rm.startLSN)
 	if err != nil {
 		return err
 	}
@@ -62,7 +76,7 @@ func (rm *RecoveryMgr) Rollback() error {
 		return err
 	}
 
-	err = rm.lm.Flush(lsn)
+	err = rm.lm.FlushCommit(lsn)
 	if err != nil {
 		return err
 	}
@@ -70,8 +84,25 @@ func (rm *RecoveryMgr) Rollback() error {
 	return nil
 }
 
-// Recover recovers uncompleted transactions from the log and then writes a
-// quiescent checkpoint record to the log and flushes it.
+// Savepoint returns the LSN of the latest record that the transaction has
+// written, which marks the point that RollbackTo can return to.
+func (rm *RecoveryMgr) Savepoint() int {
+	return rm.lastLSN
+}
+
+// RollbackTo undoes the transaction's changes logged after the specified
+// savepoint LSN, logging each undo in a compensation record. The
+// transaction keeps running, and a later rollback skips the changes that
+// were undone.
+func (rm *RecoveryMgr) RollbackTo(savepoint int) error {
+	return rm.doRollback(savepoint)
+}
+
+// Recover redoes the changes in the log that aren't on disk, rolls back
+// uncompleted transactions, and then writes a nonquiescent checkpoint
+// record to the log and flushes it. The checkpoint lists this transaction,
+// which is still running, so that the changes it makes after recovery are
+// undone if the system crashes before it commits.
 func (rm *RecoveryMgr) Recover() error {
 	err := rm.doRecover()
 	if err != nil {
@@ -83,7 +114,7 @@ func (rm *RecoveryMgr) Recover() error {
 		return err
 	}
 
-	lsn, err := WriteCheckpointToLog(rm.lm)
+	lsn, err := WriteNQCheckpointToLog(rm.lm, rm.startLSN, []int{rm.txnum})
 	if err != nil {
 		return err
 	}
@@ -93,92 +124,283 @@ func (rm *RecoveryMgr) Recover() error {
 		return err
 	}
 
-	return nil
+	// This transaction's own records, which come before the checkpoint,
+	// are still needed in case it rolls back.
+	return rm.lm.TruncateBefore(rm.startLSN)
 }
 
 // SetInt writes a setint record to the log and returns its LSN.
+// While an update is being undone, it writes a compensation record instead.
 func (rm *RecoveryMgr) SetInt(b *buffer.Buffer, offset int, newval int32) (int, error) {
-	// newval isn't used because the recovery algorithm is undo-only
+	if rm.undoing != nil {
+		return rm.logged(WriteCompensationToLog(rm.lm, rm.undoing.txnum, b.Blk, offset, newval, rm.undoing.lsn))
+	}
 	oldval := b.Contents.GetInt(offset)
-	return WriteSetIntToLog(rm.lm, rm.txnum, b.Blk, offset, int(oldval))
+	return rm.logged(WriteSetIntToLog(rm.lm, rm.txnum, b.Blk, offset, int(oldval), int(newval)))
 }
 
 // SetString writes a setstring record to the log and returns its LSN.
+// While an update is being undone, it writes a compensation record instead.
 func (rm *RecoveryMgr) SetString(b *buffer.Buffer, offset int, newval string) (int, error) {
-	// newvalue isn't used because the recovery algorithm is undo-only
+	if rm.undoing != nil {
+		return rm.logged(WriteCompensationToLog(rm.lm, rm.undoing.txnum, b.Blk, offset, newval, rm.undoing.lsn))
+	}
 	oldval := b.Contents.GetString(offset)
-	return WriteSetStringToLog(rm.lm, rm.txnum, b.Blk, offset, oldval)
+	return rm.logged(WriteSetStringToLog(rm.lm, rm.txnum, b.Blk, offset, oldval, newval))
 }
 
-// doRollback rolls back the transaction by iterating through the
-// log records until it finds the transaction's START record, calling
-// undo() for each of the transaction's log records.
-func (rm *RecoveryMgr) doRollback() error {
-	for bytes, err := range rm.lm.All() {
+// logged remembers the LSN of a record that was just written, and passes
+// it on.
+func (rm *RecoveryMgr) logged(lsn int, err error) (int, error) {
+	if err == nil {
+		rm.lastLSN = lsn
+	}
+	return lsn, err
+}
+
+// undo undoes the update with the specified LSN, which was made by the
+// specified transaction. The undo is logged in a compensation record for
+// that transaction.
+func (rm *RecoveryMgr) undo(rec UpdateRecord, lsn int) error {
+	rm.undoing = &undoing{rec.TxNumber(), lsn}
+	defer func() { rm.undoing = nil }()
+	return rec.Undo(rm.tx)
+}
+
+// doRollback rolls back the transaction by iterating backwards through the
+// log records until it reaches the specified LSN, which is that of the
+// transaction's START record or of a savepoint, undoing each of the
+// transaction's updates after it.
+// When it finds a compensation record, it skips to the update before the
+// one that was undone, since the updates in between were undone already.
+func (rm *RecoveryMgr) doRollback(stop int) error {
+	undoNext := math.MaxInt
+	for logrec, err := range rm.lm.Backward() {
 		if err != nil {
 			return err
 		}
-		rec, err := CreateLogRecord(bytes)
+		if logrec.LSN <= stop {
+			return nil
+		}
+		rec, err := CreateLogRecord(logrec.Bytes)
 		if err != nil {
 			return err
 		}
-		// If the log record is for the current transaction, undo it
-		if rec.TxNumber() == rm.txnum {
-			// If the log record is a start record, we're done
-			if rec.Op() == Start {
-				return nil
-			}
-			// Otherwise, undo the operation
-			err = rec.Undo(rm.tx)
-			if err != nil {
-				return err
+		if rec.TxNumber() != rm.txnum {
+			continue
+		}
+		switch rec := rec.(type) {
+		case *CompensationRecord:
+			undoNext = min(undoNext, rec.UndoNext())
+		case UpdateRecord:
+			if logrec.LSN < undoNext {
+				if err := rm.undo(rec, logrec.LSN); err != nil {
+					return err
+				}
 			}
 		}
 	}
 	return nil
 }
 
-// doRecover does a complete database recovery.
+// doRecover does a complete database recovery of the records logged before
+// this transaction started. It first reads the log backwards to find the
+// last checkpoint, which bounds how much of the log is needed. Then, in a
+// single forward pass from the oldest record that the checkpoint needs, it:
 //
-// The current recovery algorithm is undo-only - a transaction
-// never has to be redo-ed because a commit log entry is only written
-// after all of the transaction's buffers are flushed to disk.
-// The only thing we need to do in a recovery is undo transactions
-// that didn't finish complete.
+//   - finds the transactions that were unfinished when the log ended: those
+//     listed by a nonquiescent checkpoint, and those started after the
+//     checkpoint, less those with a commit or rollback record;
+//   - makes each logged change again, unless its block's page LSN shows that
+//     the block already contains it. This repeats history: the changes of
+//     committed transactions, which commit without flushing, are redone, and
+//     so are the updates of rolled back transactions along with the
+//     compensation records that undid them.
 //
-// It iterates through the log records (in reverse order), and whenever
-// it finds a log record for an unfinished transaction, it calls undo()
-// on that record. The method stops when it encounters a CHECKPOINT
-// record or the end of the log.
+// Finally, it undoes the changes of the unfinished transactions, newest
+// first, skipping those that compensation records show were already
+// undone, and writes a rollback record for each of them.
 func (rm *RecoveryMgr) doRecover() error {
-	finishedTxs := make([]int, 0)
-	for bytes, err := range rm.lm.All() {
+	ckptLSN, oldestLSN := 0, 0
+	unfinished := make(map[int]bool)
+	for logrec, err := range rm.lm.Backward() {
 		if err != nil {
 			return err
 		}
-		rec, err := CreateLogRecord(bytes)
+		if logrec.LSN >= rm.startLSN {
+			continue
+		}
+		rec, err := CreateLogRecord(logrec.Bytes)
 		if err != nil {
 			return err
 		}
-		// If the log record is a checkpoint, the recovery is finished.
-		// All logs beyond this point represent completed transations
-		// that are already on disk.
 		if rec.Op() == Checkpoint {
-			return nil
+			ckptLSN, oldestLSN = logrec.LSN, logrec.LSN
+			break
+		}
+		if ckpt, ok := rec.(*NQCheckpointRecord); ok {
+			ckptLSN, oldestLSN = logrec.LSN, logrec.LSN
+			if ckpt.OldestLSN() > 0 {
+				oldestLSN = ckpt.OldestLSN()
+			}
+			for _, txnum := range ckpt.Transactions() {
+				unfinished[txnum] = true
+			}
+			break
 		}
-		// If the log record is a commit or rollback,
-		// track this as a completed transaction.
-		if rec.Op() == Commit || rec.Op() == Rollback {
-			finishedTxs = append(finishedTxs, rec.TxNumber())
+	}
+
+	var undo []loggedRecord
+	for rec, err := range rm.recordsFrom(oldestLSN) {
+		if err != nil {
+			return err
 		}
-		// If the log record doesn't match a completed transaction,
-		// then the transaction it's part of didn't finish, so undo it.
-		if !slices.Contains(finishedTxs, rec.TxNumber()) {
-			err = rec.Undo(rm.tx)
-			if err != nil {
-				return err
+		switch rec.Op() {
+		case Start:
+			if rec.lsn > ckptLSN {
+				unfinished[rec.TxNumber()] = true
 			}
+		case Commit, Rollback:
+			delete(unfinished, rec.TxNumber())
+		}
+		ur, ok := rec.LogRecord.(UpdateRecord)
+		if !ok {
+			continue
+		}
+		if err := rm.redo(ur, rec.lsn); err != nil {
+			return err
+		}
+		if unfinished[ur.TxNumber()] {
+			undo = append(undo, rec)
 		}
 	}
+
+	// A transaction may have finished after being listed by the
+	// checkpoint, so only undo the ones that are still unfinished.
+	undoNext := make(map[int]int)
+	for _, rec := range slices.Backward(undo) {
+		txnum := rec.TxNumber()
+		if !unfinished[txnum] {
+			continue
+		}
+		if clr, ok := rec.LogRecord.(*CompensationRecord); ok {
+			if next, ok := undoNext[txnum]; !ok || clr.UndoNext() < next {
+				undoNext[txnum] = clr.UndoNext()
+			}
+			continue
+		}
+		if next, ok := undoNext[txnum]; ok && rec.lsn >= next {
+			continue
+		}
+		if err := rm.undo(rec.LogRecord.(UpdateRecord), rec.lsn); err != nil {
+			return err
+		}
+	}
+	for txnum := range unfinished {
+		if _, err := WriteRollbackToLog(rm.lm, txnum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redo makes a logged change to a block again, if the block doesn't
+// already contain it, and sets the block's page LSN to the record's LSN.
+func (rm *RecoveryMgr) redo(rec UpdateRecord, lsn int) error {
+	b, err := rm.bm.Pin(rec.Block())
+	if err != nil {
+		return err
+	}
+	defer rm.bm.Unpin(b)
+	if b.Contents.LSN() < lsn {
+		rec.Redo(b.Contents)
+		b.SetModified(rm.txnum, lsn)
+	}
 	return nil
 }
+
+// loggedRecord is a log record read during recovery, with its LSN.
+type loggedRecord struct {
+	LogRecord
+	lsn int
+}
+
+// recordsFrom returns the log records starting at the specified LSN and
+// ending before this transaction's start record. The log records that
+// follow were written by this transaction, or after recovery began.
+func (rm *RecoveryMgr) recordsFrom(lsn int) iter.Seq2[loggedRecord, error] {
+	return func(yield func(loggedRecord, error) bool) {
+		for logrec, err := range rm.lm.From(lsn) {
+			if err != nil {
+				yield(loggedRecord{}, err)
+				return
+			}
+			if logrec.LSN >= rm.startLSN {
+				return
+			}
+			rec, err := CreateLogRecord(logrec.Bytes)
+			if !yield(loggedRecord{rec, logrec.LSN}, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// NonquiescentCheckpoint writes a checkpoint while transactions are
+// running. It flushes the modified buffers that aren't pinned, then writes
+// an NQCKPT record listing the active transactions, which are given with
+// the LSNs of their start records, and flushes it. The record also holds
+// the oldest LSN that recovery may need: the start of an active
+// transaction, or a change in a buffer that is still dirty. The log
+// segments before that LSN are then removed or archived.
+// No transaction may start while the checkpoint is being written.
+func NonquiescentCheckpoint(lm *log.LogMgr, bm *buffer.BufferMgr, active map[int]int) error {
+	if _, err := bm.FlushUnpinned(0); err != nil {
+		return err
+	}
+	oldest := bm.OldestDirtyLSN()
+	txnums := make([]int, 0, len(active))
+	for txnum, startLSN := range active {
+		txnums = append(txnums, txnum)
+		if oldest == 0 || startLSN < oldest {
+			oldest = startLSN
+		}
+	}
+	slices.Sort(txnums)
+	lsn, err := WriteNQCheckpointToLog(lm, oldest, txnums)
+	if err != nil {
+		return err
+	}
+	if err := lm.Flush(lsn); err != nil {
+		return err
+	}
+	if oldest == 0 {
+		oldest = lsn
+	}
+	return lm.TruncateBefore(oldest)
+}
+
+// QuiescentCheckpoint flushes the modified buffers that aren't pinned,
+// then writes a checkpoint record to the log and flushes it, so that
+// recovery never needs to look past the record. The log segments before
+// the checkpoint are then removed or archived.
+// If a buffer is still dirty, because it is pinned, a nonquiescent
+// checkpoint is written instead, so that recovery can still redo the
+// buffer's changes.
+// It must only be called while no transactions are active.
+func QuiescentCheckpoint(lm *log.LogMgr, bm *buffer.BufferMgr) error {
+	if _, err := bm.FlushUnpinned(0); err != nil {
+		return err
+	}
+	if bm.OldestDirtyLSN() > 0 {
+		return NonquiescentCheckpoint(lm, bm, nil)
+	}
+	lsn, err := WriteCheckpointToLog(lm)
+	if err != nil {
+		return err
+	}
+	if err := lm.Flush(lsn); err != nil {
+		return err
+	}
+	return lm.TruncateBefore(lsn)
+}
@@ -6,14 +6,15 @@ import (
 	"simpledb/internal/log"
 )
 
-// Check that SetIntRecord implements LogRecord
-var _ LogRecord = (*SetIntRecord)(nil)
+// Check that SetIntRecord implements UpdateRecord
+var _ UpdateRecord = (*SetIntRecord)(nil)
 
 // SetIntRecord represents a SETINT log record
 type SetIntRecord struct {
 	txnum  int
 	offset int
-	val    int
+	oldval int
+	newval int
 	blk    file.BlockID
 }
 
@@ -29,8 +30,10 @@ func NewSetIntRecord(p *file.Page) *SetIntRecord {
 	opos := bpos + 4
 	offset := int(p.GetInt(opos))
 	vpos := opos + 4
-	val := int(p.GetInt(vpos))
-	return &SetIntRecord{txnum, offset, val, blk}
+	oldval := int(p.GetInt(vpos))
+	npos := vpos + 4
+	newval := int(p.GetInt(npos))
+	return &SetIntRecord{txnum, offset, oldval, newval, blk}
 }
 
 // Op returns the log record's type.
@@ -43,16 +46,22 @@ func (r *SetIntRecord) TxNumber() int {
 	return r.txnum
 }
 
-// Undo replaces the specified data value with the value saved in the log record.
+// Block returns the block that the record modified.
+func (r *SetIntRecord) Block() file.BlockID {
+	return r.blk
+}
+
+// Undo replaces the specified data value with the old value saved in the log record.
 // The method pins a buffer to the specified block, calls SetInt to restore
-// the saved value, and unpins the buffer.
+// the saved value, and unpins the buffer. The transaction's recovery manager
+// logs the restored value in a compensation record.
 func (r *SetIntRecord) Undo(tx Transaction) error {
 	err := tx.Pin(r.blk)
 	if err != nil {
 		return err
 	}
 
-	err = tx.SetInt(r.blk, r.offset, int32(r.val), false) // don't log the undo!
+	err = tx.SetInt(r.blk, r.offset, int32(r.oldval), true)
 	if err != nil {
 		return err
 	}
@@ -61,29 +70,36 @@ func (r *SetIntRecord) Undo(tx Transaction) error {
 	return nil
 }
 
+// Redo writes the new value saved in the log record to the page.
+func (r *SetIntRecord) Redo(p *file.Page) {
+	p.SetInt(r.offset, int32(r.newval))
+}
+
 // String returns a string representation of the SetIntRecord.
 func (r *SetIntRecord) String() string {
-	return fmt.Sprintf("<SETINT %d %s %d %d>", r.txnum, r.blk.String(), r.offset, r.val)
+	return fmt.Sprintf("<SETINT %d %s %d %d %d>", r.txnum, r.blk.String(), r.offset, r.oldval, r.newval)
 }
 
 // WriteSetIntToLog writes a setint record to the log.
 // This log record contains the SETINT operator, followed by the transaction id,
-// the filename, number, and offset of the modified block, and the previous integer
-// value at that offset.
+// the filename, number, and offset of the modified block, and the previous and
+// new integer values at that offset.
 // It returns the LSN of the last log value.
-func WriteSetIntToLog(lm *log.LogMgr, txnum int, blk file.BlockID, offset int, val int) (int, error) {
+func WriteSetIntToLog(lm *log.LogMgr, txnum int, blk file.BlockID, offset int, oldval int, newval int) (int, error) {
 	tpos := 4
 	fpos := tpos + 4
 	bpos := fpos + file.MaxLength(len(blk.Filename))
 	opos := bpos + 4
 	vpos := opos + 4
-	rec := make([]byte, vpos+4)
+	npos := vpos + 4
+	rec := make([]byte, npos+4)
 	p := file.NewPageFromBytes(rec)
 	p.SetInt(0, int32(SetInt))
 	p.SetInt(4, int32(txnum))
 	p.SetString(fpos, blk.Filename)
 	p.SetInt(bpos, int32(blk.Blknum))
 	p.SetInt(opos, int32(offset))
-	p.SetInt(vpos, int32(val))
+	p.SetInt(vpos, int32(oldval))
+	p.SetInt(npos, int32(newval))
 	return lm.Append(rec)
 }
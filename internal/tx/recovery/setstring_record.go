@@ -6,14 +6,15 @@ import (
 	"simpledb/internal/log"
 )
 
-// Check that SetStringRecord implements LogRecord
-var _ LogRecord = (*SetStringRecord)(nil)
+// Check that SetStringRecord implements UpdateRecord
+var _ UpdateRecord = (*SetStringRecord)(nil)
 
 // SetStringRecord represents a SETSTRING log record
 type SetStringRecord struct {
 	txnum  int
 	offset int
-	val    string
+	oldval string
+	newval string
 	blk    file.BlockID
 }
 
@@ -29,8 +30,10 @@ func NewSetStringRecord(p *file.Page) *SetStringRecord {
 	opos := bpos + 4
 	offset := int(p.GetInt(opos))
 	vpos := opos + 4
-	val := p.GetString(vpos)
-	return &SetStringRecord{txnum, offset, val, blk}
+	oldval := p.GetString(vpos)
+	npos := vpos + file.MaxLength(len(oldval))
+	newval := p.GetString(npos)
+	return &SetStringRecord{txnum, offset, oldval, newval, blk}
 }
 
 // Op returns the log record's type.
@@ -43,16 +46,22 @@ func (r *SetStringRecord) TxNumber() int {
 	return r.txnum
 }
 
-// Undo replaces the specified data value with the value saved in the log record.
+// Block returns the block that the record modified.
+func (r *SetStringRecord) Block() file.BlockID {
+	return r.blk
+}
+
+// Undo replaces the specified data value with the old value saved in the log record.
 // The method pins a buffer to the specified block, calls SetString to restore
-// the saved value, and unpins the buffer.
+// the saved value, and unpins the buffer. The transaction's recovery manager
+// logs the restored value in a compensation record.
 func (r *SetStringRecord) Undo(tx Transaction) error {
 	err := tx.Pin(r.blk)
 	if err != nil {
 		return err
 	}
 
-	err = tx.SetString(r.blk, r.offset, r.val, false) // don't log the undo!
+	err = tx.SetString(r.blk, r.offset, r.oldval, true)
 	if err != nil {
 		return err
 	}
@@ -61,23 +70,29 @@ func (r *SetStringRecord) Undo(tx Transaction) error {
 	return nil
 }
 
+// Redo writes the new value saved in the log record to the page.
+func (r *SetStringRecord) Redo(p *file.Page) {
+	p.SetString(r.offset, r.newval)
+}
+
 // String returns a string representation of the SetStringRecord.
 func (r *SetStringRecord) String() string {
-	return fmt.Sprintf("<SETSTRING %d %s %d %s>", r.txnum, r.blk.String(), r.offset, r.val)
+	return fmt.Sprintf("<SETSTRING %d %s %d %s %s>", r.txnum, r.blk.String(), r.offset, r.oldval, r.newval)
 }
 
 // WriteSetStringToLog writes a setstring record to the log.
 // This log record contains the SetString operator, followed by the transaction id,
-// the filename, number, and offset of the modified block, and the previous string
-// value at that offset.
+// the filename, number, and offset of the modified block, and the previous and
+// new string values at that offset.
 // It returns the LSN of the last log value.
-func WriteSetStringToLog(lm *log.LogMgr, txnum int, blk file.BlockID, offset int, val string) (int, error) {
+func WriteSetStringToLog(lm *log.LogMgr, txnum int, blk file.BlockID, offset int, oldval string, newval string) (int, error) {
 	tpos := 4
 	fpos := tpos + 4
 	bpos := fpos + file.MaxLength(len(blk.Filename))
 	opos := bpos + 4
 	vpos := opos + 4
-	reclen := vpos + file.MaxLength(len(val))
+	npos := vpos + file.MaxLength(len(oldval))
+	reclen := npos + file.MaxLength(len(newval))
 	rec := make([]byte, reclen)
 	p := file.NewPageFromBytes(rec)
 	p.SetInt(0, int32(SetString))
@@ -85,6 +100,7 @@ func WriteSetStringToLog(lm *log.LogMgr, txnum int, blk file.BlockID, offset int
 	p.SetString(fpos, blk.Filename)
 	p.SetInt(bpos, int32(blk.Blknum))
 	p.SetInt(opos, int32(offset))
-	p.SetString(vpos, val)
+	p.SetString(vpos, oldval)
+	p.SetString(npos, newval)
 	return lm.Append(rec)
 }
@@ -3,8 +3,13 @@ package tx_test
 import (
 	"os"
 	"simpledb/internal/file"
+	"simpledb/internal/log"
 	"simpledb/internal/server"
+	"simpledb/internal/tx"
+	"simpledb/internal/tx/recovery"
+	"slices"
 	"testing"
+	"time"
 )
 
 var (
@@ -90,6 +95,11 @@ func initialize(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to commit transaction: %v", err)
 	}
+	// The changes weren't logged, so they only become durable when they
+	// are flushed.
+	if _, err := db.BufferMgr.FlushUnpinned(0); err != nil {
+		t.Fatalf("Failed to flush buffers: %v", err)
+	}
 
 	printValues(t, "After Initialization:")
 }
@@ -168,6 +178,24 @@ func recover(t *testing.T) {
 		t.Fatalf("Failed to recover transaction: %v", err)
 	}
 	printValues(t, "After recovery:")
+
+	// Both the rolled back and the unfinished changes are undone.
+	p0 := file.NewPage(db.FileMgr.BlockSize)
+	p1 := file.NewPage(db.FileMgr.BlockSize)
+	if err := db.FileMgr.Read(blk0, p0); err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+	if err := db.FileMgr.Read(blk1, p1); err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+	for pos := 0; pos < 24; pos += 4 {
+		if p0.GetInt(pos) != int32(pos) || p1.GetInt(pos) != int32(pos) {
+			t.Fatalf("Expected %d at offset %d, got %d and %d", pos, pos, p0.GetInt(pos), p1.GetInt(pos))
+		}
+	}
+	if p0.GetString(30) != "abc" || p1.GetString(30) != "def" {
+		t.Fatalf("Expected abc and def, got %s and %s", p0.GetString(30), p1.GetString(30))
+	}
 }
 
 func printValues(t *testing.T, msg string) {
@@ -196,3 +224,724 @@ func printValues(t *testing.T, msg string) {
 	t.Logf("%v %v %v %v %v %v %v %v %v %v %v %v %v %v",
 		values...)
 }
+
+func TestCheckpoint(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("checkpointtest")
+	})
+
+	cdb, err := server.NewSimpleDBWithConfig("checkpointtest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer cdb.Close()
+
+	lastOp := func() recovery.LogRecordType {
+		t.Helper()
+		for bytes, err := range cdb.LogMgr.All() {
+			if err != nil {
+				t.Fatalf("Failed to read log: %v", err)
+			}
+			rec, err := recovery.CreateLogRecord(bytes)
+			if err != nil {
+				t.Fatalf("Failed to create log record: %v", err)
+			}
+			return rec.Op()
+		}
+		t.Fatal("Expected a log record")
+		return -1
+	}
+
+	tx1, err := cdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	blk := file.NewBlockID("testfile", 0)
+	if err := tx1.Pin(blk); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if err := tx1.SetInt(blk, 0, 42, true); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+
+	// While tx1 is active, the checkpoint is nonquiescent and lists it.
+	if err := cdb.Checkpoint(); err != nil {
+		t.Fatalf("Failed to write checkpoint: %v", err)
+	}
+	for bytes, err := range cdb.LogMgr.All() {
+		if err != nil {
+			t.Fatalf("Failed to read log: %v", err)
+		}
+		rec, err := recovery.CreateLogRecord(bytes)
+		if err != nil {
+			t.Fatalf("Failed to create log record: %v", err)
+		}
+		ckpt, ok := rec.(*recovery.NQCheckpointRecord)
+		if !ok {
+			t.Fatalf("Expected last log record to be NQCKPT, got %d", rec.Op())
+		}
+		if txs := ckpt.Transactions(); len(txs) != 1 || txs[0] != tx1.TxNum() {
+			t.Fatalf("Expected NQCKPT to list transaction %d, got %v", tx1.TxNum(), txs)
+		}
+		if ckpt.OldestLSN() != tx1.StartLSN() {
+			t.Fatalf("Expected NQCKPT to need LSN %s, got %s", log.FormatLSN(tx1.StartLSN()), log.FormatLSN(ckpt.OldestLSN()))
+		}
+		break
+	}
+
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+	if err := cdb.Checkpoint(); err != nil {
+		t.Fatalf("Failed to write checkpoint: %v", err)
+	}
+	if op := lastOp(); op != recovery.Checkpoint {
+		t.Fatalf("Expected last log record to be CHECKPOINT, got %d", op)
+	}
+
+	// Once tx1 is used again, it is active again, under a new number.
+	if err := tx1.Pin(blk); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if err := cdb.Checkpoint(); err != nil {
+		t.Fatalf("Failed to write checkpoint: %v", err)
+	}
+	for bytes, err := range cdb.LogMgr.All() {
+		if err != nil {
+			t.Fatalf("Failed to read log: %v", err)
+		}
+		rec, err := recovery.CreateLogRecord(bytes)
+		if err != nil {
+			t.Fatalf("Failed to create log record: %v", err)
+		}
+		ckpt, ok := rec.(*recovery.NQCheckpointRecord)
+		if !ok {
+			t.Fatalf("Expected last log record to be NQCKPT, got %d", rec.Op())
+		}
+		if txs := ckpt.Transactions(); len(txs) != 1 || txs[0] != tx1.TxNum() {
+			t.Fatalf("Expected NQCKPT to list transaction %d, got %v", tx1.TxNum(), txs)
+		}
+		break
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	// The checkpointer writes checkpoints in the background, and stops
+	// when the database is closed.
+	tx2, err := cdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx2.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+	if op := lastOp(); op != recovery.Commit {
+		t.Fatalf("Expected last log record to be COMMIT, got %d", op)
+	}
+	cdb.StartBackground(server.BackgroundConfig{CheckpointInterval: time.Millisecond})
+	deadline := time.Now().Add(time.Second)
+	for lastOp() != recovery.Checkpoint && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if op := lastOp(); op != recovery.Checkpoint {
+		t.Fatalf("Expected the checkpointer to write a checkpoint, got %d", op)
+	}
+}
+
+func TestCheckpointPinnedDirtyBuffer(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("checkpointpinnedtest")
+	})
+
+	cdb, err := server.NewSimpleDBWithConfig("checkpointpinnedtest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer cdb.Close()
+
+	tx1, err := cdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	blk := file.NewBlockID("testfile", 0)
+	if err := tx1.Pin(blk); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if err := tx1.SetInt(blk, 0, 42, true); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	// No transaction is active, but the committed change is in a buffer
+	// that is pinned, so it can't be flushed; the checkpoint must still
+	// let recovery redo it.
+	b, err := cdb.BufferMgr.Pin(blk)
+	if err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	defer cdb.BufferMgr.Unpin(b)
+	oldest := cdb.BufferMgr.OldestDirtyLSN()
+	if oldest == 0 {
+		t.Fatal("Expected the pinned buffer to be dirty")
+	}
+	if err := cdb.Checkpoint(); err != nil {
+		t.Fatalf("Failed to write checkpoint: %v", err)
+	}
+	for bytes, err := range cdb.LogMgr.All() {
+		if err != nil {
+			t.Fatalf("Failed to read log: %v", err)
+		}
+		rec, err := recovery.CreateLogRecord(bytes)
+		if err != nil {
+			t.Fatalf("Failed to create log record: %v", err)
+		}
+		ckpt, ok := rec.(*recovery.NQCheckpointRecord)
+		if !ok {
+			t.Fatalf("Expected last log record to be NQCKPT, got %d", rec.Op())
+		}
+		if ckpt.OldestLSN() != oldest {
+			t.Fatalf("Expected NQCKPT to need LSN %s, got %s", log.FormatLSN(oldest), log.FormatLSN(ckpt.OldestLSN()))
+		}
+		break
+	}
+}
+
+func TestCheckpointWhileWriting(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("checkpointwritetest")
+	})
+
+	cdb, err := server.NewSimpleDBWithConfig("checkpointwritetest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer cdb.Close()
+
+	// Checkpoints read the state of the buffers that a transaction is
+	// modifying.
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := cdb.Checkpoint(); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	tx1, err := cdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	blk := file.NewBlockID("testfile", 0)
+	for i := 0; i < 200; i++ {
+		if err := tx1.Pin(blk); err != nil {
+			t.Fatalf("Failed to pin block: %v", err)
+		}
+		if err := tx1.SetInt(blk, 0, int32(i), true); err != nil {
+			t.Fatalf("Failed to set int: %v", err)
+		}
+		tx1.Unpin(blk)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+	close(done)
+	if err := <-errs; err != nil {
+		t.Fatalf("Failed to write checkpoint: %v", err)
+	}
+}
+
+func TestRecoveryTornLog(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("recoverytorntest")
+	})
+
+	tdb, err := server.NewSimpleDBWithConfig("recoverytorntest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	blk := file.NewBlockID("testfile", 0)
+	tx1, err := tdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx1.Pin(blk); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if err := tx1.SetInt(blk, 0, 42, true); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+	// The uncommitted change reaches the disk, and so does its log record.
+	tx1.Unpin(blk)
+	if _, err := tdb.BufferMgr.FlushUnpinned(0); err != nil {
+		t.Fatalf("Failed to flush buffers: %v", err)
+	}
+
+	// Crash while writing the next log record: the block's boundary was
+	// moved, but only the start of the record made it to disk.
+	segfile := log.SegmentFileName(log.DefaultLogFile, 1)
+	n, err := tdb.FileMgr.Length(segfile)
+	if err != nil {
+		t.Fatalf("Failed to get log length: %v", err)
+	}
+	logblk := file.NewBlockID(segfile, n-1)
+	p := file.NewPage(tdb.FileMgr.BlockSize)
+	if err := tdb.FileMgr.Read(logblk, p); err != nil {
+		t.Fatalf("Failed to read log block: %v", err)
+	}
+	boundary := int(p.GetInt(0)) - 24
+	p.SetInt(0, int32(boundary))
+	p.SetInt(boundary, 12)
+	p.SetInt(boundary+4, 99) // not a log record type
+	if err := tdb.FileMgr.Write(logblk, p); err != nil {
+		t.Fatalf("Failed to write log block: %v", err)
+	}
+	tdb.Close()
+
+	tdb, err = server.NewSimpleDBWithConfig("recoverytorntest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer tdb.Close()
+	tx2, err := tdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx2.Recover(); err != nil {
+		t.Fatalf("Failed to recover: %v", err)
+	}
+	if err := tx2.Pin(blk); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if n, err := tx2.GetInt(blk, 0); err != nil || n != 0 {
+		t.Fatalf("Expected the change to be undone, got %d, %v", n, err)
+	}
+	if err := tx2.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
+
+func TestRecoveryRedo(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("recoveryredotest")
+	})
+
+	rdb, err := server.NewSimpleDBWithConfig("recoveryredotest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	blk := file.NewBlockID("testfile", 0)
+	tx1, err := rdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx1.Pin(blk); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if err := tx1.SetInt(blk, 0, 42, true); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+	if err := tx1.SetString(blk, 20, "committed", true); err != nil {
+		t.Fatalf("Failed to set string: %v", err)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+	// Committing only flushes the log.
+	p := file.NewPage(rdb.FileMgr.BlockSize)
+	if err := rdb.FileMgr.Read(blk, p); err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+	if p.GetInt(0) != 0 {
+		t.Fatalf("Expected the block not to be flushed on commit, got %d", p.GetInt(0))
+	}
+
+	// An unfinished transaction changes the same block, and the change
+	// reaches the disk.
+	tx2, err := rdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx2.Pin(blk); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if err := tx2.SetInt(blk, 4, 99, true); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+	tx2.Unpin(blk)
+	if _, err := rdb.BufferMgr.FlushUnpinned(0); err != nil {
+		t.Fatalf("Failed to flush buffers: %v", err)
+	}
+	if err := rdb.FileMgr.Read(blk, p); err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+	if p.LSN() == 0 {
+		t.Fatal("Expected the flushed block to have a page LSN")
+	}
+
+	// A committed change whose block is never flushed before the crash.
+	tx3, err := rdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	blk2 := file.NewBlockID("testfile", 1)
+	if err := tx3.Pin(blk2); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if err := tx3.SetInt(blk2, 0, 7, true); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+	if err := tx3.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+	// Crash, without flushing the buffers or writing a checkpoint.
+	rdb.FileMgr.Close()
+
+	rdb, err = server.NewSimpleDBWithConfig("recoveryredotest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer rdb.Close()
+	tx4, err := rdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx4.Recover(); err != nil {
+		t.Fatalf("Failed to recover: %v", err)
+	}
+
+	if err := rdb.FileMgr.Read(blk, p); err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+	if p.GetInt(0) != 42 || p.GetString(20) != "committed" || p.GetInt(4) != 0 {
+		t.Fatalf("Expected the committed changes only, got %d, %q, %d", p.GetInt(0), p.GetString(20), p.GetInt(4))
+	}
+	if err := rdb.FileMgr.Read(blk2, p); err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+	if p.GetInt(0) != 7 {
+		t.Fatalf("Expected the committed change to be redone, got %d", p.GetInt(0))
+	}
+	if p.LSN() == 0 {
+		t.Fatal("Expected the redone block to have a page LSN")
+	}
+	if err := tx4.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
+
+func TestRecoveryNonquiescentCheckpoint(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("recoverynqckpttest")
+	})
+
+	ndb, err := server.NewSimpleDBWithConfig("recoverynqckpttest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	ndb.LogMgr.SetSegmentBlocks(1)
+	// Each transaction changes its own block, so that they don't wait for
+	// each other's locks.
+	blks := []file.BlockID{
+		file.NewBlockID("testfile", 0),
+		file.NewBlockID("testfile", 1),
+		file.NewBlockID("testfile", 2),
+		file.NewBlockID("testfile", 3),
+	}
+	setInt := func(blk file.BlockID, val int32) *tx.Transaction {
+		t.Helper()
+		tx, err := ndb.NewTx()
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		if err := tx.Pin(blk); err != nil {
+			t.Fatalf("Failed to pin block: %v", err)
+		}
+		if err := tx.SetInt(blk, 0, val, true); err != nil {
+			t.Fatalf("Failed to set int: %v", err)
+		}
+		tx.Unpin(blk)
+		return tx
+	}
+
+	// Fill several log segments with committed changes.
+	for i := 0; i < 20; i++ {
+		if err := setInt(blks[0], int32(i)).Commit(); err != nil {
+			t.Fatalf("Failed to commit transaction: %v", err)
+		}
+	}
+	// tx1 is active during the checkpoint and commits after it; tx2 is
+	// active during the checkpoint and never finishes; tx3 starts after
+	// the checkpoint and never finishes.
+	tx1 := setInt(blks[1], 1)
+	setInt(blks[2], 2)
+	before, err := ndb.LogMgr.Stats()
+	if err != nil {
+		t.Fatalf("Failed to get log stats: %v", err)
+	}
+	if err := ndb.Checkpoint(); err != nil {
+		t.Fatalf("Failed to write checkpoint: %v", err)
+	}
+	after, err := ndb.LogMgr.Stats()
+	if err != nil {
+		t.Fatalf("Failed to get log stats: %v", err)
+	}
+	if after.OldestNeededLSN != tx1.StartLSN() || after.Segments >= before.Segments {
+		t.Fatalf("Expected the log to be truncated at %s, got %+v", log.FormatLSN(tx1.StartLSN()), after)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+	setInt(blks[3], 3)
+	// Crash, without flushing the buffers.
+	ndb.FileMgr.Close()
+
+	ndb, err = server.NewSimpleDBWithConfig("recoverynqckpttest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer ndb.Close()
+	tx4, err := ndb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx4.Recover(); err != nil {
+		t.Fatalf("Failed to recover: %v", err)
+	}
+	p := file.NewPage(ndb.FileMgr.BlockSize)
+	var got []int32
+	for _, blk := range blks {
+		if err := ndb.FileMgr.Read(blk, p); err != nil {
+			t.Fatalf("Failed to read block: %v", err)
+		}
+		got = append(got, p.GetInt(0))
+	}
+	if !slices.Equal(got, []int32{19, 1, 0, 0}) {
+		t.Fatalf("Expected [19 1 0 0], got %v", got)
+	}
+	if err := tx4.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+}
+
+func TestRecoveryCompensation(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("recoveryclrtest")
+	})
+
+	cdb, err := server.NewSimpleDBWithConfig("recoveryclrtest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	blk1 := file.NewBlockID("testfile", 0)
+	blk2 := file.NewBlockID("testfile", 1)
+
+	// tx1 rolls back completely. Its change reached the disk before the
+	// rollback, but the undo doesn't.
+	tx1, err := cdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx1.Pin(blk1); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if err := tx1.SetInt(blk1, 0, 5, true); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+	tx1.Unpin(blk1)
+	if _, err := cdb.BufferMgr.FlushUnpinned(0); err != nil {
+		t.Fatalf("Failed to flush buffers: %v", err)
+	}
+	if err := tx1.Rollback(); err != nil {
+		t.Fatalf("Failed to roll back transaction: %v", err)
+	}
+
+	// tx2 is in the middle of rolling back when the database crashes: its
+	// last update has been undone, but not the others.
+	tx2, err := cdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx2.Pin(blk2); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := tx2.SetInt(blk2, 4*i, int32(10*(i+1)), true); err != nil {
+			t.Fatalf("Failed to set int: %v", err)
+		}
+	}
+	var lastLSN int
+	for rec, err := range cdb.LogMgr.Backward() {
+		if err != nil {
+			t.Fatalf("Failed to read log: %v", err)
+		}
+		lastLSN = rec.LSN
+		break
+	}
+	if _, err := recovery.WriteCompensationToLog(cdb.LogMgr, tx2.TxNum(), blk2, 8, int32(0), lastLSN); err != nil {
+		t.Fatalf("Failed to write compensation record: %v", err)
+	}
+	if err := tx2.SetInt(blk2, 8, 0, false); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+	tx2.Unpin(blk2)
+	if _, err := cdb.BufferMgr.FlushUnpinned(0); err != nil {
+		t.Fatalf("Failed to flush buffers: %v", err)
+	}
+	// Crash, without writing a checkpoint.
+	cdb.FileMgr.Close()
+
+	cdb, err = server.NewSimpleDBWithConfig("recoveryclrtest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer cdb.Close()
+	tx3, err := cdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx3.Recover(); err != nil {
+		t.Fatalf("Failed to recover: %v", err)
+	}
+	if err := tx3.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	p := file.NewPage(cdb.FileMgr.BlockSize)
+	if err := cdb.FileMgr.Read(blk1, p); err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+	if p.GetInt(0) != 0 {
+		t.Fatalf("Expected tx1's rollback to be redone, got %d", p.GetInt(0))
+	}
+	if err := cdb.FileMgr.Read(blk2, p); err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+	if got := []int32{p.GetInt(0), p.GetInt(4), p.GetInt(8)}; !slices.Equal(got, []int32{0, 0, 0}) {
+		t.Fatalf("Expected tx2 to be rolled back, got %v", got)
+	}
+
+	// Recovery resumed tx2's rollback, undoing each update once, and
+	// finished it with a rollback record.
+	clrs, rolledBack := 0, false
+	for bytes, err := range cdb.LogMgr.All() {
+		if err != nil {
+			t.Fatalf("Failed to read log: %v", err)
+		}
+		rec, err := recovery.CreateLogRecord(bytes)
+		if err != nil {
+			t.Fatalf("Failed to create log record: %v", err)
+		}
+		if rec.TxNumber() != tx2.TxNum() {
+			continue
+		}
+		if rec.Op() == recovery.Compensation {
+			clrs++
+		}
+		if rec.Op() == recovery.Rollback {
+			rolledBack = true
+		}
+	}
+	if clrs != 3 || !rolledBack {
+		t.Fatalf("Expected 3 compensation records and a rollback for tx2, got %d, %v", clrs, rolledBack)
+	}
+}
+
+// crashAfter opens the database in dir, recovers it, lets modify change it
+// without committing, flushes the changes to disk, and crashes. It then
+// recovers the database again and returns the value at the start of blk.
+func crashAfter(t *testing.T, dir string, blk file.BlockID, modify func(tx *tx.Transaction)) int32 {
+	t.Helper()
+	cdb, err := server.NewSimpleDBWithConfig(dir, 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	tx1, err := cdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx1.Recover(); err != nil {
+		t.Fatalf("Failed to recover: %v", err)
+	}
+	modify(tx1)
+	if _, err := cdb.BufferMgr.FlushUnpinned(0); err != nil {
+		t.Fatalf("Failed to flush buffers: %v", err)
+	}
+	// Crash, before the changes are committed.
+	cdb.FileMgr.Close()
+
+	cdb, err = server.NewSimpleDBWithConfig(dir, 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer cdb.Close()
+	tx2, err := cdb.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx2.Recover(); err != nil {
+		t.Fatalf("Failed to recover: %v", err)
+	}
+	if err := tx2.Commit(); err != nil {
+		t.Fatalf("Failed to commit transaction: %v", err)
+	}
+	p := file.NewPage(cdb.FileMgr.BlockSize)
+	if err := cdb.FileMgr.Read(blk, p); err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+	return p.GetInt(0)
+}
+
+func TestRecoveryCrashAfterRecovery(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("recoverycrashtest")
+	})
+
+	blk := file.NewBlockID("testfile", 0)
+	setInt := func(tx *tx.Transaction, val int32) {
+		t.Helper()
+		if err := tx.Pin(blk); err != nil {
+			t.Fatalf("Failed to pin block: %v", err)
+		}
+		if err := tx.SetInt(blk, 0, val, true); err != nil {
+			t.Fatalf("Failed to set int: %v", err)
+		}
+		tx.Unpin(blk)
+	}
+
+	// The transaction that recovered the database goes on to change it,
+	// as when the metadata is set up, and the system crashes before it
+	// commits.
+	got := crashAfter(t, "recoverycrashtest", blk, func(tx *tx.Transaction) {
+		setInt(tx, 7)
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Failed to commit transaction: %v", err)
+		}
+		// The transaction restarts when it is used again.
+		txnum := tx.TxNum()
+		setInt(tx, 8)
+		if tx.TxNum() == txnum {
+			t.Fatalf("Expected the transaction to restart with a new number, but it kept %d", txnum)
+		}
+	})
+	if got != 7 {
+		t.Fatalf("Expected the change made after the commit to be undone, got %d", got)
+	}
+
+	got = crashAfter(t, "recoverycrashtest", blk, func(tx *tx.Transaction) {
+		setInt(tx, 9)
+	})
+	if got != 7 {
+		t.Fatalf("Expected the change made after recovery to be undone, got %d", got)
+	}
+}
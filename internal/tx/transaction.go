@@ -2,6 +2,7 @@ package tx
 
 import (
 	"fmt"
+	"maps"
 	"simpledb/internal/buffer"
 	"simpledb/internal/file"
 	"simpledb/internal/log"
@@ -25,39 +26,130 @@ func nextTxNumber() int {
 // Transaction provides transaction management for clients, ensuring that
 // all transactions are serializable, recoverable, and in general satisfy
 // the ACID properties.
+// A transaction can be used again after it commits or rolls back. It then
+// restarts, with a new number and a new start record, the first time it
+// pins a block or accesses a file, so that recovery treats the changes it
+// makes from then on as those of a new transaction.
 type Transaction struct {
 	rm      *recovery.RecoveryMgr
 	cm      *concurrency.ConcurrencyMgr
 	bm      *buffer.BufferMgr
 	fm      *file.FileMgr
+	lm      *log.LogMgr
+	lt      *concurrency.LockTable
 	txnum   int
 	buffers *BufferList
+	// Whether the transaction has committed or rolled back, and hasn't
+	// restarted since.
+	ended bool
+	// The function that restarts the transaction, if one is set; see
+	// OnRestart.
+	restart func(start func() error) error
+	// The number of times this transaction has pinned a block.
+	blocksPinned int
+	// The changes this transaction has made to each table.
+	deltas   map[string]TableDelta
+	listener TableChangeListener
+	// Functions to call when the transaction ends.
+	endHooks []func(committed bool)
+	// The savepoints that can be rolled back to, oldest first.
+	savepoints []savepoint
+	// Whether reads take update locks instead of shared locks.
+	readForUpdate bool
+	// Whether the transaction is being rolled back, which it may be even
+	// if it has been aborted.
+	rollingBack bool
+}
+
+// savepoint records the state of a transaction when a savepoint was set.
+type savepoint struct {
+	name   string
+	lsn    int
+	pins   []file.BlockID
+	deltas map[string]TableDelta
+}
+
+// TableDelta describes the changes that a transaction has made to a table.
+type TableDelta struct {
+	// Rows is the net number of records inserted.
+	Rows int
+	// Blocks is the number of blocks appended.
+	Blocks int
+	// Modifications is the number of records inserted, deleted or updated.
+	Modifications int
+}
+
+// TableChangeListener is notified of the changes that a transaction has
+// made to each table, once the transaction has ended.
+// If the transaction rolled back, committed is false; its records were
+// restored, but the blocks it appended remain part of the table.
+type TableChangeListener interface {
+	TablesChanged(deltas map[string]TableDelta, committed bool)
 }
 
 // NewTransaction creates a new transaction instance.
 func NewTransaction(fm *file.FileMgr, lm *log.LogMgr, bm *buffer.BufferMgr, lt *concurrency.LockTable) (*Transaction, error) {
 	t := &Transaction{
-		rm:      nil,
-		cm:      concurrency.NewConcurrencyMgr(lt),
 		bm:      bm,
 		fm:      fm,
-		txnum:   nextTxNumber(),
+		lm:      lm,
+		lt:      lt,
 		buffers: NewBufferList(bm),
 	}
+	if err := t.start(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
 
-	rm, err := recovery.NewRecoveryMgr(t, t.txnum, lm, bm)
+// start gives the transaction a new number, with its own recovery and
+// concurrency managers, and writes its start record to the log.
+func (t *Transaction) start() error {
+	txnum := nextTxNumber()
+	rm, err := recovery.NewRecoveryMgr(t, txnum, t.lm, t.bm)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	t.txnum = txnum
 	t.rm = rm
+	t.cm = concurrency.NewConcurrencyMgr(t.lt, txnum)
+	t.ended = false
+	return nil
+}
 
-	return t, nil
+// begin restarts the transaction if it has committed or rolled back.
+func (t *Transaction) begin() error {
+	if !t.ended {
+		return nil
+	}
+	if t.restart != nil {
+		return t.restart(t.start)
+	}
+	return t.start()
+}
+
+// OnRestart sets the function that restarts the transaction when it is used
+// again after committing or rolling back. The function must call start,
+// which gives the transaction its new number and writes its start record;
+// it can keep track of the transactions that are running around that call.
+func (t *Transaction) OnRestart(f func(start func() error) error) {
+	t.restart = f
 }
 
-// Commit commits the transaction. It flushes all modified buffers
-// (and their log records), writes and flushes a commit record to the log,
-// releases all locks, and unpins any pinned buffers.
+// Commit commits the transaction. It writes and flushes a commit record
+// to the log, releases all locks, and unpins any pinned buffers.
+// The modified buffers are flushed later, since recovery can redo their
+// changes from the log.
+// A transaction that has been aborted to deal with a deadlock can't commit;
+// Commit returns a DeadlockError, and the transaction must be rolled back.
+// Committing a transaction that has already ended does nothing.
 func (t *Transaction) Commit() error {
+	if t.ended {
+		return nil
+	}
+	if err := t.cm.Aborted(); err != nil {
+		return err
+	}
 	err := t.rm.Commit()
 	if err != nil {
 		return err
@@ -66,15 +158,22 @@ func (t *Transaction) Commit() error {
 	fmt.Printf("transaction %d committed\n", t.txnum)
 	t.cm.Release()
 	t.buffers.UnpinAll()
+	t.end(true)
 	return nil
 }
 
 // Rollback rolls back the current transaction.
-// It undoes any modified values, flushes those buffers,
+// It undoes any modified values, logging each undo in a compensation record,
 // writes and flushes a rollback record to the log, releases all locks,
 // and unpins any pinned buffers.
+// Rolling back a transaction that has already ended does nothing.
 func (t *Transaction) Rollback() error {
+	if t.ended {
+		return nil
+	}
+	t.rollingBack = true
 	err := t.rm.Rollback()
+	t.rollingBack = false
 	if err != nil {
 		return err
 	}
@@ -82,15 +181,103 @@ func (t *Transaction) Rollback() error {
 	fmt.Printf("transaction %d rolled back\n", t.txnum)
 	t.cm.Release()
 	t.buffers.UnpinAll()
+	t.end(false)
 	return nil
 }
 
-// Recover flushes all modified buffers, then goes through the log
-// and rolls back all uncommitted transactions.
-// Finally, it writes a quiescent checkpoint record to the log.
+// Savepoint sets a savepoint with the specified name, which the
+// transaction can later roll back to. A savepoint with the same name that
+// was set earlier is replaced.
+func (t *Transaction) Savepoint(name string) {
+	if i := t.findSavepoint(name); i >= 0 {
+		t.savepoints = append(t.savepoints[:i], t.savepoints[i+1:]...)
+	}
+	t.savepoints = append(t.savepoints, savepoint{
+		name:   name,
+		lsn:    t.rm.Savepoint(),
+		pins:   t.buffers.Pins(),
+		deltas: maps.Clone(t.deltas),
+	})
+}
+
+// RollbackToSavepoint undoes the changes the transaction made after the
+// savepoint with the specified name was set, logging each undo in a
+// compensation record, and unpins the buffers it pinned since then.
+// The transaction keeps its locks, and the savepoint itself, but the
+// savepoints set after it are released.
+// A transaction that has been aborted to deal with a deadlock must be
+// rolled back completely instead, so this returns a DeadlockError.
+func (t *Transaction) RollbackToSavepoint(name string) error {
+	if err := t.cm.Aborted(); err != nil {
+		return err
+	}
+	i := t.findSavepoint(name)
+	if i < 0 {
+		return fmt.Errorf("unknown savepoint: %s", name)
+	}
+	sp := t.savepoints[i]
+	if err := t.rm.RollbackTo(sp.lsn); err != nil {
+		return err
+	}
+	t.buffers.RestorePins(sp.pins)
+	t.restoreDeltas(sp.deltas)
+	t.savepoints = t.savepoints[:i+1]
+	return nil
+}
+
+// ReleaseSavepoint removes the savepoint with the specified name, along
+// with the savepoints set after it. The changes made since then are kept.
+func (t *Transaction) ReleaseSavepoint(name string) error {
+	i := t.findSavepoint(name)
+	if i < 0 {
+		return fmt.Errorf("unknown savepoint: %s", name)
+	}
+	t.savepoints = t.savepoints[:i]
+	return nil
+}
+
+// findSavepoint returns the index of the savepoint with the specified
+// name, or -1 if there isn't one.
+func (t *Transaction) findSavepoint(name string) int {
+	for i, sp := range t.savepoints {
+		if sp.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// restoreDeltas restores the changes the transaction has made to each table
+// to those it had made at a savepoint. As with a rollback, the blocks
+// appended since then remain part of their tables.
+func (t *Transaction) restoreDeltas(saved map[string]TableDelta) {
+	deltas := maps.Clone(saved)
+	for tblname, d := range t.deltas {
+		if d.Blocks == saved[tblname].Blocks {
+			continue
+		}
+		if deltas == nil {
+			deltas = make(map[string]TableDelta)
+		}
+		restored := deltas[tblname]
+		restored.Blocks = d.Blocks
+		deltas[tblname] = restored
+	}
+	t.deltas = deltas
+}
+
+// Recover flushes all modified buffers, then goes through the log,
+// redoing the changes that aren't on disk and rolling back all
+// uncommitted transactions.
+// Finally, it writes a checkpoint record to the log, which lists this
+// transaction, so that the changes it goes on to make are undone if the
+// system crashes before it commits.
 // This method is called during system startup, before user
 // transactions begin.
 func (t *Transaction) Recover() error {
+	if err := t.begin(); err != nil {
+		return err
+	}
 	err := t.bm.FlushAll(t.txnum)
 	if err != nil {
 		return err
@@ -106,8 +293,21 @@ func (t *Transaction) Recover() error {
 
 // Pin pins the specified block.
 // The transaction managers the buffer for the client.
+// Like the methods that read and write a block, it returns a DeadlockError
+// if the transaction has been aborted to deal with a deadlock, even if it
+// needs no new lock.
 func (t *Transaction) Pin(blk file.BlockID) error {
-	return t.buffers.Pin(blk)
+	if err := t.begin(); err != nil {
+		return err
+	}
+	if err := t.checkAborted(); err != nil {
+		return err
+	}
+	if err := t.buffers.Pin(blk); err != nil {
+		return err
+	}
+	t.blocksPinned++
+	return nil
 }
 
 // Unpin unpins the specified block.
@@ -116,12 +316,47 @@ func (t *Transaction) Unpin(blk file.BlockID) {
 	t.buffers.Unpin(blk)
 }
 
+// Prefetch asks the buffer manager to read the specified blocks in the
+// background, as a hint that the transaction is about to pin them in order.
+func (t *Transaction) Prefetch(blks []file.BlockID) {
+	t.bm.Prefetch(blks)
+}
+
+// SetReadForUpdate sets whether GetInt and GetString obtain ULocks instead
+// of SLocks. Update statements read the blocks they scan for update, since
+// they may modify them: two transactions can't both hold a ULock on a
+// block, so they can't deadlock upgrading their locks to XLocks.
+func (t *Transaction) SetReadForUpdate(forUpdate bool) {
+	t.readForUpdate = forUpdate
+}
+
+// checkAborted returns a DeadlockError if the transaction has been aborted
+// to deal with a deadlock, unless it is being rolled back.
+func (t *Transaction) checkAborted() error {
+	if t.rollingBack {
+		return nil
+	}
+	return t.cm.Aborted()
+}
+
+// readLock obtains the lock needed to read the specified block: a ULock if
+// the transaction is reading for update, and an SLock otherwise.
+func (t *Transaction) readLock(blk file.BlockID) error {
+	if err := t.checkAborted(); err != nil {
+		return err
+	}
+	if t.readForUpdate {
+		return t.cm.ULock(blk)
+	}
+	return t.cm.SLock(blk)
+}
+
 // GetInt returns the integer value stored at the specified offset
 // of the specified block.
-// The method first obtains an SLock on the block, then it calls the
-// buffer to retrieve the value.
+// The method first obtains an SLock (or ULock) on the block, then it calls
+// the buffer to retrieve the value.
 func (t *Transaction) GetInt(blk file.BlockID, offset int) (int32, error) {
-	if err := t.cm.SLock(blk); err != nil {
+	if err := t.readLock(blk); err != nil {
 		return 0, err
 	}
 	b, ok := t.buffers.GetBuffer(blk)
@@ -133,10 +368,10 @@ func (t *Transaction) GetInt(blk file.BlockID, offset int) (int32, error) {
 
 // GetString returns the string value stored at the specified offset
 // of the specified block.
-// The method first obtains an SLock on the block, then it calls the
-// buffer to retrieve the value.
+// The method first obtains an SLock (or ULock) on the block, then it calls
+// the buffer to retrieve the value.
 func (t *Transaction) GetString(blk file.BlockID, offset int) (string, error) {
-	if err := t.cm.SLock(blk); err != nil {
+	if err := t.readLock(blk); err != nil {
 		return "", err
 	}
 	b, ok := t.buffers.GetBuffer(blk)
@@ -153,6 +388,9 @@ func (t *Transaction) GetString(blk file.BlockID, offset int) (string, error) {
 // Finally, it calls the buffer to store the value,
 // passing in the LSN of the log record and the transaction's id.
 func (t *Transaction) SetInt(blk file.BlockID, offset int, n int32, okToLog bool) error {
+	if err := t.checkAborted(); err != nil {
+		return err
+	}
 	if err := t.cm.XLock(blk); err != nil {
 		return err
 	}
@@ -181,6 +419,9 @@ func (t *Transaction) SetInt(blk file.BlockID, offset int, n int32, okToLog bool
 // Finally, it calls the buffer to store the value,
 // passing in the LSN of the log record and the transaction's id.
 func (t *Transaction) SetString(blk file.BlockID, offset int, val string, okToLog bool) error {
+	if err := t.checkAborted(); err != nil {
+		return err
+	}
 	if err := t.cm.XLock(blk); err != nil {
 		return err
 	}
@@ -207,6 +448,9 @@ func (t *Transaction) SetString(blk file.BlockID, offset int, val string, okToLo
 // before asking the file manager to return the file size.
 // It returns an error if a lock could not be obtained.
 func (t *Transaction) Size(filename string) (int, error) {
+	if err := t.begin(); err != nil {
+		return 0, err
+	}
 	dummyblk := file.NewBlockID(filename, endOfFile)
 	if err := t.cm.SLock(dummyblk); err != nil {
 		return 0, err
@@ -220,6 +464,9 @@ func (t *Transaction) Size(filename string) (int, error) {
 // before performing the append.
 // It returns an error if a lock could not be obtained.
 func (t *Transaction) Append(filename string) (file.BlockID, error) {
+	if err := t.begin(); err != nil {
+		return file.BlockID{}, err
+	}
 	dummyblk := file.NewBlockID(filename, endOfFile)
 	if err := t.cm.XLock(dummyblk); err != nil {
 		return file.BlockID{}, err
@@ -227,11 +474,71 @@ func (t *Transaction) Append(filename string) (file.BlockID, error) {
 	return t.fm.Append(filename)
 }
 
+// TxNum returns the transaction's number, which changes when the
+// transaction restarts.
+func (t *Transaction) TxNum() int {
+	return t.txnum
+}
+
+// StartLSN returns the LSN of the transaction's start record.
+func (t *Transaction) StartLSN() int {
+	return t.rm.StartLSN()
+}
+
 // BlockSize returns the block size for the file system.
 func (t *Transaction) BlockSize() int {
 	return t.fm.BlockSize
 }
 
+// BlocksPinned returns the number of times this transaction has pinned a
+// block. It is used to report the actual block accesses of a query.
+func (t *Transaction) BlocksPinned() int {
+	return t.blocksPinned
+}
+
+// SetTableChangeListener sets the listener that is notified of the
+// changes this transaction made to each table when it ends.
+func (t *Transaction) SetTableChangeListener(l TableChangeListener) {
+	t.listener = l
+}
+
+// RecordTableChange adds the specified change to the changes this
+// transaction has made to a table.
+func (t *Transaction) RecordTableChange(tblname string, d TableDelta) {
+	if t.deltas == nil {
+		t.deltas = make(map[string]TableDelta)
+	}
+	total := t.deltas[tblname]
+	total.Rows += d.Rows
+	total.Blocks += d.Blocks
+	total.Modifications += d.Modifications
+	t.deltas[tblname] = total
+}
+
+// OnEnd registers a function to be called when the transaction commits
+// or rolls back, after its locks have been released.
+func (t *Transaction) OnEnd(f func(committed bool)) {
+	t.endHooks = append(t.endHooks, f)
+}
+
+// end calls the transaction's end hooks, and then passes the changes it
+// made to each table to its listener, if it has one and there are any.
+// Its savepoints are discarded.
+func (t *Transaction) end(committed bool) {
+	t.ended = true
+	t.savepoints = nil
+	hooks := t.endHooks
+	t.endHooks = nil
+	for _, f := range hooks {
+		f(committed)
+	}
+	deltas := t.deltas
+	t.deltas = nil
+	if t.listener != nil && len(deltas) > 0 {
+		t.listener.TablesChanged(deltas, committed)
+	}
+}
+
 func (t *Transaction) AvailableBufs() int {
 	return t.bm.Available()
 }
@@ -123,3 +123,103 @@ func TestTransaction(t *testing.T) {
 		t.Fatalf("Expected int value %d, got %d", newival, ival)
 	}
 }
+
+func TestTransactionSavepoint(t *testing.T) {
+	t.Cleanup(func() {
+		os.RemoveAll("txsavepointtest")
+	})
+
+	db, err := server.NewSimpleDBWithConfig("txsavepointtest", 400, 8)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	blk1 := file.NewBlockID("testfile", 1)
+	blk2 := file.NewBlockID("testfile", 2)
+	tx1, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx1.Pin(blk1); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if err := tx1.SetInt(blk1, 80, 1, true); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+	tx1.Savepoint("sp1")
+	if err := tx1.SetInt(blk1, 80, 2, true); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+	tx1.Savepoint("sp2")
+	available := tx1.AvailableBufs()
+	if err := tx1.Pin(blk2); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if err := tx1.SetString(blk2, 40, "two", true); err != nil {
+		t.Fatalf("Failed to set string: %v", err)
+	}
+	if err := tx1.SetInt(blk1, 80, 3, true); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+
+	// Rolling back to sp2 undoes only the changes made after it, and
+	// unpins the block pinned since then.
+	if err := tx1.RollbackToSavepoint("sp2"); err != nil {
+		t.Fatalf("Failed to roll back to savepoint: %v", err)
+	}
+	if n := tx1.AvailableBufs(); n != available {
+		t.Fatalf("Expected %d available buffers, got %d", available, n)
+	}
+	if ival, err := tx1.GetInt(blk1, 80); err != nil || ival != 2 {
+		t.Fatalf("Expected int value 2, got %d, %v", ival, err)
+	}
+	if err := tx1.Pin(blk2); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if sval, err := tx1.GetString(blk2, 40); err != nil || sval != "" {
+		t.Fatalf("Expected an empty string, got %q, %v", sval, err)
+	}
+
+	// Rolling back to sp1 releases sp2.
+	if err := tx1.RollbackToSavepoint("sp1"); err != nil {
+		t.Fatalf("Failed to roll back to savepoint: %v", err)
+	}
+	if ival, err := tx1.GetInt(blk1, 80); err != nil || ival != 1 {
+		t.Fatalf("Expected int value 1, got %d, %v", ival, err)
+	}
+	if err := tx1.RollbackToSavepoint("sp2"); err == nil {
+		t.Fatal("Expected an error rolling back to a released savepoint")
+	}
+	if err := tx1.SetInt(blk1, 80, 4, true); err != nil {
+		t.Fatalf("Failed to set int: %v", err)
+	}
+	if err := tx1.ReleaseSavepoint("sp1"); err != nil {
+		t.Fatalf("Failed to release savepoint: %v", err)
+	}
+	if err := tx1.ReleaseSavepoint("sp1"); err == nil {
+		t.Fatal("Expected an error releasing an unknown savepoint")
+	}
+	if ival, err := tx1.GetInt(blk1, 80); err != nil || ival != 4 {
+		t.Fatalf("Expected int value 4, got %d, %v", ival, err)
+	}
+
+	// A full rollback still undoes the changes made before the savepoints,
+	// and skips the ones that were already undone.
+	if err := tx1.Rollback(); err != nil {
+		t.Fatalf("Failed to rollback: %v", err)
+	}
+	tx2, err := db.NewTx()
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := tx2.Pin(blk1); err != nil {
+		t.Fatalf("Failed to pin block: %v", err)
+	}
+	if ival, err := tx2.GetInt(blk1, 80); err != nil || ival != 0 {
+		t.Fatalf("Expected int value 0, got %d, %v", ival, err)
+	}
+	if err := tx2.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+}